@@ -2,23 +2,26 @@ package auth
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/breez/server/clientip"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/rpcerror"
 	"github.com/golang-jwt/jwt/v5"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 type providerCtxKeyType string
@@ -58,61 +61,79 @@ func GetHeaderKeys(ctx context.Context) []string {
 	return keys
 }
 
-func AuthenticatedHandler(prefix string, h http.Handler, u *url.URL) func(http.ResponseWriter, *http.Request) {
-	CACertBlock, _ := pem.Decode([]byte(os.Getenv("BREEZ_CA_CERT")))
-	if CACertBlock == nil {
-		log.Fatal("Breez CA cert invalid")
-	}
-	CACert, err := x509.ParseCertificate(CACertBlock.Bytes)
-	if err != nil {
-		log.Fatal("Cannot parse CA cert:", err)
-	}
-
-	rootPool := x509.NewCertPool()
-	rootPool.AddCert(CACert)
+// KeyHash returns a short stable identifier of an API key, usable in logs and
+// storage keys without exposing the key itself.
+func KeyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:16])
+}
 
-	crl := make(map[string]struct{})
+// CertAuthenticator authenticates the http requests by their client
+// certificate, passed as the bearer token and issued by the Breez CA.
+type CertAuthenticator struct {
+	caCert    *x509.Certificate
+	rootPool  *x509.CertPool
+	crl       map[string]struct{}
+	crlList   string
+	jwtSigner *ecdsa.PrivateKey
+}
 
-	for c := range strings.SplitSeq(os.Getenv("BREEZ_CA_CRL"), ",") {
-		crl[c] = struct{}{}
+// NewCertAuthenticator returns an authenticator of the certificates issued by
+// caCert, except the revoked serial numbers of crl. The jwt handler signs its
+// tokens with jwtSigner.
+func NewCertAuthenticator(caCert *x509.Certificate, crl []string, jwtSigner *ecdsa.PrivateKey) *CertAuthenticator {
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(caCert)
+	revoked := make(map[string]struct{}, len(crl))
+	for _, c := range crl {
+		revoked[c] = struct{}{}
+	}
+	return &CertAuthenticator{
+		caCert:    caCert,
+		rootPool:  rootPool,
+		crl:       revoked,
+		crlList:   strings.Join(crl, ","),
+		jwtSigner: jwtSigner,
 	}
+}
 
+func (a *CertAuthenticator) AuthenticatedHandler(prefix string, h http.Handler, u *url.URL) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("authorization")
 		if len(authHeader) < 8 || !strings.HasPrefix(authHeader, "Bearer ") {
-			log.Printf("No bearer data in authorization header: %v", authHeader)
+			log.Printf("[%v] No bearer data in authorization header: %v", clientip.FromContext(r.Context()), logging.Secret(authHeader))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		apiKey := authHeader[7:]
 		block, err := base64.StdEncoding.DecodeString(apiKey)
 		if err != nil {
-			log.Printf("base64.StdEncoding.DecodeString(apiKey) [%v] error: %v", apiKey, err)
+			log.Printf("[%v] base64.StdEncoding.DecodeString(apiKey) [%v] error: %v", clientip.FromContext(r.Context()), logging.Secret(apiKey), err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		cert, err := x509.ParseCertificate(block)
 		if err != nil {
-			log.Printf("Cannot parse cert: %v", err)
+			log.Printf("[%v] Cannot parse cert: %v", clientip.FromContext(r.Context()), err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		chains, err := cert.Verify(x509.VerifyOptions{
-			Roots: rootPool,
+			Roots: a.rootPool,
 		})
 		if err != nil {
-			log.Printf("cert.Verify error: %v", err)
+			log.Printf("[%v] cert.Verify error: %v", clientip.FromContext(r.Context()), err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		if len(chains) != 1 || len(chains[0]) != 2 || !chains[0][0].Equal(cert) || !chains[0][1].Equal(CACert) {
-			log.Printf("cert verification error")
+		if len(chains) != 1 || len(chains[0]) != 2 || !chains[0][0].Equal(cert) || !chains[0][1].Equal(a.caCert) {
+			log.Printf("[%v] cert verification error", clientip.FromContext(r.Context()))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if _, ok := crl[cert.SerialNumber.String()]; ok {
-			log.Printf("certificate revoked: %s", cert.SerialNumber)
+		if _, ok := a.crl[cert.SerialNumber.String()]; ok {
+			log.Printf("[%v] certificate revoked: %s", clientip.FromContext(r.Context()), cert.SerialNumber)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -125,28 +146,21 @@ func AuthenticatedHandler(prefix string, h http.Handler, u *url.URL) func(http.R
 	}
 }
 
-func CRLHandler(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(os.Getenv("BREEZ_CA_CRL")))
+func (a *CertAuthenticator) CRLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(a.crlList))
 }
 
-func JWTHandler(w http.ResponseWriter, r *http.Request) {
+func (a *CertAuthenticator) JWTHandler(w http.ResponseWriter, r *http.Request) {
 	cert := GetCert(r)
 	if cert == nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	keyPEM := os.Getenv("JWT_PRIVATE_KEY")
-	if keyPEM == "" {
+	if a.jwtSigner == nil {
 		log.Printf("JWT_PRIVATE_KEY not set")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(keyPEM))
-	if err != nil {
-		log.Printf("jwt.ParseECPrivateKeyFromPEM error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
 	now := time.Now()
 	claims := jwt.MapClaims{
 		"aud": "spark-so",
@@ -160,7 +174,7 @@ func JWTHandler(w http.ResponseWriter, r *http.Request) {
 		claims["sub"] = fmt.Sprintf("[%s]", cert.SerialNumber)
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
-	signed, err := token.SignedString(privateKey)
+	signed, err := token.SignedString(a.jwtSigner)
 	if err != nil {
 		log.Printf("token.SignedString error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -170,45 +184,78 @@ func JWTHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"token": signed})
 }
 
-func UnaryAuth(prefix, token string) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if !strings.HasPrefix(info.FullMethod, prefix) {
-			return handler(ctx, req)
+// hasToken tells whether the request carries token as its bearer token.
+func hasToken(ctx context.Context, token string) bool {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, auth := range md.Get("authorization") {
+			if auth == "Bearer "+token {
+				return true
+			}
 		}
-		if md, ok := metadata.FromIncomingContext(ctx); ok {
-			for _, auth := range md.Get("authorization") {
-				if auth == "Bearer "+token {
-					return handler(ctx, req)
+	}
+	return false
+}
+
+// tokenProvider returns the provider of the bearer token of the request.
+func tokenProvider(ctx context.Context, tokens map[string]string) (string, bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, auth := range md.Get("authorization") {
+			if strings.HasPrefix(auth, "Bearer ") {
+				if provider, ok := tokens[auth[7:]]; ok {
+					return provider, true
 				}
 			}
 		}
-		return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
 	}
+	return "", false
 }
 
-func UnaryMultiAuth(prefix, jsonTokens string) grpc.UnaryServerInterceptor {
-	tokens := make(map[string]string)
-	err := json.Unmarshal([]byte(jsonTokens), &tokens)
-	if err != nil {
-		log.Printf("json.Unmarshal(%v) error: %v", jsonTokens, err)
-		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-			return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
+func UnaryAuth(prefix, token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) || hasToken(ctx, token) {
+			return handler(ctx, req)
+		}
+		log.Printf("[%v] %v: not authorized", clientip.FromContext(ctx), info.FullMethod)
+		return nil, rpcerror.NotAuthorized()
+	}
+}
+
+func StreamAuth(prefix, token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, prefix) || hasToken(ss.Context(), token) {
+			return handler(srv, ss)
 		}
+		log.Printf("[%v] %v: not authorized", clientip.FromContext(ss.Context()), info.FullMethod)
+		return rpcerror.NotAuthorized()
 	}
+}
+
+// UnaryMultiAuth authorizes the methods starting with prefix for the bearer
+// tokens of the providers in tokens, keyed by token.
+func UnaryMultiAuth(prefix string, tokens map[string]string) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		if !strings.HasPrefix(info.FullMethod, prefix) {
 			return handler(ctx, req)
 		}
-		if md, ok := metadata.FromIncomingContext(ctx); ok {
-			for _, auth := range md.Get("authorization") {
-				if strings.HasPrefix(auth, "Bearer ") {
-					if provider, ok := tokens[auth[7:]]; ok {
-						return handler(context.WithValue(ctx, providerCtxKey, &provider), req)
-					}
-				}
+		if provider, ok := tokenProvider(ctx, tokens); ok {
+			return handler(context.WithValue(ctx, providerCtxKey, &provider), req)
+		}
+		log.Printf("[%v] %v: not authorized", clientip.FromContext(ctx), info.FullMethod)
+		return nil, rpcerror.NotAuthorized()
+	}
+}
 
-			}
+func StreamMultiAuth(prefix string, tokens map[string]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(srv, ss)
+		}
+		if provider, ok := tokenProvider(ss.Context(), tokens); ok {
+			wrapped := grpc_middleware.WrapServerStream(ss)
+			wrapped.WrappedContext = context.WithValue(ss.Context(), providerCtxKey, &provider)
+			return handler(srv, wrapped)
 		}
-		return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
+		log.Printf("[%v] %v: not authorized", clientip.FromContext(ss.Context()), info.FullMethod)
+		return rpcerror.NotAuthorized()
 	}
 }
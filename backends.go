@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/breez/boltz"
+	"github.com/breez/server/bitcoind"
+	"github.com/breez/server/config"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/lsp"
+	"github.com/breez/server/notify"
+	"github.com/breez/server/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// backends are the outside services the server depends on, or their fakes in
+// the -dev mode.
+type backends struct {
+	lnd           *grpc.ClientConn
+	subswapperLND *grpc.ClientConn
+	swapd         *grpc.ClientConn
+	dialLSP       lsp.Dialer
+	notifier      notify.Notifier
+	mailer        mailer
+	blobs         blobStore
+	// senderAddresses returns the addresses spent by the transactions txids.
+	senderAddresses func(txids []string) ([]string, error)
+	// lockupTx returns the lockup transaction of a boltz reverse swap.
+	lockupTx func(boltzID string) (string, error)
+	// handlers are served by the http server, by pattern.
+	handlers map[string]http.Handler
+	close    func()
+}
+
+// dialOptions are the options of the connections to the grpc backends,
+// propagating the request IDs and tracing the calls.
+func dialOptions() []grpc.DialOption {
+	return append(logging.DialOptions(), tracing.DialOptions()...)
+}
+
+// connectBackends connects to the outside services.
+func connectBackends(cfg *config.Config) (*backends, error) {
+	// Creds file to connect to LND gRPC
+	creds := credentials.NewClientTLSFromCert(cfg.LND.Certs, "")
+	// Address of an LND instance
+	conn, err := grpc.Dial(cfg.LND.Address, append(dialOptions(), grpc.WithTransportCredentials(creds))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LND gRPC: %w", err)
+	}
+
+	ssCreds := credentials.NewClientTLSFromCert(cfg.SubswapperLND.Certs, "")
+	// Address of an LND instance
+	subswapConn, err := grpc.Dial(cfg.SubswapperLND.Address, append(dialOptions(), grpc.WithTransportCredentials(ssCreds), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(128*1024*1024)))...)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to the subswapper LND gRPC: %w", err)
+	}
+
+	taprootSwapperConn, err := grpc.Dial(cfg.SwapdAddress, append(dialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		conn.Close()
+		subswapConn.Close()
+		return nil, fmt.Errorf("failed to connect to swapd gRPC: %w", err)
+	}
+
+	notifier, err := newNotifier(cfg)
+	if err != nil {
+		conn.Close()
+		subswapConn.Close()
+		taprootSwapperConn.Close()
+		return nil, err
+	}
+
+	bitcoindClient := bitcoind.NewClient(cfg.Bitcoind.Host, cfg.Bitcoind.Port, cfg.Bitcoind.User, cfg.Bitcoind.Password)
+	return &backends{
+		lnd:             conn,
+		subswapperLND:   subswapConn,
+		swapd:           taprootSwapperConn,
+		dialLSP:         lsp.Dial,
+		notifier:        notifier,
+		mailer:          sesMailer{},
+		blobs:           &gcsBlobStore{credentialsFile: cfg.GoogleCloudServiceFile, bucket: cfg.GoogleCloudImagesBucketName},
+		senderAddresses: bitcoindClient.GetSenderAddresses,
+		lockupTx: func(boltzID string) (string, error) {
+			_, _, tx, _, err := boltz.GetTransaction(boltzID, "", 0)
+			return tx, err
+		},
+		close: func() {
+			conn.Close()
+			subswapConn.Close()
+			taprootSwapperConn.Close()
+		},
+	}, nil
+}
+
+// newNotifier returns the notifier of the configured push notification
+// providers.
+func newNotifier(cfg *config.Config) (*notify.Router, error) {
+	router := &notify.Router{}
+	if len(cfg.GoogleApplicationCredentials) > 0 {
+		fcm, err := notify.NewFCM(context.Background(), cfg.GoogleApplicationCredentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the FCM client: %w", err)
+		}
+		router.FCM = fcm
+	} else {
+		log.Printf("GOOGLE_APPLICATION_CREDENTIALS is not set: the FCM push notifications are disabled")
+	}
+	if cfg.APNs.Key != nil {
+		router.APNs = notify.NewAPNs(cfg.APNs.Key, cfg.APNs.KeyID, cfg.APNs.TeamID, cfg.APNs.Topic,
+			cfg.APNs.Environment == "sandbox")
+	}
+	return router, nil
+}
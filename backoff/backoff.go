@@ -0,0 +1,55 @@
+// Package backoff spaces the retries of the background workers while a
+// backend is down.
+package backoff
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	minDelay = time.Second
+	maxDelay = time.Minute
+)
+
+// Backoff doubles the delay between consecutive failures, from one second up
+// to a minute. The zero value is ready to use.
+type Backoff struct {
+	delay time.Duration
+}
+
+// Wait waits for the next delay. It returns false if ctx is done first.
+func (b *Backoff) Wait(ctx context.Context) bool {
+	if b.delay == 0 {
+		b.delay = minDelay
+	} else {
+		b.delay = min(2*b.delay, maxDelay)
+	}
+	select {
+	case <-time.After(b.delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Reset is called after a success, to retry the next failure quickly.
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
+
+// Retry calls f until it succeeds or ctx is done, and returns its last error.
+func Retry(ctx context.Context, name string, f func(ctx context.Context) error) error {
+	var b Backoff
+	for {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("%v failed, retrying: %v", name, err)
+		if !b.Wait(ctx) {
+			return err
+		}
+	}
+}
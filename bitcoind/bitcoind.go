@@ -3,18 +3,27 @@ package bitcoind
 import (
 	"fmt"
 	"log"
-	"os"
-	"strconv"
 
 	gbitcoind "github.com/toorop/go-bitcoind"
 )
 
-func GetSenderAddresses(destTxs []string) ([]string, error) {
-	bitcoindPort, err := strconv.Atoi(os.Getenv("BITCOIND_PORT"))
-	if err != nil {
-		return nil, fmt.Errorf("no valid port for bitcoind: %v", os.Getenv("BITCOIND_PORT"))
+// Client is the rpc connection settings of bitcoind.
+type Client struct {
+	host     string
+	port     int
+	user     string
+	password string
+}
+
+func NewClient(host string, port int, user, password string) *Client {
+	return &Client{host: host, port: port, user: user, password: password}
+}
+
+func (c *Client) GetSenderAddresses(destTxs []string) ([]string, error) {
+	if c.port == 0 {
+		return nil, fmt.Errorf("no valid port for bitcoind: %v", c.port)
 	}
-	bc, err := gbitcoind.New(os.Getenv("BITCOIND_HOST"), bitcoindPort, os.Getenv("BITCOIND_USER"), os.Getenv("BITCOIND_PASSWORD"), false)
+	bc, err := gbitcoind.New(c.host, c.port, c.user, c.password, false)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create a bitcoind client")
 	}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// gcsBlobStore stores the uploaded files in a public Google Cloud Storage
+// bucket.
+type gcsBlobStore struct {
+	credentialsFile string
+	bucket          string
+}
+
+func (g *gcsBlobStore) Put(ctx context.Context, name string, content []byte) (string, error) {
+	client, err := storage.NewClient(ctx, option.WithCredentialsFile(g.credentialsFile))
+	if err != nil {
+		return "", fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+	obj := client.Bucket(g.bucket).Object(name)
+
+	writer := obj.NewWriter(ctx)
+	if _, err := writer.Write(content); err != nil {
+		return "", fmt.Errorf("writing to the bucket stream: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("closing the bucket stream: %w", err)
+	}
+	if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		return "", fmt.Errorf("setting the read permissions on the object: %w", err)
+	}
+	objAttrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("reading the object attributes: %w", err)
+	}
+	return objAttrs.MediaLink, nil
+}
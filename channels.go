@@ -64,7 +64,11 @@ func subscribeChannelAcceptor(ctx context.Context, c lnrpc.LightningClient, acce
 		if err != nil {
 			log.Println("subscribeTransactions:", err)
 		}
-		time.Sleep(5 * time.Second)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
@@ -0,0 +1,216 @@
+// Package clientip resolves the address of the client behind the load
+// balancers and proxies forwarding its requests, and shares it through the
+// request context.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type ctxKeyType string
+
+const ctxKey ctxKeyType = "clientip"
+
+// NewContext returns a copy of ctx carrying the client ip.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey, ip)
+}
+
+// FromContext returns the client ip resolved by the interceptor or the
+// middleware of a Resolver, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey).(string)
+	return ip
+}
+
+// The forwarding headers a Resolver reads.
+const (
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderForwarded     = "forwarded"
+)
+
+// Resolver finds the client ip of a request from the peer address and the
+// forwarding header set by the trusted proxies, X-Forwarded-For or
+// Forwarded. Only the hops added by trusted proxies are believed: the
+// forwarding chain is read from right to left and the first address which is
+// not a trusted proxy is the client. The other header is ignored, as the
+// proxies pass it from the client untouched.
+type Resolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewResolver returns a resolver trusting the proxies in the given networks,
+// which set header, HeaderXForwardedFor or HeaderForwarded. A network is
+// either a CIDR or a single ip address.
+func NewResolver(trusted []string, header string) (*Resolver, error) {
+	header = strings.ToLower(header)
+	if header != HeaderXForwardedFor && header != HeaderForwarded {
+		return nil, fmt.Errorf("invalid forwarding header %q", header)
+	}
+	r := &Resolver{header: header}
+	for _, t := range trusted {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", t)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q: %w", t, err)
+		}
+		r.trusted = append(r.trusted, n)
+	}
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, n := range r.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client of a request received from remote, given the
+// values of the forwarding header of the resolver, or "" when remote is
+// unknown. The client is an ip address, or the hop of the chain following
+// the last trusted proxy when it is not an address, such as "unknown" or an
+// obfuscated identifier of the Forwarded header: the clients behind the
+// proxy are not all identified by its address.
+func (r *Resolver) Resolve(remote net.IP, values []string) string {
+	if remote == nil {
+		return ""
+	}
+	var hops []string
+	if r.header == HeaderForwarded {
+		hops = forwardedFor(values)
+	} else {
+		for _, h := range values {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0 && r.isTrusted(client); i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			return hopID(hops[i])
+		}
+		client = ip
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers.
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, h := range headers {
+		for _, element := range strings.Split(h, ",") {
+			var hop string
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hop = v
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop parses one hop of a forwarding chain: an ip address, optionally
+// quoted, with brackets and a port.
+func parseHop(hop string) net.IP {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+// hopID returns the identifier of a hop which is not an address.
+func hopID(hop string) string {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if hop == "" {
+		return "unknown"
+	}
+	return hop
+}
+
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	switch addr := p.Addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+func (r *Resolver) resolveGRPC(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	client := r.Resolve(peerIP(ctx), md.Get(r.header))
+	if client == "" {
+		return ctx
+	}
+	return NewContext(ctx, client)
+}
+
+// UnaryServerInterceptor puts the client ip in the context of every request.
+// It has to come first in the interceptor chain.
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(r.resolveGRPC(ctx), req)
+	}
+}
+
+// StreamServerInterceptor puts the client ip in the context of every stream.
+// It has to come first in the interceptor chain.
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = r.resolveGRPC(ss.Context())
+		return handler(srv, wrapped)
+	}
+}
+
+// Middleware puts the client ip in the context of every http request.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		client := r.Resolve(net.ParseIP(host), req.Header.Values(r.header))
+		if client != "" {
+			req = req.WithContext(NewContext(req.Context(), client))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
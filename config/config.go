@@ -0,0 +1,544 @@
+// Package config loads the configuration of the server from the environment
+// and an optional YAML file, and validates it once at startup.
+//
+// Every setting is named after its environment variable. The file named by
+// CONFIG_FILE maps the same names to their values, and the environment
+// overrides it. Any setting can also be read from the file named by its
+// variable with a _FILE suffix (LND_MACAROON_HEX_FILE for example), to pass
+// the secrets and certificates mounted as files.
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breez/server/breez"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// LND is the connection to an lnd node.
+type LND struct {
+	Address         string
+	Certs           *x509.CertPool
+	MacaroonHex     string
+	ChannelAcceptor string
+}
+
+// Email is the recipients and sender of a notification email.
+type Email struct {
+	To   string
+	Cc   string
+	From string
+}
+
+// Bitcoind is the rpc connection to bitcoind.
+type Bitcoind struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+// APNs is the direct connection to the Apple Push Notification service, with
+// token based authentication.
+type APNs struct {
+	// Key is the .p8 signing key of KeyID, of the team TeamID.
+	Key    *ecdsa.PrivateKey
+	KeyID  string
+	TeamID string
+	// Topic is the bundle ID of the app.
+	Topic string
+	// Environment is production or sandbox.
+	Environment string
+}
+
+// Tracing is the export of the OpenTelemetry traces.
+type Tracing struct {
+	// Exporter is none, otlp or stdout.
+	Exporter string
+	// Endpoint is the OTLP grpc endpoint of the collector.
+	Endpoint    *url.URL
+	SampleRatio float64
+}
+
+// Config is the configuration of the server.
+type Config struct {
+	// Dev is set by LoadDev: the outside services are replaced by the fakes.
+	Dev              bool
+	DevBlobDirectory string
+
+	Network *chaincfg.Params
+
+	LogLevel  slog.Level
+	LogFormat string
+	Tracing   Tracing
+
+	GRPCListenAddress    string
+	HTTPListenAddress    string
+	MetricsListenAddress string
+	ShutdownTimeout      time.Duration
+	TrustedProxies       []string
+	// TrustedProxyHeader is the forwarding header set by the trusted
+	// proxies: x-forwarded-for or forwarded.
+	TrustedProxyHeader string
+
+	RateLimitPolicyFile string
+	RateLimitBackend    string
+
+	RedisURL    string
+	RedisDB     int
+	DatabaseURL string
+
+	LND           LND
+	SubswapperLND LND
+	SwapdAddress  string
+	Bitcoind      Bitcoind
+
+	LiquidEsploraAPIBaseURL *url.URL
+	ChainAPIServers         []*breez.ChainApiServersReply_ChainAPIServer
+
+	StaticFilesDirectory      string
+	StaticFilesPrefix         string
+	StaticFilesAuthentication string
+
+	BreezCACert           *x509.Certificate
+	BreezCACRL            []string
+	JWTPrivateKey         *ecdsa.PrivateKey
+	PublicChannelTokens   map[string]string
+	InactiveNotifierToken string
+
+	GoogleApplicationCredentials []byte
+	APNs                         APNs
+	GoogleCloudServiceFile       string
+	GoogleCloudImagesBucketName  string
+
+	CardNotification           Email
+	PaymentFailureNotification Email
+
+	LSPConfig              string
+	ReceiverNode           string
+	ReverseSwapRoutingNode []byte
+	MoonPaySecret          string
+	OrchestraBaseURL       string
+	OrchestraAPIKey        string
+}
+
+// devDefaults are the defaults of the -dev mode, for the services running
+// locally.
+var devDefaults = map[string]string{
+	"NETWORK":                "regtest",
+	"LOG_FORMAT":             "text",
+	"GRPC_LISTEN_ADDRESS":    "localhost:50051",
+	"HTTP_LISTEN_ADDRESS":    "localhost:8080",
+	"METRICS_LISTEN_ADDRESS": "localhost:9090",
+	"REDIS_URL":              "localhost:6379",
+	"DATABASE_URL":           "postgres://localhost:5432/breez?sslmode=disable",
+	"DEV_BLOB_DIRECTORY":     "dev-blobs",
+	// The liquid handlers need a boltz swapper, which is not faked.
+	"CHAIN_API_SERVERS": `[{"server_type": "BOLTZ_SWAPPER", "server_base_url": "http://localhost:9001/"}]`,
+}
+
+// Load loads the configuration from the environment and the file named by
+// CONFIG_FILE. The error lists every invalid or missing setting.
+func Load() (*Config, error) {
+	l, err := newLoader()
+	if err != nil {
+		return nil, err
+	}
+	return l.load()
+}
+
+// LoadDev loads the configuration of the -dev mode. The settings of the
+// services replaced by fakes are optional, and the local services have
+// defaults.
+func LoadDev() (*Config, error) {
+	l, err := newLoader()
+	if err != nil {
+		return nil, err
+	}
+	l.dev = true
+	return l.load()
+}
+
+func (l *loader) load() (*Config, error) {
+	c := &Config{
+		Dev:              l.dev,
+		DevBlobDirectory: l.string("DEV_BLOB_DIRECTORY"),
+
+		Network: l.network("NETWORK"),
+
+		LogLevel:  l.logLevel("LOG_LEVEL"),
+		LogFormat: l.oneOf("LOG_FORMAT", "json", "text"),
+		Tracing: Tracing{
+			Exporter:    l.oneOf("OTEL_TRACES_EXPORTER", "none", "otlp", "stdout"),
+			Endpoint:    l.url("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			SampleRatio: l.ratio("OTEL_TRACES_SAMPLER_ARG", 1),
+		},
+
+		GRPCListenAddress:    l.required("GRPC_LISTEN_ADDRESS"),
+		HTTPListenAddress:    l.required("HTTP_LISTEN_ADDRESS"),
+		MetricsListenAddress: l.string("METRICS_LISTEN_ADDRESS"),
+		ShutdownTimeout:      l.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		TrustedProxies:       l.list("TRUSTED_PROXIES"),
+		TrustedProxyHeader:   l.oneOf("TRUSTED_PROXY_HEADER", "x-forwarded-for", "forwarded"),
+
+		RateLimitPolicyFile: l.string("RATE_LIMIT_POLICY_FILE"),
+		RateLimitBackend:    l.string("RATE_LIMIT_BACKEND"),
+
+		RedisURL:    l.required("REDIS_URL"),
+		RedisDB:     l.int("REDIS_DB", 0),
+		DatabaseURL: l.required("DATABASE_URL"),
+
+		LND:           l.lnd("LND_"),
+		SubswapperLND: l.lnd("SUBSWAPPER_LND_"),
+		SwapdAddress:  l.string("SWAPD_ADDRESS"),
+		Bitcoind: Bitcoind{
+			Host:     l.string("BITCOIND_HOST"),
+			Port:     l.int("BITCOIND_PORT", 0),
+			User:     l.string("BITCOIND_USER"),
+			Password: l.string("BITCOIND_PASSWORD"),
+		},
+
+		LiquidEsploraAPIBaseURL: l.url("LIQUID_ESPLORA_API_BASE_URL"),
+
+		StaticFilesDirectory:      l.string("STATIC_FILES_DIRECTORY"),
+		StaticFilesPrefix:         l.string("STATIC_FILES_PREFIX"),
+		StaticFilesAuthentication: l.string("STATIC_FILES_AUTHENTICATION"),
+
+		BreezCACert:           l.certificate("BREEZ_CA_CERT"),
+		BreezCACRL:            l.list("BREEZ_CA_CRL"),
+		JWTPrivateKey:         l.ecPrivateKey("JWT_PRIVATE_KEY"),
+		InactiveNotifierToken: l.string("INACTIVE_NOTIFIER_TOKEN"),
+
+		GoogleApplicationCredentials: []byte(l.string("GOOGLE_APPLICATION_CREDENTIALS")),
+		GoogleCloudServiceFile:       l.string("GOOGLE_CLOUD_SERVICE_FILE"),
+		GoogleCloudImagesBucketName:  l.string("GOOGLE_CLOUD_IMAGES_BUCKET_NAME"),
+		APNs: APNs{
+			Key:         l.ecPrivateKey("APNS_KEY"),
+			KeyID:       l.string("APNS_KEY_ID"),
+			TeamID:      l.string("APNS_TEAM_ID"),
+			Topic:       l.string("APNS_TOPIC"),
+			Environment: l.oneOf("APNS_ENVIRONMENT", "production", "sandbox"),
+		},
+
+		CardNotification:           l.email("CARD_NOTIFICATION_"),
+		PaymentFailureNotification: l.email("PAYMENT_FAILURE_NOTIFICATION_"),
+
+		LSPConfig:              l.string("LSP_CONFIG"),
+		ReceiverNode:           l.string("RECEIVER_NODE"),
+		ReverseSwapRoutingNode: l.hex("REVERSE_SWAP_ROUTING_NODE"),
+		MoonPaySecret:          l.string("MOONPAY_SECRET"),
+		OrchestraBaseURL:       l.string("ORCHESTRA_BASE_URL"),
+		OrchestraAPIKey:        l.string("ORCHESTRA_API_KEY"),
+	}
+	if proxyAddress := l.string("PROXY_ADDRESS"); proxyAddress != "" {
+		// PROXY_ADDRESS is the single trusted proxy of older configurations.
+		c.TrustedProxies = append(c.TrustedProxies, proxyAddress)
+	}
+	if c.Tracing.Endpoint.Host == "" {
+		c.Tracing.Endpoint = &url.URL{Scheme: "http", Host: "localhost:4317"}
+	}
+	l.json("CHAIN_API_SERVERS", &c.ChainAPIServers)
+	l.json("PUBLIC_CHANNEL_TOKENS", &c.PublicChannelTokens)
+	if lspConfig := c.LSPConfig; lspConfig != "" && !json.Valid([]byte(lspConfig)) {
+		l.fail("LSP_CONFIG", errors.New("invalid json"))
+	}
+	if len(c.GoogleApplicationCredentials) > 0 && !json.Valid(c.GoogleApplicationCredentials) {
+		l.fail("GOOGLE_APPLICATION_CREDENTIALS", errors.New("invalid json"))
+	}
+	if c.APNs.Key != nil && (c.APNs.KeyID == "" || c.APNs.TeamID == "" || c.APNs.Topic == "") {
+		l.fail("APNS_KEY", errors.New("APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC are required"))
+	}
+
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DatabaseURL loads only DATABASE_URL, for the commands which do not run the
+// server. dev selects the default of the -dev mode.
+func DatabaseURL(dev bool) (string, error) {
+	l, err := newLoader()
+	if err != nil {
+		return "", err
+	}
+	l.dev = dev
+	databaseURL := l.required("DATABASE_URL")
+	return databaseURL, errors.Join(l.errs...)
+}
+
+// Redis loads only REDIS_URL and REDIS_DB, for the commands which do not run
+// the server. dev selects the default of the -dev mode.
+func Redis(dev bool) (string, int, error) {
+	l, err := newLoader()
+	if err != nil {
+		return "", 0, err
+	}
+	l.dev = dev
+	address, db := l.required("REDIS_URL"), l.int("REDIS_DB", 0)
+	return address, db, errors.Join(l.errs...)
+}
+
+// loader reads the settings, collecting the errors so that they are all
+// reported at once.
+type loader struct {
+	lookup func(string) (string, bool)
+	file   map[string]string
+	errs   []error
+	// dev is set in the -dev mode.
+	dev bool
+}
+
+func newLoader() (*loader, error) {
+	l := &loader{lookup: os.LookupEnv}
+	if name := os.Getenv("CONFIG_FILE"); name != "" {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile(%v): %w", name, err)
+		}
+		if err := yaml.Unmarshal(data, &l.file); err != nil {
+			return nil, fmt.Errorf("yaml.Unmarshal(%v): %w", name, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *loader) fail(name string, err error) {
+	l.errs = append(l.errs, fmt.Errorf("%v: %w", name, err))
+}
+
+// string returns the value of the setting name: the environment variable,
+// the file named by the _FILE variable, or the config file, in that order.
+func (l *loader) string(name string) string {
+	sources := []func(string) (string, bool){
+		l.lookup,
+		func(name string) (string, bool) {
+			v, ok := l.file[name]
+			return v, ok
+		},
+	}
+	for _, lookup := range sources {
+		if v, ok := lookup(name); ok && v != "" {
+			return v
+		}
+		if path, ok := lookup(name + "_FILE"); ok && path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				l.fail(name+"_FILE", err)
+				return ""
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+	}
+	if l.dev {
+		return devDefaults[name]
+	}
+	return ""
+}
+
+func (l *loader) required(name string) string {
+	v := l.string(name)
+	if v == "" {
+		l.fail(name, errors.New("required"))
+	}
+	return v
+}
+
+func (l *loader) int(name string, def int) int {
+	v := l.string(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		l.fail(name, fmt.Errorf("invalid integer %q", v))
+		return def
+	}
+	return i
+}
+
+func (l *loader) duration(name string, def time.Duration) time.Duration {
+	v := l.string(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.fail(name, fmt.Errorf("invalid duration %q", v))
+		return def
+	}
+	return d
+}
+
+// ratio returns the value between 0 and 1 of a setting.
+func (l *loader) ratio(name string, def float64) float64 {
+	v := l.string(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		l.fail(name, fmt.Errorf("invalid ratio %q", v))
+		return def
+	}
+	return f
+}
+
+// oneOf returns the value of a setting restricted to values, defaulting to
+// the first one.
+func (l *loader) oneOf(name string, values ...string) string {
+	v := l.string(name)
+	if v == "" {
+		return values[0]
+	}
+	if !slices.Contains(values, v) {
+		l.fail(name, fmt.Errorf("invalid value %q, expected one of %v", v, strings.Join(values, ", ")))
+		return values[0]
+	}
+	return v
+}
+
+// logLevel returns the debug, info, warn or error level of a setting,
+// defaulting to info.
+func (l *loader) logLevel(name string) slog.Level {
+	var level slog.Level
+	if v := l.string(name); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			l.fail(name, fmt.Errorf("invalid level %q", v))
+		}
+	}
+	return level
+}
+
+// list returns the comma separated values of a setting.
+func (l *loader) list(name string) []string {
+	var values []string
+	for v := range strings.SplitSeq(l.string(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (l *loader) hex(name string) []byte {
+	v := l.string(name)
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		l.fail(name, fmt.Errorf("invalid hex %q", v))
+	}
+	return b
+}
+
+func (l *loader) url(name string) *url.URL {
+	v := l.string(name)
+	u, err := url.Parse(v)
+	if err != nil {
+		l.fail(name, err)
+		return &url.URL{}
+	}
+	if v != "" && (u.Scheme == "" || u.Host == "") {
+		l.fail(name, fmt.Errorf("invalid url %q", v))
+	}
+	return u
+}
+
+func (l *loader) json(name string, v any) {
+	data := l.string(name)
+	if data == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		l.fail(name, err)
+	}
+}
+
+// pem returns a PEM setting. The newlines of PEM values passed in one line
+// of an env file are escaped as \n.
+func (l *loader) pem(name string) []byte {
+	return []byte(strings.ReplaceAll(l.string(name), `\n`, "\n"))
+}
+
+func (l *loader) certificate(name string) *x509.Certificate {
+	data := l.pem(name)
+	if len(data) == 0 {
+		if !l.dev {
+			l.fail(name, errors.New("required"))
+		}
+		return nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		l.fail(name, errors.New("no PEM data"))
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		l.fail(name, err)
+		return nil
+	}
+	return cert
+}
+
+func (l *loader) certPool(name string) *x509.CertPool {
+	data := l.pem(name)
+	if len(data) == 0 {
+		l.fail(name, errors.New("required"))
+		return nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		l.fail(name, errors.New("no valid certificate"))
+		return nil
+	}
+	return pool
+}
+
+func (l *loader) ecPrivateKey(name string) *ecdsa.PrivateKey {
+	data := l.pem(name)
+	if len(data) == 0 {
+		return nil
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		l.fail(name, err)
+		return nil
+	}
+	return key
+}
+
+func (l *loader) lnd(prefix string) LND {
+	if l.dev {
+		// lnd is faked.
+		return LND{ChannelAcceptor: l.string(prefix + "CHANNEL_ACCEPTOR")}
+	}
+	return LND{
+		Address:         l.required(prefix + "ADDRESS"),
+		Certs:           l.certPool(prefix + "CERT"),
+		MacaroonHex:     l.required(prefix + "MACAROON_HEX"),
+		ChannelAcceptor: l.string(prefix + "CHANNEL_ACCEPTOR"),
+	}
+}
+
+func (l *loader) email(prefix string) Email {
+	return Email{
+		To:   l.string(prefix + "TO"),
+		Cc:   l.string(prefix + "CC"),
+		From: l.string(prefix + "FROM"),
+	}
+}
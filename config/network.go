@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestNet4Params are the parameters of testnet4 (BIP94), which btcd does not
+// define yet. Its addresses and invoices use the same prefixes as testnet3.
+var TestNet4Params = testNet4Params()
+
+func testNet4Params() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = "testnet4"
+	params.Net = wire.BitcoinNet(0x283f161c)
+	params.DefaultPort = "48333"
+	params.DNSSeeds = nil
+	params.GenesisBlock = nil
+	params.GenesisHash = mustHash("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
+	params.Checkpoints = nil
+	return params
+}
+
+func mustHash(s string) *chainhash.Hash {
+	h, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// networks are the accepted values of NETWORK.
+var networks = map[string]*chaincfg.Params{
+	"":         &chaincfg.MainNetParams,
+	"mainnet":  &chaincfg.MainNetParams,
+	"testnet":  &chaincfg.TestNet3Params,
+	"testnet3": &chaincfg.TestNet3Params,
+	"testnet4": &TestNet4Params,
+	"signet":   &chaincfg.SigNetParams,
+	"regtest":  &chaincfg.RegressionNetParams,
+	"simnet":   &chaincfg.SimNetParams,
+}
+
+func (l *loader) network(name string) *chaincfg.Params {
+	v := l.string(name)
+	params, ok := networks[v]
+	if !ok {
+		l.fail(name, fmt.Errorf("unknown network %q", v))
+		return &chaincfg.MainNetParams
+	}
+	return params
+}
@@ -0,0 +1,182 @@
+// Package ctp implements the Connect To Pay sessions, through which a payer
+// and a payee exchange a payment.
+package ctp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/breez/server/breez"
+	"github.com/breez/server/i18n"
+	"github.com/breez/server/notify"
+	"github.com/breez/server/rpcerror"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	ctpSessionTTL = 3600 * 24 // one day
+)
+
+var (
+	notifyMessages = map[string]map[string]i18n.Message{
+		"payer": map[string]i18n.Message{"body": i18n.CTPPayerJoinedBody, "title": i18n.CTPPayerJoinedTitle},
+		"payee": map[string]i18n.Message{"body": i18n.CTPPayeeJoinedBody, "title": i18n.CTPPayeeJoinedTitle},
+	}
+	notifyTypes = map[string]string{
+		"payer": notify.TypeCTPPayerJoined,
+		"payee": notify.TypeCTPPayeeJoined,
+	}
+)
+
+// Store is the storage of the sessions.
+type Store interface {
+	UpdateKeyFields(key string, fields map[string]string) error
+	GetKeyFields(key string) (map[string]string, error)
+	KeyExists(key string) (bool, error)
+	SetKeyExpiration(key string, seconds int64) error
+	GetKeyExpiration(key string) (int64, error)
+	DeleteKey(key string) error
+	// IndexToken records the token of a party, in a field of a session, so
+	// that it is purged with the token.
+	IndexToken(token, key, member string) error
+}
+
+// Outbox enqueues the push notifications to the parties.
+type Outbox interface {
+	EnqueueAlert(source string, a notify.Alert, token string) error
+}
+
+// Localizer renders the texts of the notifications in the language of the
+// device of the token.
+type Localizer interface {
+	Render(token string, id i18n.Message, args ...any) string
+}
+
+// Server implements breez.CTPServer.
+type Server struct {
+	breez.UnimplementedCTPServer
+	store     Store
+	outbox    Outbox
+	localizer Localizer
+}
+
+func NewServer(store Store, outbox Outbox, localizer Localizer) *Server {
+	return &Server{store: store, outbox: outbox, localizer: localizer}
+}
+
+// JoinCTPSession is used by both payer/payee to join a CTP session.
+func (s *Server) JoinCTPSession(ctx context.Context, in *breez.JoinCTPSessionRequest) (*breez.JoinCTPSessionResponse, error) {
+	sessionID, expiry, err := s.joinSession(in.SessionID, in.NotificationToken, in.PartyName, in.PartyType == breez.JoinCTPSessionRequest_PAYER)
+	if err != nil {
+		return nil, err
+	}
+	return &breez.JoinCTPSessionResponse{SessionID: sessionID, Expiry: expiry}, nil
+}
+
+func (s *Server) TerminateCTPSession(ctx context.Context, in *breez.TerminateCTPSessionRequest) (*breez.TerminateCTPSessionResponse, error) {
+	err := s.terminateSession(in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &breez.TerminateCTPSessionResponse{}, nil
+}
+
+// joinSession is used by both payer/payee to join a CTP session.
+// If the sessionID parameter is given then this function looks for an existing session.
+// If the sessionID parameter is not given then this function creates a new session.
+// Every session that is created is removed automatically after "ctpSessionTTL" in seconds.
+func (s *Server) joinSession(existingSessionID, partyToken, partyName string, payer bool) (string, int64, error) {
+	partyType := "payer"
+	otherParty := "payee"
+	if !payer {
+		partyType = "payee"
+		otherParty = "payer"
+	}
+	sessionID := existingSessionID
+
+	slog.Debug("joinSession", "party_type", partyType, "party_token", partyToken, "other_party", otherParty, "session_id", sessionID)
+	//if we didn't get session id we are asked to create a new session.
+	if sessionID == "" {
+		sessionID = uuid.New().String() //generte
+	}
+	redisSessionKey := fmt.Sprintf("ctp-session-%v", sessionID)
+
+	//if got session id we are asked to join an existing session.
+	//We are going to validate that the session exists and not expired.
+	if existingSessionID != "" {
+		sessionExists, err := s.store.KeyExists(redisSessionKey)
+		if err != nil {
+			slog.Error("joinSession: KeyExists failed", "session_id", sessionID, "error", err)
+			return "", 0, err
+		}
+		if !sessionExists {
+			slog.Info("joinSession: session doesn't exist or expired", "session_id", sessionID)
+			return "", 0, rpcerror.New(codes.NotFound, rpcerror.ReasonSessionNotFound,
+				fmt.Sprintf("Session %v does not exist or expired", sessionID))
+		}
+	}
+
+	partyTokenKey := fmt.Sprintf("ctp-token-%v", partyType)
+
+	err := s.store.UpdateKeyFields(redisSessionKey, map[string]string{
+		partyTokenKey: partyToken,
+		partyName:     partyName,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if partyToken != "" {
+		if err := s.store.IndexToken(partyToken, redisSessionKey, partyTokenKey); err != nil {
+			return "", 0, err
+		}
+	}
+
+	//if we just created a new session, put expiration on it
+	//so it will be removed automaticaly
+	if existingSessionID == "" {
+		s.store.SetKeyExpiration(redisSessionKey, ctpSessionTTL)
+	}
+
+	//notify other party about the new user joined the session
+	fields, err := s.store.GetKeyFields(redisSessionKey)
+	if err != nil {
+		slog.Error("joinSession: GetKeyFields failed", "session_id", sessionID, "error", err)
+		return "", 0, err
+	}
+	otherPartyTokenKey := fmt.Sprintf("ctp-token-%v", otherParty)
+	otherPartyToken := fields[otherPartyTokenKey]
+	slog.Debug("joinSession", "session_id", sessionID, "other_party_token", otherPartyToken)
+	if otherPartyToken != "" {
+		s.notifyOtherParty(sessionID, partyType, partyName, otherPartyToken)
+	}
+	ttl, err := s.store.GetKeyExpiration(redisSessionKey)
+	if err != nil {
+		return "", 0, err
+	}
+
+	expiry := time.Now().Add(time.Second * time.Duration(ttl))
+	return sessionID, expiry.Unix(), nil
+}
+
+func (s *Server) terminateSession(sessionID string) error {
+	return s.store.DeleteKey(fmt.Sprintf("ctp-session-%v", sessionID))
+}
+
+func (s *Server) notifyOtherParty(sessionID, joinedPartyType, joinedPartyName, sendToToken string) {
+	data := map[string]string{
+		"msg": fmt.Sprintf("{\"CTPSessionID\": \"%v\"}", sessionID),
+	}
+
+	err := s.outbox.EnqueueAlert("ctp", notify.Alert{
+		Type:  notifyTypes[joinedPartyType],
+		Title: s.localizer.Render(sendToToken, notifyMessages[joinedPartyType]["title"]),
+		Body:  s.localizer.Render(sendToToken, notifyMessages[joinedPartyType]["body"], joinedPartyName),
+		Data:  data,
+	}, sendToToken)
+	if err != nil {
+		slog.Error("notifyOtherParty: EnqueueAlert failed", "session_id", sessionID, "error", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/breez/server/config"
+	"github.com/breez/server/fake"
+	"github.com/breez/server/notify"
+	"google.golang.org/grpc"
+)
+
+const devBlobsPrefix = "/dev/blobs/"
+
+// fakeBackends starts the fakes replacing the outside services in the -dev
+// mode. Besides the fakes, the http server serves:
+//
+//	GET /dev/messages       the push notifications and emails recorded
+//	GET /dev/blobs/...      the uploaded files
+//	POST /dev/deposit       simulates a deposit of amount sats to a swap address
+func fakeBackends(cfg *config.Config) (*backends, error) {
+	fakes, err := fake.NewServer(cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := fakes.Dial()
+	if err != nil {
+		fakes.Stop()
+		return nil, fmt.Errorf("failed to connect to the fakes: %w", err)
+	}
+	blobs, err := fake.NewBlobs(cfg.DevBlobDirectory, "http://"+cfg.HTTPListenAddress+devBlobsPrefix)
+	if err != nil {
+		conn.Close()
+		fakes.Stop()
+		return nil, fmt.Errorf("fake.NewBlobs: %w", err)
+	}
+	recorder := &notify.Recorder{}
+	return &backends{
+		lnd:           conn,
+		subswapperLND: conn,
+		swapd:         conn,
+		dialLSP: func(server string, noTLS bool) (grpc.ClientConnInterface, error) {
+			return conn, nil
+		},
+		notifier: recorder,
+		mailer:   recorder,
+		blobs:    blobs,
+		senderAddresses: func(txids []string) ([]string, error) {
+			return nil, nil
+		},
+		lockupTx: fakes.LockupTx,
+		handlers: map[string]http.Handler{
+			"GET /dev/messages":     recorder,
+			"GET " + devBlobsPrefix: http.StripPrefix(devBlobsPrefix, blobs),
+			"POST /dev/deposit":     depositHandler(fakes),
+		},
+		close: func() {
+			conn.Close()
+			fakes.Stop()
+		},
+	}, nil
+}
+
+// applyDevDefaults sets the settings of the -dev mode which cannot have a
+// default in the config package.
+func applyDevDefaults(cfg *config.Config) error {
+	if cfg.LSPConfig == "" {
+		cfg.LSPConfig = fake.LSPConfig
+	}
+	if cfg.BreezCACert == nil {
+		cert, err := fake.NewCACertificate()
+		if err != nil {
+			return fmt.Errorf("fake.NewCACertificate: %w", err)
+		}
+		cfg.BreezCACert = cert
+	}
+	return nil
+}
+
+// depositHandler simulates a deposit to the address form value of the amount
+// form value, in sats.
+func depositHandler(fakes *fake.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid amount", http.StatusBadRequest)
+			return
+		}
+		txid, err := fakes.Deposit(r.FormValue("address"), amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]string{"txid": txid}); err != nil {
+			log.Printf("deposit: %v", err)
+		}
+	})
+}
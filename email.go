@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"html/template"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/breez/server/breez"
+	"github.com/breez/server/config"
 )
 
 const (
@@ -24,7 +24,10 @@ func addresses(a string) (addr []*string) {
 	return
 }
 
-func sendEmail(to, cc, from, content, subject string) error {
+// sesMailer sends the emails with Amazon SES.
+type sesMailer struct{}
+
+func (sesMailer) SendEmail(to, cc, from, content, subject string) error {
 
 	sess, err := session.NewSession(&aws.Config{})
 	if err != nil {
@@ -79,7 +82,7 @@ func sendEmail(to, cc, from, content, subject string) error {
 	return nil
 }
 
-func sendCardOrderNotification(in *breez.OrderRequest) error {
+func sendCardOrderNotification(m mailer, recipients config.Email, in *breez.OrderRequest) error {
 
 	var html bytes.Buffer
 
@@ -101,10 +104,10 @@ func sendCardOrderNotification(in *breez.OrderRequest) error {
 		return err
 	}
 
-	err = sendEmail(
-		os.Getenv("CARD_NOTIFICATION_TO"),
-		os.Getenv("CARD_NOTIFICATION_CC"),
-		os.Getenv("CARD_NOTIFICATION_FROM"),
+	err = m.SendEmail(
+		recipients.To,
+		recipients.Cc,
+		recipients.From,
 		html.String(),
 		"Card Order",
 	)
@@ -116,7 +119,7 @@ func sendCardOrderNotification(in *breez.OrderRequest) error {
 	return nil
 }
 
-func sendPaymentFailureNotification(in *breez.ReportPaymentFailureRequest, keys []string) error {
+func sendPaymentFailureNotification(m mailer, recipients config.Email, in *breez.ReportPaymentFailureRequest, keys []string) error {
 	var html bytes.Buffer
 
 	tpl := `
@@ -152,10 +155,10 @@ func sendPaymentFailureNotification(in *breez.ReportPaymentFailureRequest, keys
 		return err
 	}
 
-	err = sendEmail(
-		os.Getenv("PAYMENT_FAILURE_NOTIFICATION_TO"),
-		os.Getenv("PAYMENT_FAILURE_NOTIFICATION_CC"),
-		os.Getenv("PAYMENT_FAILURE_NOTIFICATION_FROM"),
+	err = m.SendEmail(
+		recipients.To,
+		recipients.Cc,
+		recipients.From,
 		html.String(),
 		"Payment Failure",
 	)
@@ -0,0 +1,47 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Blobs stores the uploaded files in a local directory instead of a bucket.
+// ServeHTTP serves them, and must be mounted at baseURL.
+type Blobs struct {
+	dir     string
+	baseURL string
+	files   http.Handler
+}
+
+// NewBlobs creates dir if needed.
+func NewBlobs(dir, baseURL string) (*Blobs, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Blobs{dir: dir, baseURL: baseURL, files: http.FileServer(http.Dir(dir))}, nil
+}
+
+// Put stores content under the slash separated path name and returns its
+// URL.
+func (b *Blobs) Put(ctx context.Context, name string, content []byte) (string, error) {
+	path := filepath.FromSlash(name)
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+	path = filepath.Join(b.dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return "", err
+	}
+	return url.JoinPath(b.baseURL, name)
+}
+
+func (b *Blobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,125 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const startHeight = 1000
+
+// chain is the fake blockchain shared by the fakes. Every transaction is
+// mined in a new block.
+type chain struct {
+	network *chaincfg.Params
+	// rawTx is the transaction returned for every confirmation.
+	rawTx []byte
+
+	mu           sync.Mutex
+	height       uint32
+	transactions []*lnrpc.Transaction
+	txFeed       feed[*lnrpc.Transaction]
+	blockFeed    feed[uint32]
+}
+
+func newChain(network *chaincfg.Params) *chain {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(0, nil))
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		panic(err)
+	}
+	return &chain{network: network, rawTx: buf.Bytes(), height: startHeight}
+}
+
+func (c *chain) blockHeight() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.height
+}
+
+// blockHash returns the fake hash of the block at height.
+func blockHash(height uint32) []byte {
+	h := sha256.Sum256(binary.BigEndian.AppendUint32(nil, height))
+	return h[:]
+}
+
+// mine mines a block with a transaction paying amount to address, and
+// returns it.
+func (c *chain) mine(address string, amount int64) *lnrpc.Transaction {
+	c.mu.Lock()
+	c.height++
+	tx := &lnrpc.Transaction{
+		TxHash:           hex.EncodeToString(randomBytes(32)),
+		Amount:           amount,
+		NumConfirmations: 1,
+		BlockHash:        hex.EncodeToString(blockHash(c.height)),
+		BlockHeight:      int32(c.height),
+		TimeStamp:        time.Now().Unix(),
+		DestAddresses:    []string{address},
+	}
+	c.transactions = append(c.transactions, tx)
+	height := c.height
+	c.mu.Unlock()
+
+	c.blockFeed.publish(height)
+	c.txFeed.publish(tx)
+	return tx
+}
+
+// transactionsFrom returns the transactions mined from startHeight.
+func (c *chain) transactionsFrom(startHeight int32) []*lnrpc.Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var txs []*lnrpc.Transaction
+	for _, tx := range c.transactions {
+		if tx.BlockHeight >= startHeight {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// feed publishes values to the subscribed streams.
+type feed[T any] struct {
+	mu   sync.Mutex
+	subs map[chan T]struct{}
+}
+
+// subscribe returns a channel receiving the published values, and the
+// function to unsubscribe.
+func (f *feed[T]) subscribe() (<-chan T, func()) {
+	ch := make(chan T, 16)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[chan T]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subs, ch)
+	}
+}
+
+// publish sends v to the subscribers, dropping it for the ones which are
+// too slow.
+func (f *feed[T]) publish(v T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
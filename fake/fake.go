@@ -0,0 +1,105 @@
+// Package fake implements in-process fakes of the services the server depends
+// on, to run it on a laptop with the -dev flag: lnd, the submarine swapper,
+// swapd, lspd, the push notifications, the emails and the blob storage.
+//
+// The grpc fakes are served on an in-memory listener, so that the server uses
+// its usual grpc clients. They share one fake chain, which mines a block for
+// every transaction. A deposit to a swap address is simulated with Deposit.
+package fake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/breez/server/breez"
+	lspdrpc "github.com/breez/server/lsp/rpc"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/chainrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/submarineswaprpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufferSize = 1 << 20
+
+// Server serves the grpc fakes in process.
+type Server struct {
+	grpcServer *grpc.Server
+	listener   *bufconn.Listener
+	chain      *chain
+	swapper    *submarineSwapper
+}
+
+// NewServer starts serving the fakes of a node on network.
+func NewServer(network *chaincfg.Params) (*Server, error) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("btcec.NewPrivateKey: %w", err)
+	}
+	c := newChain(network)
+	s := &Server{
+		grpcServer: grpc.NewServer(),
+		listener:   bufconn.Listen(bufferSize),
+		chain:      c,
+		swapper:    newSubmarineSwapper(c),
+	}
+	n := newNode(c, key)
+	lnrpc.RegisterLightningServer(s.grpcServer, n)
+	routerrpc.RegisterRouterServer(s.grpcServer, &router{})
+	walletrpc.RegisterWalletKitServer(s.grpcServer, &walletKit{})
+	chainrpc.RegisterChainNotifierServer(s.grpcServer, &chainNotifier{chain: c})
+	submarineswaprpc.RegisterSubmarineSwapperServer(s.grpcServer, s.swapper)
+	breez.RegisterTaprootSwapperServer(s.grpcServer, &taprootSwapper{chain: c, key: key})
+	lspdrpc.RegisterChannelOpenerServer(s.grpcServer, &channelOpener{node: n})
+	lspdrpc.RegisterNotificationsServer(s.grpcServer, &notifications{})
+	go func() {
+		if err := s.grpcServer.Serve(s.listener); err != nil {
+			log.Printf("fake grpc server: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Dial returns a connection to the fakes.
+func (s *Server) Dial() (*grpc.ClientConn, error) {
+	return grpc.NewClient("passthrough:///fake",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
+// Stop stops serving the fakes.
+func (s *Server) Stop() {
+	s.grpcServer.Stop()
+}
+
+// Deposit simulates an on-chain payment of amount satoshis to a swap address
+// returned by the submarine swapper. It returns the id of the transaction.
+func (s *Server) Deposit(address string, amount int64) (string, error) {
+	return s.swapper.deposit(address, amount)
+}
+
+// LockupTx returns the lockup transaction of a boltz reverse swap: every
+// transaction confirmed by the fake chain notifier.
+func (s *Server) LockupTx(boltzID string) (string, error) {
+	return hex.EncodeToString(s.chain.rawTx), nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,262 @@
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/chainrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// feeRate is the fee estimate of the wallet, 10 sat/vbyte.
+	feeRate       = 2500
+	minRelayFeeKw = 253
+	invoiceExpiry = time.Hour
+	invoiceCLTV   = 144
+	defaultMemo   = "fake invoice"
+	nodeAlias     = "fake-lnd"
+	nodeHost      = "127.0.0.1:9735"
+)
+
+// node is a fake lnd node. Its invoices are settled as soon as they are
+// created, as if the app had paid them, and its payments always succeed.
+type node struct {
+	lnrpc.UnimplementedLightningServer
+	chain *chain
+	key   *btcec.PrivateKey
+
+	mu       sync.Mutex
+	invoices map[string]*lnrpc.Invoice
+}
+
+func newNode(c *chain, key *btcec.PrivateKey) *node {
+	return &node{chain: c, key: key, invoices: make(map[string]*lnrpc.Invoice)}
+}
+
+func (n *node) pubkey() string {
+	return hex.EncodeToString(n.key.PubKey().SerializeCompressed())
+}
+
+func (n *node) GetInfo(ctx context.Context, in *lnrpc.GetInfoRequest) (*lnrpc.GetInfoResponse, error) {
+	height := n.chain.blockHeight()
+	return &lnrpc.GetInfoResponse{
+		IdentityPubkey: n.pubkey(),
+		Alias:          nodeAlias,
+		BlockHeight:    height,
+		BlockHash:      hex.EncodeToString(blockHash(height)),
+		SyncedToChain:  true,
+		SyncedToGraph:  true,
+		Chains:         []*lnrpc.Chain{{Chain: "bitcoin", Network: n.chain.network.Name}},
+	}, nil
+}
+
+func (n *node) AddInvoice(ctx context.Context, in *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, error) {
+	preimage := randomBytes(32)
+	hash := sha256.Sum256(preimage)
+	var paymentAddr [32]byte
+	copy(paymentAddr[:], randomBytes(32))
+	memo := in.Memo
+	if memo == "" {
+		memo = defaultMemo
+	}
+	expiry := invoiceExpiry
+	if in.Expiry > 0 {
+		expiry = time.Duration(in.Expiry) * time.Second
+	}
+	amountMsat := in.ValueMsat
+	if amountMsat == 0 {
+		amountMsat = in.Value * 1000
+	}
+	options := []func(*zpay32.Invoice){
+		zpay32.Description(memo),
+		zpay32.Expiry(expiry),
+		zpay32.CLTVExpiry(invoiceCLTV),
+		zpay32.PaymentAddr(paymentAddr),
+		zpay32.Features(lnwire.NewFeatureVector(
+			lnwire.NewRawFeatureVector(lnwire.TLVOnionPayloadRequired, lnwire.PaymentAddrRequired),
+			lnwire.Features,
+		)),
+	}
+	if amountMsat > 0 {
+		options = append(options, zpay32.Amount(lnwire.MilliSatoshi(amountMsat)))
+	}
+	creationDate := time.Now()
+	invoice, err := zpay32.NewInvoice(n.chain.network, hash, creationDate, options...)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "zpay32.NewInvoice: %v", err)
+	}
+	payReq, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(n.key, chainhash.HashB(msg), true), nil
+		},
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invoice.Encode: %v", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addIndex := uint64(len(n.invoices) + 1)
+	n.invoices[hex.EncodeToString(hash[:])] = &lnrpc.Invoice{
+		Memo:           memo,
+		RPreimage:      preimage,
+		RHash:          hash[:],
+		Value:          amountMsat / 1000,
+		ValueMsat:      amountMsat,
+		Settled:        true,
+		CreationDate:   creationDate.Unix(),
+		SettleDate:     creationDate.Unix(),
+		PaymentRequest: payReq,
+		Expiry:         int64(expiry.Seconds()),
+		CltvExpiry:     invoiceCLTV,
+		AddIndex:       addIndex,
+		SettleIndex:    addIndex,
+		AmtPaidSat:     amountMsat / 1000,
+		AmtPaidMsat:    amountMsat,
+		State:          lnrpc.Invoice_SETTLED,
+		PaymentAddr:    paymentAddr[:],
+	}
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          hash[:],
+		PaymentRequest: payReq,
+		AddIndex:       addIndex,
+		PaymentAddr:    paymentAddr[:],
+	}, nil
+}
+
+func (n *node) LookupInvoice(ctx context.Context, in *lnrpc.PaymentHash) (*lnrpc.Invoice, error) {
+	hash := in.RHashStr
+	if hash == "" {
+		hash = hex.EncodeToString(in.RHash)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	invoice, ok := n.invoices[hash]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "there are no existing invoices")
+	}
+	return invoice, nil
+}
+
+func (n *node) SendCoins(ctx context.Context, in *lnrpc.SendCoinsRequest) (*lnrpc.SendCoinsResponse, error) {
+	tx := n.chain.mine(in.Addr, in.Amount)
+	return &lnrpc.SendCoinsResponse{Txid: tx.TxHash}, nil
+}
+
+func (n *node) SendPaymentSync(ctx context.Context, in *lnrpc.SendRequest) (*lnrpc.SendResponse, error) {
+	invoice, err := zpay32.Decode(in.PaymentRequest, n.chain.network)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "zpay32.Decode: %v", err)
+	}
+	return &lnrpc.SendResponse{
+		PaymentHash:     invoice.PaymentHash[:],
+		PaymentPreimage: randomBytes(32),
+		PaymentRoute:    &lnrpc.Route{},
+	}, nil
+}
+
+func (n *node) ListChannels(ctx context.Context, in *lnrpc.ListChannelsRequest) (*lnrpc.ListChannelsResponse, error) {
+	return &lnrpc.ListChannelsResponse{}, nil
+}
+
+func (n *node) ListPayments(ctx context.Context, in *lnrpc.ListPaymentsRequest) (*lnrpc.ListPaymentsResponse, error) {
+	return &lnrpc.ListPaymentsResponse{}, nil
+}
+
+func (n *node) UpdateChannelPolicy(ctx context.Context, in *lnrpc.PolicyUpdateRequest) (*lnrpc.PolicyUpdateResponse, error) {
+	return &lnrpc.PolicyUpdateResponse{}, nil
+}
+
+func (n *node) GetTransactions(ctx context.Context, in *lnrpc.GetTransactionsRequest) (*lnrpc.TransactionDetails, error) {
+	return &lnrpc.TransactionDetails{Transactions: n.chain.transactionsFrom(in.StartHeight)}, nil
+}
+
+func (n *node) SubscribeTransactions(in *lnrpc.GetTransactionsRequest, stream lnrpc.Lightning_SubscribeTransactionsServer) error {
+	txs, unsubscribe := n.chain.txFeed.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case tx := <-txs:
+			if err := stream.Send(tx); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// ChannelAcceptor never receives a channel request: the fake node has no
+// peers.
+func (n *node) ChannelAcceptor(stream lnrpc.Lightning_ChannelAcceptorServer) error {
+	<-stream.Context().Done()
+	return nil
+}
+
+type router struct {
+	routerrpc.UnimplementedRouterServer
+}
+
+func (*router) ResetMissionControl(ctx context.Context, in *routerrpc.ResetMissionControlRequest) (*routerrpc.ResetMissionControlResponse, error) {
+	return &routerrpc.ResetMissionControlResponse{}, nil
+}
+
+type walletKit struct {
+	walletrpc.UnimplementedWalletKitServer
+}
+
+func (*walletKit) EstimateFee(ctx context.Context, in *walletrpc.EstimateFeeRequest) (*walletrpc.EstimateFeeResponse, error) {
+	return &walletrpc.EstimateFeeResponse{SatPerKw: feeRate, MinRelayFeeSatPerKw: minRelayFeeKw}, nil
+}
+
+// chainNotifier confirms every transaction in the next block.
+type chainNotifier struct {
+	chainrpc.UnimplementedChainNotifierServer
+	chain *chain
+}
+
+func (c *chainNotifier) RegisterConfirmationsNtfn(in *chainrpc.ConfRequest, stream chainrpc.ChainNotifier_RegisterConfirmationsNtfnServer) error {
+	height := c.chain.blockHeight() + 1
+	err := stream.Send(&chainrpc.ConfEvent{
+		Event: &chainrpc.ConfEvent_Conf{Conf: &chainrpc.ConfDetails{
+			RawTx:       c.chain.rawTx,
+			BlockHash:   blockHash(height),
+			BlockHeight: height,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (c *chainNotifier) RegisterBlockEpochNtfn(in *chainrpc.BlockEpoch, stream chainrpc.ChainNotifier_RegisterBlockEpochNtfnServer) error {
+	blocks, unsubscribe := c.chain.blockFeed.subscribe()
+	defer unsubscribe()
+	height := c.chain.blockHeight()
+	for {
+		err := stream.Send(&chainrpc.BlockEpoch{Hash: blockHash(height), Height: height})
+		if err != nil {
+			return err
+		}
+		select {
+		case height = <-blocks:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
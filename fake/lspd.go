@@ -0,0 +1,80 @@
+package fake
+
+import (
+	"context"
+	"time"
+
+	lspdrpc "github.com/breez/server/lsp/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LSPConfig is the lsp configuration of the fake lspd, used when LSP_CONFIG is
+// not set.
+const LSPConfig = `{"lspd": {"fake": {"server": "fake", "token": "fake", "notls": true}}}`
+
+const (
+	lspName                = "Fake LSP"
+	channelCapacity        = 1_000_000
+	lspTargetConf          = 6
+	lspBaseFeeMsat         = 1000
+	lspFeeRate             = 0.000001
+	lspTimeLockDelta       = 144
+	lspMinHtlcMsat         = 600
+	openingFeeMinMsat      = 2_000_000
+	openingFeeProportional = 4000
+	openingFeeValidity     = 24 * time.Hour
+	openingFeeMaxIdleTime  = 4320
+	openingFeeMaxDelay     = 2016
+)
+
+// channelOpener is a fake of lspd, opening its channels from the fake node.
+// It cannot decrypt the blobs of the app, so it accepts every payment
+// registration and does not check the channels.
+type channelOpener struct {
+	lspdrpc.UnimplementedChannelOpenerServer
+	node *node
+}
+
+func (c *channelOpener) ChannelInformation(ctx context.Context, in *lspdrpc.ChannelInformationRequest) (*lspdrpc.ChannelInformationReply, error) {
+	return &lspdrpc.ChannelInformationReply{
+		Name:            lspName,
+		Pubkey:          c.node.pubkey(),
+		Host:            nodeHost,
+		ChannelCapacity: channelCapacity,
+		TargetConf:      lspTargetConf,
+		BaseFeeMsat:     lspBaseFeeMsat,
+		FeeRate:         lspFeeRate,
+		TimeLockDelta:   lspTimeLockDelta,
+		MinHtlcMsat:     lspMinHtlcMsat,
+		LspPubkey:       c.node.key.PubKey().SerializeCompressed(),
+		OpeningFeeParamsMenu: []*lspdrpc.OpeningFeeParams{{
+			MinMsat:              openingFeeMinMsat,
+			Proportional:         openingFeeProportional,
+			ValidUntil:           time.Now().Add(openingFeeValidity).UTC().Format(time.RFC3339),
+			MaxIdleTime:          openingFeeMaxIdleTime,
+			MaxClientToSelfDelay: openingFeeMaxDelay,
+			Promise:              "fake",
+		}},
+	}, nil
+}
+
+func (c *channelOpener) RegisterPayment(ctx context.Context, in *lspdrpc.RegisterPaymentRequest) (*lspdrpc.RegisterPaymentReply, error) {
+	return &lspdrpc.RegisterPaymentReply{}, nil
+}
+
+func (c *channelOpener) CheckChannels(ctx context.Context, in *lspdrpc.Encrypted) (*lspdrpc.Encrypted, error) {
+	return nil, status.Error(codes.Unimplemented, "the fake lspd cannot check the channels")
+}
+
+type notifications struct {
+	lspdrpc.UnimplementedNotificationsServer
+}
+
+func (*notifications) SubscribeNotifications(ctx context.Context, in *lspdrpc.EncryptedNotificationRequest) (*lspdrpc.SubscribeNotificationsReply, error) {
+	return &lspdrpc.SubscribeNotificationsReply{}, nil
+}
+
+func (*notifications) UnsubscribeNotifications(ctx context.Context, in *lspdrpc.EncryptedNotificationRequest) (*lspdrpc.UnsubscribeNotificationsReply, error) {
+	return &lspdrpc.UnsubscribeNotificationsReply{}, nil
+}
@@ -0,0 +1,119 @@
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc/submarineswaprpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	swapLockHeight = 144
+	redeemFees     = 1000
+)
+
+// submarineSwapper is a fake of the submarine swapper of lnd. Its swap
+// addresses are funded with Deposit.
+type submarineSwapper struct {
+	submarineswaprpc.UnimplementedSubmarineSwapperServer
+	chain *chain
+
+	mu sync.Mutex
+	// addresses maps the hex payment hashes of the swaps to their
+	// addresses.
+	addresses map[string]string
+	utxos     map[string][]*submarineswaprpc.UnspentAmountResponse_Utxo
+}
+
+func newSubmarineSwapper(c *chain) *submarineSwapper {
+	return &submarineSwapper{
+		chain:     c,
+		addresses: make(map[string]string),
+		utxos:     make(map[string][]*submarineswaprpc.UnspentAmountResponse_Utxo),
+	}
+}
+
+func (s *submarineSwapper) SubSwapServiceInit(ctx context.Context, in *submarineswaprpc.SubSwapServiceInitRequest) (*submarineswaprpc.SubSwapServiceInitResponse, error) {
+	if len(in.Hash) != sha256.Size {
+		return nil, status.Error(codes.InvalidArgument, "invalid hash")
+	}
+	// The address only has to be valid and unique: it is the hash of the
+	// swap parameters instead of the real swap script.
+	script := sha256.Sum256(append(in.Hash, in.Pubkey...))
+	address, err := btcutil.NewAddressWitnessScriptHash(script[:], s.chain.network)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "btcutil.NewAddressWitnessScriptHash: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addresses[hex.EncodeToString(in.Hash)] = address.EncodeAddress()
+	return &submarineswaprpc.SubSwapServiceInitResponse{
+		Address:    address.EncodeAddress(),
+		Pubkey:     randomBytes(33),
+		LockHeight: swapLockHeight,
+	}, nil
+}
+
+func (s *submarineSwapper) deposit(address string, amount int64) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("invalid amount %v", amount)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := false
+	for _, a := range s.addresses {
+		known = known || a == address
+	}
+	if !known {
+		return "", fmt.Errorf("unknown swap address %v", address)
+	}
+	tx := s.chain.mine(address, amount)
+	s.utxos[address] = append(s.utxos[address], &submarineswaprpc.UnspentAmountResponse_Utxo{
+		BlockHeight: tx.BlockHeight,
+		Amount:      amount,
+		Txid:        tx.TxHash,
+	})
+	return tx.TxHash, nil
+}
+
+func (s *submarineSwapper) UnspentAmount(ctx context.Context, in *submarineswaprpc.UnspentAmountRequest) (*submarineswaprpc.UnspentAmountResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	address := in.Address
+	if address == "" {
+		address = s.addresses[hex.EncodeToString(in.Hash)]
+	}
+	r := &submarineswaprpc.UnspentAmountResponse{LockHeight: swapLockHeight, Utxos: s.utxos[address]}
+	for _, u := range r.Utxos {
+		r.Amount += u.Amount
+	}
+	return r, nil
+}
+
+func (s *submarineSwapper) SubSwapServiceRedeemFees(ctx context.Context, in *submarineswaprpc.SubSwapServiceRedeemFeesRequest) (*submarineswaprpc.SubSwapServiceRedeemFeesResponse, error) {
+	return &submarineswaprpc.SubSwapServiceRedeemFeesResponse{Amount: redeemFees}, nil
+}
+
+// SubSwapServiceRedeem spends the deposits of the swap to the wallet.
+func (s *submarineSwapper) SubSwapServiceRedeem(ctx context.Context, in *submarineswaprpc.SubSwapServiceRedeemRequest) (*submarineswaprpc.SubSwapServiceRedeemResponse, error) {
+	hash := sha256.Sum256(in.Preimage)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	address, ok := s.addresses[hex.EncodeToString(hash[:])]
+	if !ok || len(s.utxos[address]) == 0 {
+		return nil, status.Error(codes.NotFound, "no utxo to redeem")
+	}
+	var amount int64
+	for _, u := range s.utxos[address] {
+		amount += u.Amount
+	}
+	delete(s.utxos, address)
+	tx := s.chain.mine("", amount-redeemFees)
+	return &submarineswaprpc.SubSwapServiceRedeemResponse{Txid: tx.TxHash}, nil
+}
@@ -0,0 +1,58 @@
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/breez/server/breez"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const swapLockTime = 288
+
+var swapParameters = &breez.SwapParameters{
+	MaxSwapAmountSat: 4_000_000,
+	MinSwapAmountSat: 1_000,
+	MinUtxoAmountSat: 1_000,
+}
+
+// taprootSwapper is a fake of swapd. Its swaps cannot be refunded.
+type taprootSwapper struct {
+	breez.UnimplementedTaprootSwapperServer
+	chain *chain
+	key   *btcec.PrivateKey
+}
+
+func (s *taprootSwapper) CreateSwap(ctx context.Context, in *breez.CreateSwapRequest) (*breez.CreateSwapResponse, error) {
+	if len(in.Hash) != sha256.Size {
+		return nil, status.Error(codes.InvalidArgument, "invalid hash")
+	}
+	// A taproot address of the hash of the swap parameters instead of the
+	// real swap output key.
+	outputKey := sha256.Sum256(append(in.Hash, in.RefundPubkey...))
+	address, err := btcutil.NewAddressTaproot(outputKey[:], s.chain.network)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "btcutil.NewAddressTaproot: %v", err)
+	}
+	return &breez.CreateSwapResponse{
+		Address:     address.EncodeAddress(),
+		ClaimPubkey: s.key.PubKey().SerializeCompressed(),
+		LockTime:    swapLockTime,
+		Parameters:  swapParameters,
+	}, nil
+}
+
+func (s *taprootSwapper) PaySwap(ctx context.Context, in *breez.PaySwapRequest) (*breez.PaySwapResponse, error) {
+	return &breez.PaySwapResponse{}, nil
+}
+
+func (s *taprootSwapper) RefundSwap(ctx context.Context, in *breez.RefundSwapRequest) (*breez.RefundSwapResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "the fake swapd cannot refund")
+}
+
+func (s *taprootSwapper) SwapParameters(ctx context.Context, in *breez.SwapParametersRequest) (*breez.SwapParametersResponse, error) {
+	return &breez.SwapParametersResponse{Parameters: swapParameters}, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"log"
-	"os"
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
@@ -22,12 +21,12 @@ var (
 	feeEstimates string
 )
 
-func genFeeEstimates(hash string) {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
+func (s *services) genFeeEstimates(hash string) {
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.cfg.LND.MacaroonHex)
 	var confTargets = []uint32{2, 3, 4, 5, 6, 10, 20, 25, 144, 504, 1008}
 	feeByBlockTarget := make(map[uint32]uint32)
 	for _, ct := range confTargets {
-		r, err := walletKitClient.EstimateFee(clientCtx, &walletrpc.EstimateFeeRequest{ConfTarget: int32(ct)})
+		r, err := s.walletKit.EstimateFee(clientCtx, &walletrpc.EstimateFeeRequest{ConfTarget: int32(ct)})
 		if err != nil {
 			log.Printf("walletKitClient.EstimateFee(%v): %v", ct, err)
 			return
@@ -49,30 +48,37 @@ func genFeeEstimates(hash string) {
 	log.Printf("Fees: %v", feeEstimates)
 }
 
-func startFeeEstimates() {
-	go func() {
-		for {
-			clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
-			stream, err := chainNotifierClient.RegisterBlockEpochNtfn(clientCtx, &chainrpc.BlockEpoch{})
-			if err != nil {
-				log.Printf("startFeeEstimates: chainNotifierClient.RegisterBlockEpochNtfn: %v", err)
-				<-time.After(time.Second * 10)
+// watchFeeEstimates updates the fee estimates on every new block, until ctx is
+// done.
+func (s *services) watchFeeEstimates(ctx context.Context) {
+	for {
+		clientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.cfg.LND.MacaroonHex)
+		stream, err := s.chainNotifier.RegisterBlockEpochNtfn(clientCtx, &chainrpc.BlockEpoch{})
+		if err != nil {
+			log.Printf("watchFeeEstimates: chainNotifierClient.RegisterBlockEpochNtfn: %v", err)
+			select {
+			case <-time.After(time.Second * 10):
 				continue
+			case <-ctx.Done():
+				return
 			}
+		}
 
-			for {
-				block, err := stream.Recv()
-				if err != nil {
-					log.Printf("startFeeEstimates: stream.Recv: %v", err)
-					break
-				}
-				c, err := chainhash.NewHash(block.Hash)
-				if err != nil {
-					log.Printf("startFeeEstimates: chainhash.NewHash(%x) err: %v", block.Hash, err)
-					continue
-				}
-				genFeeEstimates(c.String())
+		for {
+			block, err := stream.Recv()
+			if err != nil {
+				log.Printf("watchFeeEstimates: stream.Recv: %v", err)
+				break
 			}
+			c, err := chainhash.NewHash(block.Hash)
+			if err != nil {
+				log.Printf("watchFeeEstimates: chainhash.NewHash(%x) err: %v", block.Hash, err)
+				continue
+			}
+			s.genFeeEstimates(c.String())
 		}
-	}()
+		if ctx.Err() != nil {
+			return
+		}
+	}
 }
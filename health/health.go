@@ -0,0 +1,191 @@
+// Package health checks the dependencies of the server in the background and
+// reports their state through the grpc.health.v1 service and the /healthz and
+// /readyz http endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/breez/server/tracing"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check reports the health of one dependency. It must return when ctx is
+// done.
+type Check func(ctx context.Context) error
+
+type dependency struct {
+	name     string
+	critical bool
+	check    Check
+}
+
+type result struct {
+	err error
+}
+
+// Checker runs the checks of the dependencies periodically and caches their
+// results, so that probes never wait for a slow dependency.
+type Checker struct {
+	timeout time.Duration
+
+	mu           sync.Mutex
+	dependencies []dependency
+	results      map[string]result
+
+	grpcServer *health.Server
+}
+
+// NewChecker returns a checker giving up on a check after timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	grpcServer := health.NewServer()
+	grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &Checker{
+		timeout:    timeout,
+		results:    make(map[string]result),
+		grpcServer: grpcServer,
+	}
+}
+
+// Add adds a dependency. The server is not ready while a critical dependency
+// is unhealthy. It keeps serving in a degraded state while another one is:
+// only the features depending on it fail.
+func (c *Checker) Add(name string, critical bool, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dependencies = append(c.dependencies, dependency{name: name, critical: critical, check: check})
+}
+
+// GRPCServer returns the grpc.health.v1 service. The overall status ("") is
+// SERVING when the server is ready, and the status of every dependency is
+// reported under its name.
+func (c *Checker) GRPCServer() healthpb.HealthServer {
+	return c.grpcServer
+}
+
+// Run checks the dependencies every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			c.grpcServer.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) checkAll(ctx context.Context) {
+	c.mu.Lock()
+	dependencies := append([]dependency(nil), c.dependencies...)
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The checks run every few seconds: their calls are not traced.
+			checkCtx, cancel := context.WithTimeout(tracing.Untraced(ctx), c.timeout)
+			defer cancel()
+			err := d.check(checkCtx)
+			r := result{err: err}
+			c.mu.Lock()
+			previous, ok := c.results[d.name]
+			c.results[d.name] = r
+			c.mu.Unlock()
+			if err != nil && (!ok || previous.err == nil) {
+				log.Printf("health: %v is unhealthy: %v", d.name, err)
+			}
+			if err == nil && ok && previous.err != nil {
+				log.Printf("health: %v is healthy again", d.name)
+			}
+			status := healthpb.HealthCheckResponse_SERVING
+			if err != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			c.grpcServer.SetServingStatus(d.name, status)
+		}()
+	}
+	wg.Wait()
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if c.Ready() {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	c.grpcServer.SetServingStatus("", status)
+}
+
+// Ready tells whether every critical dependency was healthy when last checked.
+func (c *Checker) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range c.dependencies {
+		r, ok := c.results[d.name]
+		if d.critical && (!ok || r.err != nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// readiness is the public state of the server: the status of every
+// dependency, by name. The errors of the checks are only logged, since they
+// reveal the internal addresses and configuration.
+type readiness struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+func (c *Checker) readiness() readiness {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rd := readiness{Status: "ok", Dependencies: make(map[string]string, len(c.dependencies))}
+	for _, d := range c.dependencies {
+		status := "unknown"
+		if r, ok := c.results[d.name]; ok {
+			status = "ok"
+			if r.err != nil {
+				status = "unavailable"
+			}
+		}
+		switch {
+		case d.critical && status != "ok":
+			rd.Status = "unavailable"
+		case status != "ok" && rd.Status == "ok":
+			rd.Status = "degraded"
+		}
+		rd.Dependencies[d.name] = status
+	}
+	return rd
+}
+
+// HealthzHandler is the liveness probe: it succeeds as long as the process
+// serves http requests.
+func (c *Checker) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyzHandler is the readiness probe: it fails when a critical dependency is
+// unhealthy, and reports the last status of every dependency. The status is
+// "degraded" when only non critical dependencies are unhealthy.
+func (c *Checker) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	rd := c.readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if rd.Status == "unavailable" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(rd); err != nil {
+		log.Printf("json.Encode error: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/breez/server/breez"
+	"github.com/breez/server/health"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func lndCheck(c lnrpc.LightningClient, macaroonHex string) health.Check {
+	return func(ctx context.Context) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "macaroon", macaroonHex)
+		if _, err := c.GetInfo(ctx, &lnrpc.GetInfoRequest{}); err != nil {
+			return fmt.Errorf("GetInfo: %w", err)
+		}
+		return nil
+	}
+}
+
+func swapdCheck(c breez.TaprootSwapperClient) health.Check {
+	return func(ctx context.Context) error {
+		if _, err := c.SwapParameters(ctx, &breez.SwapParametersRequest{}); err != nil {
+			return fmt.Errorf("SwapParameters: %w", err)
+		}
+		return nil
+	}
+}
+
+// pinger is a storage checking its connection.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func storeCheck(p pinger) health.Check {
+	return func(ctx context.Context) error {
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("Ping: %w", err)
+		}
+		return nil
+	}
+}
+
+// esploraCheck checks that the esplora api at baseURL returns the height of
+// the chain tip.
+func esploraCheck(baseURL *url.URL) health.Check {
+	tipURL := baseURL.JoinPath("blocks/tip/height").String()
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tipURL, nil)
+		if err != nil {
+			return fmt.Errorf("http.NewRequest(%v): %w", tipURL, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("GET %v: %w", tipURL, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %v: %v", tipURL, resp.Status)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 100))
+		if err != nil {
+			return fmt.Errorf("GET %v: %w", tipURL, err)
+		}
+		if _, err := strconv.ParseUint(strings.TrimSpace(string(body)), 10, 64); err != nil {
+			return fmt.Errorf("GET %v: invalid height %q", tipURL, body)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+// Package i18n renders the texts of the notifications in the language of
+// their device. The texts are in a message catalog keyed by message ID, in
+// messages.go. The locale of a device is taken from the accept-language
+// metadata of the requests registering its notification token, and English
+// is the fallback of the devices without a locale or of a language without
+// the texts.
+package i18n
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/breez/server/notify"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// acceptLanguageKey is the metadata of the requests with the preferred
+// languages of the device, in the format of the Accept-Language http header.
+const acceptLanguageKey = "accept-language"
+
+var (
+	messages = newCatalog()
+	matcher  = language.NewMatcher(append([]language.Tag{language.English}, languages()...))
+)
+
+// newCatalog returns the catalog of texts, in which the texts missing in a
+// language are the English ones.
+func newCatalog() *catalog.Builder {
+	b := catalog.NewBuilder(catalog.Fallback(language.English))
+	for tag, msgs := range texts {
+		for id, english := range texts[language.English] {
+			text, ok := msgs[id]
+			if !ok {
+				text = english
+			}
+			if err := b.SetString(tag, string(id), text); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return b
+}
+
+// languages are the languages of the catalog but English.
+func languages() []language.Tag {
+	var tags []language.Tag
+	for tag := range texts {
+		if tag != language.English {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Sprintf returns the text of id in locale, a BCP 47 language tag, or in
+// English when the catalog does not have it.
+func Sprintf(locale string, id Message, args ...any) string {
+	tag, _ := language.MatchStrings(matcher, locale)
+	return message.NewPrinter(tag, message.Catalog(messages)).Sprintf(string(id), args...)
+}
+
+// Store is the storage of the locales of the devices, by notification token.
+type Store interface {
+	SetDeviceLocale(token, locale string) error
+	DeviceLocale(token string) (string, error)
+}
+
+// Localizer renders the texts in the locale of the devices.
+type Localizer struct {
+	store Store
+}
+
+// New returns the localizer of the devices whose locale is in store.
+func New(store Store) *Localizer {
+	return &Localizer{store: store}
+}
+
+// Render returns the text of id in the locale of the device of token.
+func (l *Localizer) Render(token string, id Message, args ...any) string {
+	locale, err := l.store.DeviceLocale(token)
+	if err != nil {
+		slog.Warn("i18n: DeviceLocale failed, rendering in English", "token", token, "error", err)
+	}
+	return Sprintf(locale, id, args...)
+}
+
+// UnaryServerInterceptor records the locale of the device of the requests
+// registering a notification token, when they have the accept-language
+// metadata.
+func (l *Localizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		token, locale := notify.RegisteredToken(req), requestLocale(ctx)
+		if token != "" && locale != "" {
+			if err := l.store.SetDeviceLocale(token, locale); err != nil {
+				slog.WarnContext(ctx, "i18n: SetDeviceLocale failed", "token", token, "locale", locale, "error", err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// requestLocale returns the preferred language of the request, or "".
+func requestLocale(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(acceptLanguageKey)
+	if len(values) == 0 {
+		return ""
+	}
+	tags, _, err := language.ParseAcceptLanguage(values[0])
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	return tags[0].String()
+}
@@ -0,0 +1,106 @@
+package i18n
+
+import "golang.org/x/text/language"
+
+// Message is the ID of a text of the notifications. The %v verbs of the
+// texts are replaced by the arguments of Render. The numbers are passed
+// formatted, with strconv: the printer of the catalog groups their digits.
+type Message string
+
+const (
+	ReceivePaymentTitle Message = "receive_payment.title"
+	ReceivePaymentBody  Message = "receive_payment.body"
+	ChannelOpenedTitle  Message = "channel_opened.title"
+	ChannelOpenedBody   Message = "channel_opened.body"
+	// CTPPayeeJoinedBody and CTPPayerJoinedBody take the name of the party
+	// which joined.
+	CTPPayeeJoinedTitle Message = "ctp.payee_joined.title"
+	CTPPayeeJoinedBody  Message = "ctp.payee_joined.body"
+	CTPPayerJoinedTitle Message = "ctp.payer_joined.title"
+	CTPPayerJoinedBody  Message = "ctp.payer_joined.body"
+	// PaymentRequestBody follows the name of the payee, the title, and takes
+	// the amount.
+	PaymentRequestBody Message = "payment_request.body"
+	InactiveTitle      Message = "inactive.title"
+	// InactiveBody takes the number of days.
+	InactiveBody Message = "inactive.body"
+	// FundsTitle, FundsConfirmedBody and FundsUnconfirmedBody are the
+	// notifications of the deposits to the swap addresses.
+	// FundsUnconfirmedBody takes the amount.
+	FundsTitle           Message = "funds.title"
+	FundsConfirmedBody   Message = "funds_confirmed.body"
+	FundsUnconfirmedBody Message = "funds_unconfirmed.body"
+)
+
+// texts are the texts of the messages by language. English is the fallback
+// of the missing texts and languages.
+var texts = map[language.Tag]map[Message]string{
+	language.English: {
+		ReceivePaymentTitle:  "Receive Payment",
+		ReceivePaymentBody:   "You are now ready to receive payments using Breez. Open to continue with a previously shared payment link.",
+		ChannelOpenedTitle:   "Breez",
+		ChannelOpenedBody:    "You can now use Breez to send and receive Bitcoin payments!",
+		CTPPayeeJoinedTitle:  "Connect To Pay",
+		CTPPayeeJoinedBody:   "%v is waiting for you to complete a payment you've previously shared. Open to continue with the payment.",
+		CTPPayerJoinedTitle:  "Receive Payment",
+		CTPPayerJoinedBody:   "%v is waiting for you to join a payment session. Open to continue with receiving this payment.",
+		PaymentRequestBody:   "is requesting you to pay %v Sat",
+		InactiveTitle:        "Inactive Channels",
+		InactiveBody:         "You haven't made any payments with Breez for %v days, so your LSP might have to close your channels. Open Breez for more information.",
+		FundsTitle:           "Breez",
+		FundsConfirmedBody:   "Received funds are now confirmed. Please open the app to complete your transaction.",
+		FundsUnconfirmedBody: "Breez is waiting for %v sat to be confirmed.",
+	},
+	language.Spanish: {
+		ReceivePaymentTitle:  "Recibir pago",
+		ReceivePaymentBody:   "Ya puedes recibir pagos con Breez. Abre la app para continuar con un enlace de pago compartido anteriormente.",
+		ChannelOpenedBody:    "¡Ya puedes usar Breez para enviar y recibir pagos en Bitcoin!",
+		CTPPayeeJoinedBody:   "%v está esperando que completes un pago que compartiste anteriormente. Abre la app para continuar con el pago.",
+		CTPPayerJoinedTitle:  "Recibir pago",
+		CTPPayerJoinedBody:   "%v está esperando que te unas a una sesión de pago. Abre la app para continuar y recibir este pago.",
+		PaymentRequestBody:   "te solicita un pago de %v sat",
+		InactiveTitle:        "Canales inactivos",
+		InactiveBody:         "No has realizado pagos con Breez en %v días, por lo que tu LSP podría tener que cerrar tus canales. Abre Breez para más información.",
+		FundsConfirmedBody:   "Los fondos recibidos ya están confirmados. Abre la app para completar tu transacción.",
+		FundsUnconfirmedBody: "Breez está esperando la confirmación de %v sat.",
+	},
+	language.French: {
+		ReceivePaymentTitle:  "Recevoir un paiement",
+		ReceivePaymentBody:   "Vous pouvez maintenant recevoir des paiements avec Breez. Ouvrez l'application pour continuer avec un lien de paiement partagé précédemment.",
+		ChannelOpenedBody:    "Vous pouvez maintenant utiliser Breez pour envoyer et recevoir des paiements en Bitcoin !",
+		CTPPayeeJoinedBody:   "%v attend que vous finalisiez un paiement que vous avez partagé. Ouvrez l'application pour continuer le paiement.",
+		CTPPayerJoinedTitle:  "Recevoir un paiement",
+		CTPPayerJoinedBody:   "%v attend que vous rejoigniez une session de paiement. Ouvrez l'application pour recevoir ce paiement.",
+		PaymentRequestBody:   "vous demande de payer %v sat",
+		InactiveTitle:        "Canaux inactifs",
+		InactiveBody:         "Vous n'avez effectué aucun paiement avec Breez depuis %v jours : votre LSP pourrait devoir fermer vos canaux. Ouvrez Breez pour plus d'informations.",
+		FundsConfirmedBody:   "Les fonds reçus sont maintenant confirmés. Ouvrez l'application pour finaliser votre transaction.",
+		FundsUnconfirmedBody: "Breez attend la confirmation de %v sat.",
+	},
+	language.German: {
+		ReceivePaymentTitle:  "Zahlung empfangen",
+		ReceivePaymentBody:   "Du kannst jetzt Zahlungen mit Breez empfangen. Öffne die App, um mit einem zuvor geteilten Zahlungslink fortzufahren.",
+		ChannelOpenedBody:    "Du kannst Breez jetzt zum Senden und Empfangen von Bitcoin-Zahlungen verwenden!",
+		CTPPayeeJoinedBody:   "%v wartet darauf, dass du eine zuvor geteilte Zahlung abschließt. Öffne die App, um mit der Zahlung fortzufahren.",
+		CTPPayerJoinedTitle:  "Zahlung empfangen",
+		CTPPayerJoinedBody:   "%v wartet darauf, dass du einer Zahlungssitzung beitrittst. Öffne die App, um diese Zahlung zu empfangen.",
+		PaymentRequestBody:   "bittet dich, %v Sat zu zahlen",
+		InactiveTitle:        "Inaktive Kanäle",
+		InactiveBody:         "Du hast seit %v Tagen keine Zahlungen mit Breez getätigt, daher muss dein LSP möglicherweise deine Kanäle schließen. Öffne Breez für weitere Informationen.",
+		FundsConfirmedBody:   "Die empfangenen Mittel sind jetzt bestätigt. Bitte öffne die App, um deine Transaktion abzuschließen.",
+		FundsUnconfirmedBody: "Breez wartet auf die Bestätigung von %v Sat.",
+	},
+	language.Portuguese: {
+		ReceivePaymentTitle:  "Receber pagamento",
+		ReceivePaymentBody:   "Agora você pode receber pagamentos com o Breez. Abra o app para continuar com um link de pagamento compartilhado anteriormente.",
+		ChannelOpenedBody:    "Agora você pode usar o Breez para enviar e receber pagamentos em Bitcoin!",
+		CTPPayeeJoinedBody:   "%v está esperando você concluir um pagamento que compartilhou anteriormente. Abra o app para continuar com o pagamento.",
+		CTPPayerJoinedTitle:  "Receber pagamento",
+		CTPPayerJoinedBody:   "%v está esperando você entrar em uma sessão de pagamento. Abra o app para continuar e receber este pagamento.",
+		PaymentRequestBody:   "está solicitando que você pague %v sat",
+		InactiveTitle:        "Canais inativos",
+		InactiveBody:         "Você não faz pagamentos com o Breez há %v dias, por isso seu LSP pode precisar fechar seus canais. Abra o Breez para mais informações.",
+		FundsConfirmedBody:   "Os fundos recebidos foram confirmados. Abra o app para concluir sua transação.",
+		FundsUnconfirmedBody: "O Breez está aguardando a confirmação de %v sat.",
+	},
+}
@@ -6,19 +6,18 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/breez/server/breez"
+	"github.com/breez/server/logging"
 )
 
 func checkSwapId(fc *fastcache.Cache, apiURL, swapID string, body []byte) error {
@@ -53,7 +52,7 @@ func checkSwapId(fc *fastcache.Cache, apiURL, swapID string, body []byte) error
 	return nil
 }
 
-func BroadcastHandler(chainApiServers []*breez.ChainApiServersReply_ChainAPIServer, prefix string, p *httputil.ReverseProxy, u *url.URL) func(http.ResponseWriter, *http.Request) {
+func BroadcastHandler(chainApiServers []*breez.ChainApiServersReply_ChainAPIServer, CACert *x509.Certificate, prefix string, p *httputil.ReverseProxy, u *url.URL) func(http.ResponseWriter, *http.Request) {
 	apiURL := ""
 	for _, cs := range chainApiServers {
 		if cs.ServerType == "BOLTZ_SWAPPER" {
@@ -74,15 +73,6 @@ func BroadcastHandler(chainApiServers []*breez.ChainApiServersReply_ChainAPIServ
 	}
 	fc := fastcache.New(100_000_000)
 
-	CACertBlock, _ := pem.Decode([]byte(os.Getenv("BREEZ_CA_CERT")))
-	if CACertBlock == nil {
-		log.Fatal("Breez CA cert invalid")
-	}
-	CACert, err := x509.ParseCertificate(CACertBlock.Bytes)
-	if err != nil {
-		log.Fatal("Cannot parse CA cert:", err)
-	}
-
 	rootPool := x509.NewCertPool()
 	rootPool.AddCert(CACert)
 
@@ -93,7 +83,7 @@ func BroadcastHandler(chainApiServers []*breez.ChainApiServersReply_ChainAPIServ
 			apiKey := authHeader[7:]
 			block, err := base64.StdEncoding.DecodeString(apiKey)
 			if err != nil {
-				log.Printf("base64.StdEncoding.DecodeString(apiKey) [%v] error: %v", apiKey, err)
+				log.Printf("base64.StdEncoding.DecodeString(apiKey) [%v] error: %v", logging.Secret(apiKey), err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+)
+
+// sensitiveSuffixes are the endings of the normalized attribute keys whose
+// values are redacted: "device_token", "apiKey" and "X-Api-Key" all end with
+// one of them.
+var sensitiveSuffixes = []string{
+	"token",
+	"apikey",
+	"macaroon",
+	"macaroonhex",
+	"secret",
+	"password",
+	"preimage",
+	"deviceid",
+	"email",
+	"phone",
+	"fullname",
+	"postaladdress",
+}
+
+// Sensitive reports whether the values of the attribute key are redacted.
+func Sensitive(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "", ".", "").Replace(strings.ToLower(key))
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces s by a short hash, so that the logs of the same value can
+// still be matched without revealing it.
+func Redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(s))
+	return "redacted:" + hex.EncodeToString(h[:4])
+}
+
+// Secret is a string redacted when it is logged, with slog or with the fmt
+// verbs.
+type Secret string
+
+func (s Secret) String() string {
+	return Redact(string(s))
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// Secrets converts the strings in s to Secret.
+func Secrets(s []string) []Secret {
+	secrets := make([]Secret, len(s))
+	for i, v := range s {
+		secrets[i] = Secret(v)
+	}
+	return secrets
+}
+
+// redactHandler redacts the values of the sensitive attributes.
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindLogValuer {
+		if _, ok := a.Value.Any().(Secret); ok {
+			// Already redacted.
+			return slog.Attr{Key: a.Key, Value: a.Value.Resolve()}
+		}
+	}
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		group := v.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	}
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redact(v.String()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
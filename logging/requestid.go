@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDHeader is the http header, and the grpc metadata key in
+	// lower case, carrying the request ID.
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	traceIDKey      = "trace_id"
+	// maxRequestIDLength bounds the request IDs sent by the clients.
+	maxRequestIDLength = 128
+)
+
+type ctxKeyType string
+
+const ctxKey ctxKeyType = "requestID"
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx with the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey, id)
+}
+
+// RequestID returns the request ID of ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey).(string)
+	return id
+}
+
+// validRequestID reports whether id, sent by a client, can be reused.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// grpcRequestID returns ctx with the request ID sent by the client, or a new
+// one, and sends it back in the response header.
+func grpcRequestID(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && validRequestID(ids[0]) {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+	return WithRequestID(ctx, id)
+}
+
+// logCall logs the calls at the debug level, and the failed ones at the info
+// level.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	attrs := []any{"method", method, "code", status.Code(err).String(), "duration", time.Since(start)}
+	if err != nil {
+		slog.InfoContext(ctx, "grpc call failed", append(attrs, "error", err)...)
+		return
+	}
+	slog.DebugContext(ctx, "grpc call", attrs...)
+}
+
+// UnaryServerInterceptor puts the request ID in the context of every call and
+// logs the calls.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx = grpcRequestID(ctx)
+		resp, err := handler(ctx, req)
+		logCall(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor puts the request ID in the context of every stream
+// and logs the streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = grpcRequestID(ss.Context())
+		err := handler(srv, wrapped)
+		logCall(wrapped.WrappedContext, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// outgoingRequestID adds the request ID of ctx to the metadata of the
+// outgoing calls.
+func outgoingRequestID(ctx context.Context) context.Context {
+	if id := RequestID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	}
+	return ctx
+}
+
+// UnaryClientInterceptor propagates the request ID to the called services.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor propagates the request ID to the called services.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+	}
+}
+
+// DialOptions are the options of the grpc clients propagating the request
+// IDs.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor()),
+	}
+}
+
+// Middleware puts the request ID sent by the client, or a new one, in the
+// context of every http request and in the response header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -8,17 +8,19 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"log/slog"
 
 	"github.com/breez/server/auth"
 	"github.com/breez/server/breez"
+	"github.com/breez/server/logging"
 	lspdrpc "github.com/breez/server/lsp/rpc"
+	"github.com/breez/server/rpcerror"
+	"github.com/breez/server/tracing"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 // Server implements lsp grpc functions
@@ -57,29 +59,38 @@ var (
 	lspdClients map[string]*lspdClient
 )
 
-// InitLSP initialize lsp configuration and connections
-func InitLSP() error {
-	err := readConfig()
-	if err != nil {
-		return errors.Wrapf(err, "Error in LSP Initialization")
+// Dialer connects to the lspd server of an LSP.
+type Dialer func(server string, noTLS bool) (grpc.ClientConnInterface, error)
+
+func dialOptions() []grpc.DialOption {
+	return append(logging.DialOptions(), tracing.DialOptions()...)
+}
+
+// Dial connects to an lspd server, authenticated with the system
+// certificates unless noTLS is set.
+func Dial(server string, noTLS bool) (grpc.ClientConnInterface, error) {
+	if noTLS {
+		return grpc.Dial(server, append(dialOptions(), grpc.WithInsecure())...)
 	}
-	log.Printf("LSP Configuration: %#v", lspConf)
 	systemCertPool, err := x509.SystemCertPool()
 	if err != nil {
-		return errors.Wrapf(err, "Error getting SystemCertPool in InitLSP")
+		return nil, errors.Wrapf(err, "Error getting SystemCertPool")
+	}
+	return grpc.Dial(server, append(dialOptions(), grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(systemCertPool, "")))...)
+}
+
+// InitLSP initialize lsp configuration and connections from the json
+// configuration lspConfig, connecting to the lspd servers with dial.
+func InitLSP(lspConfig string, dial Dialer) error {
+	err := readConfig(lspConfig)
+	if err != nil {
+		return errors.Wrapf(err, "Error in LSP Initialization")
 	}
-	creds := credentials.NewClientTLSFromCert(systemCertPool, "")
 	lspdClients = make(map[string]*lspdClient, len(lspConf.LspdList))
 	for id, LSP := range lspConf.LspdList {
-		log.Printf("LSP id: %v; server: %v; token: %v", id, LSP.Server, LSP.Token)
+		slog.Info("LSP configuration", "id", id, "server", LSP.Server, "token", LSP.Token, "notls", LSP.NoTLS)
 		if LSP.Server != "" {
-			var dialOptions []grpc.DialOption
-			if LSP.NoTLS {
-				dialOptions = append(dialOptions, grpc.WithInsecure())
-			} else {
-				dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
-			}
-			conn, err := grpc.Dial(LSP.Server, dialOptions...)
+			conn, err := dial(LSP.Server, LSP.NoTLS)
 			if err != nil {
 				log.Printf("Failed to connect to server gRPC: %v", err)
 			} else {
@@ -93,12 +104,22 @@ func InitLSP() error {
 	return nil
 }
 
-func readConfig() error {
-	lspConfig := os.Getenv("LSP_CONFIG")
+// Check checks that every lspd instance answers.
+func Check(ctx context.Context) error {
+	for id, c := range lspdClients {
+		clientCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+lspConf.LspdList[id].Token)
+		_, err := c.channelOpenerClient.ChannelInformation(clientCtx, &lspdrpc.ChannelInformationRequest{})
+		if err != nil {
+			return fmt.Errorf("ChannelInformation for lspd %v: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func readConfig(lspConfig string) error {
 	err := loadConfig(bytes.NewReader([]byte(lspConfig)))
 	if err != nil {
-		log.Printf("Unable to load the configuration from %s: %v", lspConfig, err)
-		return errors.Wrapf(err, "Unable to load the configuration from %s", lspConfig)
+		return errors.Wrapf(err, "Unable to load the LSP configuration")
 	}
 	return nil
 }
@@ -114,15 +135,15 @@ func (s *Server) LSPList(ctx context.Context, in *breez.LSPListRequest) (*breez.
 	keys := auth.GetHeaderKeys(ctx)
 	list, err := s.DBLSPList(keys)
 	if err != nil {
-		log.Printf("Error in DBLSPList(%#v): %v", keys, err)
-		return &r, fmt.Errorf("error in DBLSPList(%#v): %w", keys, err)
+		log.Printf("Error in DBLSPList(%v): %v", logging.Secrets(keys), err)
+		return nil, err
 	}
 	for _, id := range list {
 		c, ok := lspdClients[id]
 		if !ok {
 			continue
 		}
-		clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lspConf.LspdList[id].Token)
+		clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lspConf.LspdList[id].Token)
 		ci, err := c.channelOpenerClient.ChannelInformation(clientCtx, &lspdrpc.ChannelInformationRequest{Pubkey: in.Pubkey})
 		if err != nil {
 			log.Printf("Error in ChannelInformation for lsdp %v: %v", id, err)
@@ -170,15 +191,15 @@ func (s *Server) LSPFullList(ctx context.Context, in *breez.LSPFullListRequest)
 	keys := auth.GetHeaderKeys(ctx)
 	active, inactive, err := s.DBLSPFullList(keys)
 	if err != nil {
-		log.Printf("Error in DBLSPList(%#v): %v", keys, err)
-		return &r, fmt.Errorf("error in DBLSPList(%#v): %w", keys, err)
+		log.Printf("Error in DBLSPList(%v): %v", logging.Secrets(keys), err)
+		return nil, err
 	}
 	for _, id := range active {
 		c, ok := lspdClients[id]
 		if !ok {
 			continue
 		}
-		clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lspConf.LspdList[id].Token)
+		clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lspConf.LspdList[id].Token)
 		ci, err := c.channelOpenerClient.ChannelInformation(clientCtx, &lspdrpc.ChannelInformationRequest{Pubkey: in.Pubkey})
 		if err != nil {
 			log.Printf("Error in ChannelInformation for lsdp %v: %v", id, err)
@@ -220,7 +241,7 @@ func (s *Server) LSPFullList(ctx context.Context, in *breez.LSPFullListRequest)
 		if !ok {
 			continue
 		}
-		clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lspConf.LspdList[id].Token)
+		clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lspConf.LspdList[id].Token)
 		ci, err := c.channelOpenerClient.ChannelInformation(clientCtx, &lspdrpc.ChannelInformationRequest{Pubkey: in.Pubkey})
 		if err != nil {
 			log.Printf("Error in ChannelInformation for lsdp %v: %v", id, err)
@@ -261,27 +282,32 @@ func contains(s []string, str string) bool {
 	return false
 }
 
+// lspNotFound is the error of a request for an LSP which is not configured.
+func lspNotFound(id string) error {
+	return rpcerror.New(codes.NotFound, rpcerror.ReasonLSPNotFound, "Not found").WithMetadata("lsp_id", id)
+}
+
 // RegisterPayment sends information concerning a payment used by the LSP to open a channel
 func (s *Server) RegisterPayment(ctx context.Context, in *breez.RegisterPaymentRequest) (*breez.RegisterPaymentReply, error) {
 	lsp, ok := lspConf.LspdList[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
+		return nil, rpcerror.NotAuthorized()
 	}
 	keys := auth.GetHeaderKeys(ctx)
 	lspList, err := s.DBLSPList(auth.GetHeaderKeys(ctx))
 	if err != nil {
-		log.Printf("Error in DBLSPList(%#v): %v", keys, err)
-		return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
+		log.Printf("Error in DBLSPList(%v): %v", logging.Secrets(keys), err)
+		return nil, rpcerror.NotAuthorized()
 	}
 	if !contains(lspList, in.LspId) {
-		return nil, status.Errorf(codes.PermissionDenied, "Not authorized")
+		return nil, rpcerror.NotAuthorized()
 	}
 
 	lspdClient, ok := lspdClients[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lsp.Token)
+	clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lsp.Token)
 	_, err = lspdClient.channelOpenerClient.RegisterPayment(clientCtx, &lspdrpc.RegisterPaymentRequest{Blob: in.Blob})
 	if err != nil {
 		return nil, err
@@ -293,13 +319,13 @@ func (s *Server) RegisterPayment(ctx context.Context, in *breez.RegisterPaymentR
 func (s *Server) CheckChannels(ctx context.Context, in *breez.CheckChannelsRequest) (*breez.CheckChannelsReply, error) {
 	lsp, ok := lspConf.LspdList[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
 	lspdClient, ok := lspdClients[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lsp.Token)
+	clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lsp.Token)
 	reply, err := lspdClient.channelOpenerClient.CheckChannels(clientCtx, &lspdrpc.Encrypted{Data: in.Blob})
 	if err != nil {
 		return nil, err
@@ -313,13 +339,13 @@ func (s *Server) RegisterPaymentNotification(
 ) (*breez.RegisterPaymentNotificationResponse, error) {
 	lsp, ok := lspConf.LspdList[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
 	lspdClient, ok := lspdClients[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lsp.Token)
+	clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lsp.Token)
 	_, err := lspdClient.notificationClient.SubscribeNotifications(clientCtx, &lspdrpc.EncryptedNotificationRequest{
 		Blob: in.Blob,
 	})
@@ -335,13 +361,13 @@ func (s *Server) RemovePaymentNotification(
 ) (*breez.RemovePaymentNotificationResponse, error) {
 	lsp, ok := lspConf.LspdList[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
 	lspdClient, ok := lspdClients[in.LspId]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "Not found")
+		return nil, lspNotFound(in.LspId)
 	}
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+lsp.Token)
+	clientCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "authorization", "Bearer "+lsp.Token)
 	_, err := lspdClient.notificationClient.UnsubscribeNotifications(clientCtx, &lspdrpc.EncryptedNotificationRequest{
 		Blob: in.Blob,
 	})
@@ -0,0 +1,180 @@
+// Package metrics defines the prometheus metrics of the server.
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+const namespace = "breez"
+
+var (
+	// RateLimitRejected counts the requests rejected by the rate limiter, by
+	// method (or http pattern) and by the bucket which rejected them.
+	RateLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ratelimit",
+		Name:      "rejected_total",
+		Help:      "Requests rejected by the rate limiter.",
+	}, []string{"name", "bucket"})
+	// RateLimitBackendErrors counts the failures of the rate limit backend,
+	// by method or http pattern, and the failures of the primary backend of
+	// the fallback backend as "primary".
+	RateLimitBackendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ratelimit",
+		Name:      "backend_errors_total",
+		Help:      "Failures of the rate limit backend.",
+	}, []string{"name"})
+
+	// PushNotifications counts the push notifications sent, by provider (fcm
+	// or apns), type (data or alert) and result (success, unregistered or
+	// error).
+	PushNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "notifications_total",
+		Help:      "Push notifications sent.",
+	}, []string{"provider", "type", "result"})
+	// OutboxNotifications counts the delivery attempts of the notification
+	// outbox, by source and outcome (delivered, retry or dead).
+	OutboxNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "outbox_attempts_total",
+		Help:      "Delivery attempts of the notification outbox.",
+	}, []string{"source", "outcome"})
+	// InvalidatedTokens counts the unregistered notification tokens purged
+	// from the storage, by provider and result (success or error).
+	InvalidatedTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "invalidated_tokens_total",
+		Help:      "Unregistered notification tokens purged.",
+	}, []string{"provider", "result"})
+
+	// LiquidProxyResponses counts the responses of the liquid esplora proxy
+	// by http pattern and status code.
+	LiquidProxyResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "liquid_proxy",
+		Name:      "responses_total",
+		Help:      "Responses of the liquid esplora proxy.",
+	}, []string{"pattern", "code"})
+
+	// RedeemsInProgress is the number of swap redeems waiting for a
+	// confirmation.
+	RedeemsInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "redeemer",
+		Name:      "in_progress",
+		Help:      "Swap redeems waiting for a confirmation.",
+	})
+	// RedeemFeeRate is the fee rate chosen for the redeem transactions.
+	RedeemFeeRate = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redeemer",
+		Name:      "fee_rate_sat_per_vbyte",
+		Help:      "Fee rate of the redeem transactions.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+	})
+	// RedeemFeeBumps counts the redeem transactions replaced by fee.
+	RedeemFeeBumps = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redeemer",
+		Name:      "fee_bumps_total",
+		Help:      "Redeem transactions replaced with a higher fee.",
+	})
+	// Redeems counts the redeem transactions broadcast, by result.
+	Redeems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redeemer",
+		Name:      "redeems_total",
+		Help:      "Redeem transactions broadcast.",
+	}, []string{"result"})
+
+	// SyncQueueDepth is the number of devices registered for periodic sync
+	// notifications.
+	SyncQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "queue_depth",
+		Help:      "Devices registered for periodic sync notifications.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		RateLimitRejected,
+		RateLimitBackendErrors,
+		PushNotifications,
+		OutboxNotifications,
+		InvalidatedTokens,
+		LiquidProxyResponses,
+		RedeemsInProgress,
+		RedeemFeeRate,
+		RedeemFeeBumps,
+		Redeems,
+		SyncQueueDepth,
+	)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+}
+
+// Result returns the result label of an operation.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// UnaryServerInterceptor counts the requests of every method by status code
+// and measures their latency.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return grpc_prometheus.UnaryServerInterceptor
+}
+
+// StreamServerInterceptor is the UnaryServerInterceptor of streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return grpc_prometheus.StreamServerInterceptor
+}
+
+// RegisterGRPCServer initializes the metrics of all the methods of a grpc
+// server, so that they are exported before their first call.
+func RegisterGRPCServer(s *grpc.Server) {
+	grpc_prometheus.Register(s)
+}
+
+// InstrumentProxy counts the responses of a liquid proxy.
+func InstrumentProxy(p *httputil.ReverseProxy) {
+	modifyResponse := p.ModifyResponse
+	p.ModifyResponse = func(resp *http.Response) error {
+		LiquidProxyResponses.WithLabelValues(resp.Request.Pattern, strconv.Itoa(resp.StatusCode)).Inc()
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+	errorHandler := p.ErrorHandler
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		LiquidProxyResponses.WithLabelValues(r.Pattern, strconv.Itoa(http.StatusBadGateway)).Inc()
+		if errorHandler != nil {
+			errorHandler(w, r, err)
+			return
+		}
+		log.Printf("liquid proxy error: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/breez/server/config"
+	"github.com/breez/server/postgresql"
+	"github.com/breez/server/store"
+)
+
+const migrateUsage = "usage: server migrate up|down [steps]|status|index-tokens"
+
+// runMigrate runs the migrate subcommand against the database at
+// DATABASE_URL:
+//
+//	migrate up            applies all the pending migrations
+//	migrate down [steps]  reverts the last steps migrations (1 by default)
+//	migrate status        prints the version of the schema
+//	migrate index-tokens  indexes the notification tokens registered in Redis
+//	                      before they were indexed, so that they are purged
+func runMigrate(args []string, dev bool) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if args[0] == "index-tokens" && len(args) == 1 {
+		return runIndexTokens(dev)
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid steps %q", args[1])
+		}
+		steps = n
+	case len(args) != 1:
+		return errors.New(migrateUsage)
+	}
+
+	databaseURL, err := config.DatabaseURL(dev)
+	if err != nil {
+		return err
+	}
+	migrator, err := postgresql.NewMigrator(databaseURL)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down(steps)
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+	return printMigrationStatus(migrator)
+}
+
+// migrateUp applies the pending migrations to the database at databaseURL.
+func migrateUp(databaseURL string) error {
+	migrator, err := postgresql.NewMigrator(databaseURL)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return migrator.Up()
+}
+
+func printMigrationStatus(migrator *postgresql.Migrator) error {
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	for _, m := range status.Migrations {
+		state := "pending"
+		if m.Version <= status.Version {
+			state = "applied"
+		}
+		if m.Version == status.Version && status.Dirty {
+			state = "dirty"
+		}
+		fmt.Fprintf(os.Stdout, "%06d  %-8s %v\n", m.Version, state, m.Description)
+	}
+	fmt.Fprintf(os.Stdout, "schema version %v, latest %v\n", status.Version, status.Latest())
+	return nil
+}
+
+// tokenRegistrations are the Redis keys holding notification tokens, by
+// pattern, with the function returning the token of one of their members, or
+// of a field and its value.
+var tokenRegistrations = map[string]func(member, value string) string{
+	syncSetName:                    func(member, _ string) string { return member },
+	"input-address-notification:*": func(member, _ string) string { return member },
+	"tx-notify-*": func(member, _ string) string {
+		var registration map[string]string
+		if err := json.Unmarshal([]byte(member), &registration); err != nil {
+			return ""
+		}
+		return registration["token"]
+	},
+	"ctp-session-*": func(field, value string) string {
+		if !strings.HasPrefix(field, "ctp-token-") {
+			return ""
+		}
+		return value
+	},
+}
+
+// runIndexTokens indexes the notification tokens of the registrations stored
+// in Redis. It can run again: the registrations indexed already are left as
+// they are.
+func runIndexTokens(dev bool) error {
+	address, db, err := config.Redis(dev)
+	if err != nil {
+		return err
+	}
+	redisStore := store.NewRedis(address, db)
+	defer redisStore.Close()
+	ctx := context.Background()
+	for pattern, token := range tokenRegistrations {
+		n, err := redisStore.IndexRegistrations(ctx, pattern, token)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%-30s %v registrations indexed\n", pattern, n)
+	}
+	return nil
+}
@@ -1,29 +1,50 @@
-package main
+// Package nodeinfo stores the information signed and published by the nodes,
+// such as their routing hints.
+package nodeinfo
 
 import (
 	"context"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"math"
 	"strconv"
 	"time"
 
 	"github.com/breez/server/breez"
+	"github.com/breez/server/rpcerror"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/lightningnetwork/lnd/lnwire"
+	"google.golang.org/grpc/codes"
 )
 
 var (
-	ErrKeyNotSupported  = errors.New("key is not supported")
-	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	ErrKeyNotSupported  = rpcerror.New(codes.InvalidArgument, rpcerror.ReasonKeyNotSupported, "key is not supported").WithViolation("key", "key is not supported")
+	ErrInvalidTimestamp = rpcerror.InvalidArgument("timestamp", "invalid timestamp")
+	errValueNotFound    = rpcerror.New(codes.NotFound, rpcerror.ReasonValueNotFound, "failed to get value")
 	allowedKeys         = map[string]struct{}{
 		"routing_hints": {},
 	}
 	maxRequesTimeDiff = time.Second * 10
 )
 
+// Store is the storage of the node information.
+type Store interface {
+	UpdateKeyFields(key string, fields map[string]string) error
+	GetKeyFields(key string) (map[string]string, error)
+	SetKeyExpiration(key string, seconds int64) error
+}
+
+// Server implements breez.NodeInfoServer.
+type Server struct {
+	breez.UnimplementedNodeInfoServer
+	store Store
+}
+
+func NewServer(store Store) *Server {
+	return &Server{store: store}
+}
+
 func verifyMessage(msg, pubKey, signature []byte) (bool, error) {
 
 	if msg == nil {
@@ -58,7 +79,7 @@ func verifyMessage(msg, pubKey, signature []byte) (bool, error) {
 }
 
 // SetNodeInfo sets the meeting information by the meeting moderator. The moderator provides a proof by signing the value
-func (s *server) SetNodeInfo(ctx context.Context, in *breez.SetNodeInfoRequest) (*breez.SetNodeInfoResponse, error) {
+func (s *Server) SetNodeInfo(ctx context.Context, in *breez.SetNodeInfoRequest) (*breez.SetNodeInfoResponse, error) {
 	if _, ok := allowedKeys[in.Key]; !ok {
 		return nil, ErrKeyNotSupported
 	}
@@ -73,23 +94,23 @@ func (s *server) SetNodeInfo(ctx context.Context, in *breez.SetNodeInfoRequest)
 	// Verify the message
 	valid, err := verifyMessage([]byte(msg), in.Pubkey, in.Signature)
 	if err != nil {
-		return nil, err
+		return nil, rpcerror.New(codes.InvalidArgument, rpcerror.ReasonInvalidSignature, err.Error()).WithViolation("signature", err.Error())
 	}
 
 	if !valid {
-		return nil, errors.New("failed to verify value")
+		return nil, rpcerror.New(codes.InvalidArgument, rpcerror.ReasonInvalidSignature, "failed to verify value").WithViolation("signature", "failed to verify value")
 	}
 
 	// Update the value in redis and set expiration of 1 hour.
 	redisKey := fmt.Sprintf("%v-%v", hex.EncodeToString(in.Pubkey), in.Key)
-	if err := updateKeyFields(redisKey, map[string]string{
+	if err := s.store.UpdateKeyFields(redisKey, map[string]string{
 		"value":     hex.EncodeToString(in.Value),
 		"timestamp": strconv.FormatInt(in.Timestamp, 10),
 		"signature": hex.EncodeToString(in.Signature),
 	}); err != nil {
-		return nil, fmt.Errorf("failed to update value")
+		return nil, rpcerror.Internal(rpcerror.ReasonInternal, "failed to update value", err)
 	}
-	if err := setKeyExpiration(redisKey, 3600); err != nil {
+	if err := s.store.SetKeyExpiration(redisKey, 3600); err != nil {
 		return nil, err
 	}
 	return &breez.SetNodeInfoResponse{}, nil
@@ -97,42 +118,42 @@ func (s *server) SetNodeInfo(ctx context.Context, in *breez.SetNodeInfoRequest)
 
 // GetNodeInfo is used by other participants to get the meeting information. They should verify the message using th
 // provided signature.
-func (s *server) GetNodeInfo(ctx context.Context, in *breez.GetNodeInfoRequest) (*breez.GetNodeInfoResponse, error) {
+func (s *Server) GetNodeInfo(ctx context.Context, in *breez.GetNodeInfoRequest) (*breez.GetNodeInfoResponse, error) {
 	if _, ok := allowedKeys[in.Key]; !ok {
 		return nil, ErrKeyNotSupported
 	}
 
 	redisKey := fmt.Sprintf("%v-%v", hex.EncodeToString(in.Pubkey), in.Key)
-	fields, err := getKeyFields(redisKey)
+	fields, err := s.store.GetKeyFields(redisKey)
 	if err != nil {
 		return nil, err
 	}
 
 	value, ok := fields["value"]
 	if !ok {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 	valueBytes, err := hex.DecodeString(value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 
 	signature, ok := fields["signature"]
 	if !ok {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 	signatureBytes, err := hex.DecodeString(signature)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 
 	timestampStr, ok := fields["timestamp"]
 	if !ok {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get value")
+		return nil, errValueNotFound
 	}
 
 	return &breez.GetNodeInfoResponse{
@@ -2,115 +2,58 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
 	"os"
-	"sync"
+	"time"
 
-	"firebase.google.com/go/messaging"
-
-	firebase "firebase.google.com/go"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
+	"github.com/breez/server/config"
+	"github.com/breez/server/store"
 )
 
-var firebaseMu sync.Mutex
-
-func firebaseApp() (*firebase.App, error) {
-	firebaseMu.Lock()
-	defer firebaseMu.Unlock()
-	creds, err := google.CredentialsFromJSON(context.Background(), []byte(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")), "https://www.googleapis.com/auth/firebase.messaging")
-	if err != nil {
-		return nil, err
-	}
-	return firebase.NewApp(context.Background(), nil, option.WithCredentials(creds))
-}
-
-func notifyDataMessage(data map[string]string, token string) error {
-	app, err := firebaseApp()
-	if err != nil {
+// runNotifications runs the notifications subcommand, printing the last
+// notifications of the outbox, newest first, with their delivery outcome:
+//
+//	notifications [-token token] [-status pending|delivered|dead] [-limit n]
+func runNotifications(args []string, dev bool) error {
+	flags := flag.NewFlagSet("notifications", flag.ContinueOnError)
+	token := flags.String("token", "", "only the notifications to this device token")
+	status := flags.String("status", "", "only the notifications of this status: pending, delivered or dead")
+	limit := flags.Int("limit", 50, "the number of notifications")
+	if err := flags.Parse(args); err != nil {
 		return err
 	}
 
-	client, err := app.Messaging(context.Background())
+	databaseURL, err := config.DatabaseURL(dev)
 	if err != nil {
 		return err
 	}
-
-	iosCustomData := make(map[string]interface{})
-	for key, value := range data {
-		iosCustomData[key] = value
-	}
-
-	_, err = client.Send(context.Background(), &messaging.Message{
-		Token: token,
-		Data:  data,
-		Android: &messaging.AndroidConfig{
-			Priority: "high",
-		},
-		APNS: &messaging.APNSConfig{
-			Headers: map[string]string{
-				"apns-priority": "10",
-			},
-			Payload: &messaging.APNSPayload{
-				Aps: &messaging.Aps{
-					ContentAvailable: true,
-				},
-			},
-		},
-	})
-
-	return err
-}
-
-func notifyAlertMessage(title, body string, data map[string]string, token string) error {
-	app, err := firebaseApp()
+	ctx := context.Background()
+	pg, err := store.NewPostgres(ctx, databaseURL)
 	if err != nil {
 		return err
 	}
-
-	client, err := app.Messaging(context.Background())
+	defer pg.Close()
+	notifications, err := pg.Notifications(ctx, *token, *status, *limit)
 	if err != nil {
 		return err
 	}
-
-	if data["click_action"] == "" {
-		data["click_action"] = "FLUTTER_NOTIFICATION_CLICK"
-	}
-	data["title"] = title
-	data["body"] = body
-
-	iosCustomData := make(map[string]interface{})
-	for key, value := range data {
-		iosCustomData[key] = value
-	}
-
-	status, err := client.Send(context.Background(), &messaging.Message{
-		Token: token,
-		Data:  data,
-		Android: &messaging.AndroidConfig{
-			CollapseKey: "breez",
-			Priority:    "high",
-		},
-		APNS: &messaging.APNSConfig{
-			Headers: map[string]string{
-				"apns-priority": "5",
-			},
-			Payload: &messaging.APNSPayload{
-				Aps: &messaging.Aps{
-					Alert: &messaging.ApsAlert{
-						Title: title,
-						Body:  body,
-					},
-					CustomData: iosCustomData,
-				},
-			},
-		},
-	})
-
-	log.Printf("Alert Notification Status = %v, Error = %v", status, err)
-	return err
-}
-
-func isUnregisteredError(err error) bool {
-	return messaging.IsRegistrationTokenNotRegistered(err)
+	for _, n := range notifications {
+		outcome := n.Status
+		switch {
+		case n.DeliveredAt != nil:
+			outcome += " " + n.DeliveredAt.Format(time.RFC3339)
+		case n.Status == store.OutboxPending:
+			outcome += " next " + n.NextAttemptAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(os.Stdout, "%-8d %v %-16s %-5s attempts %-2d %v\n", n.ID, n.CreatedAt.Format(time.RFC3339),
+			n.Source, n.Kind, n.Attempts, outcome)
+		if n.Title != "" || n.Body != "" {
+			fmt.Fprintf(os.Stdout, "         %q %q\n", n.Title, n.Body)
+		}
+		if n.LastError != "" {
+			fmt.Fprintf(os.Stdout, "         last error: %v\n", n.LastError)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/breez/server/tracing"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+	// apnsTokenLifetime is the time an authentication token is reused. APNs
+	// rejects the tokens older than one hour, and the tokens renewed more
+	// than once every 20 minutes.
+	apnsTokenLifetime = 40 * time.Minute
+	apnsTimeout       = 30 * time.Second
+)
+
+// APNs sends the push notifications to the APNs device tokens directly
+// through the Apple Push Notification service, over a long-lived HTTP/2
+// connection, authenticated by a token signed with the key of the team.
+type APNs struct {
+	client  *http.Client
+	baseURL string
+	key     *ecdsa.PrivateKey
+	keyID   string
+	teamID  string
+	topic   string
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNs returns the APNs notifier of the app topic, its bundle ID,
+// authenticated with key, of keyID and teamID. sandbox sends to the
+// development environment.
+func NewAPNs(key *ecdsa.PrivateKey, keyID, teamID, topic string, sandbox bool) *APNs {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	baseURL := apnsProductionURL
+	if sandbox {
+		baseURL = apnsSandboxURL
+	}
+	return &APNs{
+		client:  &http.Client{Transport: transport, Timeout: apnsTimeout},
+		baseURL: baseURL,
+		key:     key,
+		keyID:   keyID,
+		teamID:  teamID,
+		topic:   topic,
+	}
+}
+
+// APNsError is the error returned by APNs for a notification.
+type APNsError struct {
+	Status int
+	// Reason is the reason sent by APNs, such as BadDeviceToken or
+	// Unregistered.
+	Reason string
+}
+
+func (e *APNsError) Error() string {
+	return fmt.Sprintf("apns: %v %v", e.Status, e.Reason)
+}
+
+func (n *APNs) NotifyAlert(a Alert, token string) error {
+	payload := n.payload(a.Data)
+	aps := map[string]any{
+		"alert": map[string]string{"title": a.Title, "body": a.Body},
+	}
+	if a.Category != "" {
+		aps["category"] = a.Category
+	}
+	payload["aps"] = aps
+	return n.send("alert", token, a.CollapseKey, payload)
+}
+
+func (n *APNs) NotifyDataMessage(data map[string]string, token string) error {
+	payload := n.payload(data)
+	payload["aps"] = map[string]any{"content-available": 1}
+	return n.send("background", token, "", payload)
+}
+
+// IsUnregisteredError reports whether err is the error of a token which is
+// not valid, or no longer valid, for the topic.
+func (n *APNs) IsUnregisteredError(err error) bool {
+	var apnsErr *APNsError
+	if !errors.As(err, &apnsErr) {
+		return false
+	}
+	return apnsErr.Status == http.StatusGone || apnsErr.Reason == "BadDeviceToken" ||
+		apnsErr.Reason == "DeviceTokenNotForTopic"
+}
+
+// isAPNsPermanentError reports whether err is an APNs error of the token or
+// of the notification. The authentication, rate limit and server errors are
+// transient.
+func isAPNsPermanentError(err error) bool {
+	var apnsErr *APNsError
+	if !errors.As(err, &apnsErr) {
+		return false
+	}
+	switch apnsErr.Status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
+
+// payload returns the payload with the data of the app at the top level.
+func (n *APNs) payload(data map[string]string) map[string]any {
+	payload := make(map[string]any, len(data)+1)
+	for k, v := range data {
+		payload[k] = v
+	}
+	return payload
+}
+
+// send sends the notification of pushType, alert or background, to token.
+// The notifications of a collapseID replace each other.
+func (n *APNs) send(pushType, token, collapseID string, payload map[string]any) error {
+	kind := "alert"
+	if pushType == "background" {
+		kind = "data"
+	}
+	ctx, span := tracing.Start(context.Background(), "apns Send", attribute.String("apns.push_type", pushType))
+	err := n.post(ctx, pushType, token, collapseID, payload)
+	tracing.End(span, err)
+	count("apns", kind, err, n.IsUnregisteredError(err))
+	return err
+}
+
+func (n *APNs) post(ctx context.Context, pushType, token, collapseID string, payload map[string]any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	authToken, err := n.authToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.baseURL+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+authToken)
+	req.Header.Set("apns-topic", n.topic)
+	req.Header.Set("apns-push-type", pushType)
+	req.Header.Set("apns-priority", "5")
+	req.Header.Set("content-type", "application/json")
+	if collapseID != "" {
+		req.Header.Set("apns-collapse-id", collapseID)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("apns: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&apnsErr)
+	if apnsErr.Reason == "ExpiredProviderToken" {
+		n.resetAuthToken()
+	}
+	return &APNsError{Status: resp.StatusCode, Reason: apnsErr.Reason}
+}
+
+// authToken returns the authentication token, renewed after
+// apnsTokenLifetime.
+func (n *APNs) authToken() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.token != "" && time.Since(n.issuedAt) < apnsTokenLifetime {
+		return n.token, nil
+	}
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": n.teamID,
+		"iat": now.Unix(),
+	})
+	t.Header["kid"] = n.keyID
+	signed, err := t.SignedString(n.key)
+	if err != nil {
+		return "", fmt.Errorf("signing the APNs token: %w", err)
+	}
+	n.token, n.issuedAt = signed, now
+	return signed, nil
+}
+
+func (n *APNs) resetAuthToken() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.token = ""
+}
@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"text/template"
+
+	"github.com/breez/server/auth"
+	"github.com/breez/server/breez"
+	"google.golang.org/grpc"
+)
+
+// The types of the alerts, the keys of the templates of the partners.
+const (
+	TypeReceivePayment   = "receive_payment"
+	TypeChannelOpened    = "channel_opened"
+	TypeCTPPayeeJoined   = "ctp_payee_joined"
+	TypeCTPPayerJoined   = "ctp_payer_joined"
+	TypePaymentRequest   = "payment_request"
+	TypeInactive         = "inactive"
+	TypeFundsConfirmed   = "funds_confirmed"
+	TypeFundsUnconfirmed = "funds_unconfirmed"
+	TypePushTx           = "push_tx"
+)
+
+// defaultTemplate is the key of the template of all the types, applied
+// before the template of the type.
+const defaultTemplate = "default"
+
+// Template is the branding of the alerts of a type by a partner. Its empty
+// fields keep the ones of the alert. Title and Body are text/template
+// templates of the alert, such as "{{.Body}}" or "{{.Data.value}} sat".
+type Template struct {
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+	ClickAction string `json:"click_action"`
+	CollapseKey string `json:"collapse_key"`
+	Category    string `json:"apns_category"`
+}
+
+// apply returns a with the fields of t.
+func (t Template) apply(a Alert) (Alert, error) {
+	title, err := execute(t.Title, a)
+	if err != nil {
+		return a, err
+	}
+	body, err := execute(t.Body, a)
+	if err != nil {
+		return a, err
+	}
+	if title != "" {
+		a.Title = title
+	}
+	if body != "" {
+		a.Body = body
+	}
+	if t.ClickAction != "" {
+		a.ClickAction = t.ClickAction
+	}
+	if t.CollapseKey != "" {
+		a.CollapseKey = t.CollapseKey
+	}
+	if t.Category != "" {
+		a.Category = t.Category
+	}
+	return a, nil
+}
+
+func execute(text string, a Alert) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, a); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// TemplateStore is the storage of the partners of the devices and of their
+// templates.
+type TemplateStore interface {
+	// SetDevicePartner records the partner of apiKeys as the partner of the
+	// device of token.
+	SetDevicePartner(token string, apiKeys []string) error
+	// NotificationTemplates returns the templates of the partner of the
+	// device of token by type, in json, or nil.
+	NotificationTemplates(token string) ([]byte, error)
+}
+
+// Branded sends the alerts with the templates of the partner of their
+// device, resolved when they are sent.
+type Branded struct {
+	Notifier
+	store TemplateStore
+}
+
+// NewBranded returns the notifier sending through n the alerts branded with
+// the templates of store.
+func NewBranded(n Notifier, store TemplateStore) *Branded {
+	return &Branded{Notifier: n, store: store}
+}
+
+func (b *Branded) NotifyAlert(a Alert, token string) error {
+	return b.Notifier.NotifyAlert(b.brand(a, token), token)
+}
+
+// brand applies to a the default template of the partner of the device of
+// token, then the one of the type of a. The alerts whose templates cannot be
+// read or executed are sent unbranded rather than lost.
+func (b *Branded) brand(a Alert, token string) Alert {
+	data, err := b.store.NotificationTemplates(token)
+	if err != nil {
+		slog.Warn("notify: NotificationTemplates failed, sending unbranded", "token", token, "error", err)
+		return a
+	}
+	if data == nil {
+		return a
+	}
+	var templates map[string]Template
+	if err := json.Unmarshal(data, &templates); err != nil {
+		slog.Warn("notify: invalid notification templates, sending unbranded", "token", token, "error", err)
+		return a
+	}
+	for _, key := range []string{defaultTemplate, a.Type} {
+		t, ok := templates[key]
+		if !ok || key == "" {
+			continue
+		}
+		branded, err := t.apply(a)
+		if err != nil {
+			slog.Warn("notify: invalid notification template", "type", key, "token", token, "error", err)
+			continue
+		}
+		a = branded
+	}
+	return a
+}
+
+// UnaryServerInterceptor records the partner of the API key of the requests
+// registering a notification token as the partner of the device.
+func (b *Branded) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		token, keys := RegisteredToken(req), auth.GetHeaderKeys(ctx)
+		if token != "" && len(keys) > 0 {
+			if err := b.store.SetDevicePartner(token, keys); err != nil {
+				slog.WarnContext(ctx, "notify: SetDevicePartner failed", "token", token, "error", err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// RegisteredToken returns the notification token of the device sending req,
+// when req registers it, or "".
+func RegisteredToken(req interface{}) string {
+	switch r := req.(type) {
+	case *breez.RegisterRequest:
+		return r.DeviceID
+	case *breez.PushTxNotificationRequest:
+		return r.DeviceId
+	case interface{ GetNotificationToken() string }:
+		return r.GetNotificationToken()
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+	"github.com/breez/server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// FCM sends the push notifications through Firebase Cloud Messaging, with a
+// client shared by all the notifications.
+type FCM struct {
+	client *messaging.Client
+}
+
+// NewFCM returns the FCM notifier authenticated by the service account
+// credentials, in json.
+func NewFCM(ctx context.Context, credentials []byte) (*FCM, error) {
+	creds, err := google.CredentialsFromJSON(ctx, credentials, "https://www.googleapis.com/auth/firebase.messaging")
+	if err != nil {
+		return nil, fmt.Errorf("google.CredentialsFromJSON: %w", err)
+	}
+	app, err := firebase.NewApp(ctx, nil, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("firebase.NewApp: %w", err)
+	}
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("app.Messaging: %w", err)
+	}
+	return &FCM{client: client}, nil
+}
+
+func (n *FCM) NotifyDataMessage(data map[string]string, token string) error {
+	ctx, span := tracing.Start(context.Background(), "fcm Send", attribute.String("fcm.message_type", "data"))
+	_, err := n.client.Send(ctx, &messaging.Message{
+		Token: token,
+		Data:  data,
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{
+				"apns-priority": "10",
+			},
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{
+					ContentAvailable: true,
+				},
+			},
+		},
+	})
+	tracing.End(span, err)
+	count("fcm", "data", err, n.IsUnregisteredError(err))
+
+	return err
+}
+
+func (n *FCM) NotifyAlert(a Alert, token string) error {
+	data := a.Data
+	if data == nil {
+		data = make(map[string]string)
+	}
+	if a.ClickAction != "" {
+		data["click_action"] = a.ClickAction
+	}
+	if data["click_action"] == "" {
+		data["click_action"] = "FLUTTER_NOTIFICATION_CLICK"
+	}
+	collapseKey := a.CollapseKey
+	if collapseKey == "" {
+		collapseKey = "breez"
+	}
+	data["title"] = a.Title
+	data["body"] = a.Body
+
+	iosCustomData := make(map[string]interface{})
+	for key, value := range data {
+		iosCustomData[key] = value
+	}
+
+	ctx, span := tracing.Start(context.Background(), "fcm Send", attribute.String("fcm.message_type", "alert"))
+	status, err := n.client.Send(ctx, &messaging.Message{
+		Token: token,
+		Data:  data,
+		Android: &messaging.AndroidConfig{
+			CollapseKey: collapseKey,
+			Priority:    "high",
+		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{
+				"apns-priority": "5",
+			},
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{
+					Alert: &messaging.ApsAlert{
+						Title: a.Title,
+						Body:  a.Body,
+					},
+					Category:   a.Category,
+					CustomData: iosCustomData,
+				},
+			},
+		},
+	})
+
+	tracing.End(span, err)
+	count("fcm", "alert", err, n.IsUnregisteredError(err))
+
+	log.Printf("Alert Notification Status = %v, Error = %v", status, err)
+	return err
+}
+
+func (n *FCM) IsUnregisteredError(err error) bool {
+	return err != nil && messaging.IsRegistrationTokenNotRegistered(err)
+}
+
+func isFCMPermanentError(err error) bool {
+	return err != nil && (messaging.IsRegistrationTokenNotRegistered(err) ||
+		messaging.IsInvalidArgument(err) || messaging.IsMismatchedCredential(err))
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"context"
+	"log"
+
+	"github.com/breez/server/logging"
+	"github.com/breez/server/metrics"
+)
+
+// invalidationQueueSize bounds the tokens waiting to be purged. The tokens
+// dropped when it is full are purged on their next failed notification.
+const invalidationQueueSize = 1000
+
+// Purger forgets the notification token of a device in a storage.
+type Purger interface {
+	PurgeToken(ctx context.Context, token string) error
+}
+
+// Invalidator purges the tokens whose notifications fail because they are
+// no longer registered from every storage keeping them, so that they are not
+// notified again. Run purges them in the background, out of the path of the
+// notifications.
+type Invalidator struct {
+	Notifier
+	purgers []Purger
+	tokens  chan string
+}
+
+// NewInvalidator returns the notifier sending through n and purging the
+// unregistered tokens with purgers.
+func NewInvalidator(n Notifier, purgers ...Purger) *Invalidator {
+	return &Invalidator{
+		Notifier: n,
+		purgers:  purgers,
+		tokens:   make(chan string, invalidationQueueSize),
+	}
+}
+
+func (i *Invalidator) NotifyAlert(a Alert, token string) error {
+	err := i.Notifier.NotifyAlert(a, token)
+	i.check(err, token)
+	return err
+}
+
+func (i *Invalidator) NotifyDataMessage(data map[string]string, token string) error {
+	err := i.Notifier.NotifyDataMessage(data, token)
+	i.check(err, token)
+	return err
+}
+
+// check queues token for purging when err is the error of an unregistered
+// token.
+func (i *Invalidator) check(err error, token string) {
+	if err == nil || !i.IsUnregisteredError(err) {
+		return
+	}
+	select {
+	case i.tokens <- token:
+	default:
+		log.Printf("notify: invalidation queue full, dropping %v", logging.Secret(token))
+	}
+}
+
+// Run purges the queued tokens until ctx is done.
+func (i *Invalidator) Run(ctx context.Context) {
+	for {
+		select {
+		case token := <-i.tokens:
+			i.purge(ctx, token)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purge purges token with every purger, even when some of them fail.
+func (i *Invalidator) purge(ctx context.Context, token string) {
+	var failed error
+	for _, p := range i.purgers {
+		if err := p.PurgeToken(ctx, token); err != nil {
+			log.Printf("notify: purging %v: %v", logging.Secret(token), err)
+			failed = err
+		}
+	}
+	provider := "fcm"
+	if isAPNsToken(token) {
+		provider = "apns"
+	}
+	metrics.InvalidatedTokens.WithLabelValues(provider, metrics.Result(failed)).Inc()
+}
@@ -0,0 +1,115 @@
+// Package notify sends the push notifications to the devices, through the
+// provider of their token: Firebase Cloud Messaging, or the Apple Push
+// Notification service directly. The providers keep their clients and
+// connections for the life of the server.
+package notify
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/breez/server/metrics"
+)
+
+// Alert is a notification shown to the user.
+type Alert struct {
+	// Type is the type of the notification, such as funds_confirmed, which
+	// selects the template of the partner of the device.
+	Type  string
+	Title string
+	Body  string
+	// Data is passed to the app.
+	Data map[string]string
+	// ClickAction, CollapseKey and Category, the APNs category, are the
+	// defaults of the provider when they are empty.
+	ClickAction string
+	CollapseKey string
+	Category    string
+}
+
+// Notifier sends the push notifications to the device of token.
+type Notifier interface {
+	// NotifyAlert shows the notification a.
+	NotifyAlert(a Alert, token string) error
+	// NotifyDataMessage wakes up the app in the background with data.
+	NotifyDataMessage(data map[string]string, token string) error
+	// IsUnregisteredError reports whether err is the error of a token which
+	// is no longer valid and should be forgotten.
+	IsUnregisteredError(err error) bool
+}
+
+// Router sends the notifications through the provider of the type of their
+// token: the APNs device tokens, 64 hex characters or more, to APNs when it
+// is set, and the other tokens, the FCM registration tokens, to FCM. The
+// notifications fail when FCM is not set.
+type Router struct {
+	FCM  Notifier
+	APNs Notifier
+}
+
+func (r *Router) provider(token string) Notifier {
+	if r.APNs != nil && isAPNsToken(token) {
+		return r.APNs
+	}
+	if r.FCM == nil {
+		return disabled{}
+	}
+	return r.FCM
+}
+
+func (r *Router) NotifyAlert(a Alert, token string) error {
+	return r.provider(token).NotifyAlert(a, token)
+}
+
+func (r *Router) NotifyDataMessage(data map[string]string, token string) error {
+	return r.provider(token).NotifyDataMessage(data, token)
+}
+
+func (r *Router) IsUnregisteredError(err error) bool {
+	return (r.FCM != nil && r.FCM.IsUnregisteredError(err)) ||
+		(r.APNs != nil && r.APNs.IsUnregisteredError(err))
+}
+
+// ErrNotConfigured is the error of the notifications to the tokens of a
+// provider which is not configured.
+var ErrNotConfigured = errors.New("the push notification provider is not configured")
+
+// IsPermanentError reports whether err is the error of a notification which
+// would fail again: to a token which is invalid or not registered, with an
+// invalid message, or to a provider which is not configured.
+func IsPermanentError(err error) bool {
+	return errors.Is(err, ErrNotConfigured) || isFCMPermanentError(err) || isAPNsPermanentError(err)
+}
+
+type disabled struct{}
+
+func (disabled) NotifyAlert(a Alert, token string) error {
+	return ErrNotConfigured
+}
+
+func (disabled) NotifyDataMessage(data map[string]string, token string) error {
+	return ErrNotConfigured
+}
+
+func (disabled) IsUnregisteredError(err error) bool {
+	return false
+}
+
+// isAPNsToken reports whether token is an APNs device token: at least 32
+// bytes, hex encoded. The FCM registration tokens are longer and not hex.
+func isAPNsToken(token string) bool {
+	if len(token) < 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// count counts a push notification of provider.
+func count(provider, kind string, err error, unregistered bool) {
+	result := metrics.Result(err)
+	if unregistered {
+		result = "unregistered"
+	}
+	metrics.PushNotifications.WithLabelValues(provider, kind, result).Inc()
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/breez/server/backoff"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/metrics"
+	"github.com/breez/server/store"
+)
+
+const (
+	outboxBatchSize    = 100
+	outboxPollInterval = 5 * time.Second
+	// outboxLease is the time a claimed notification is left to its worker
+	// before the others retry it.
+	outboxLease = 5 * time.Minute
+	// outboxMaxAttempts is the number of attempts before a notification
+	// failing with transient errors is dead-lettered, after about 15 hours.
+	outboxMaxAttempts   = 12
+	outboxMinRetryDelay = 30 * time.Second
+	outboxMaxRetryDelay = 6 * time.Hour
+)
+
+// OutboxStore is the storage of the outbox.
+type OutboxStore interface {
+	EnqueueNotification(n *store.OutboxNotification) error
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]store.OutboxNotification, error)
+	NotificationDelivered(ctx context.Context, id int64) error
+	RetryNotification(ctx context.Context, id int64, lastError string, at time.Time) error
+	DeadLetterNotification(ctx context.Context, id int64, lastError string) error
+}
+
+// Outbox stores the notifications before sending them, so that they survive
+// the failures of the providers and the restarts. Run delivers them, retrying
+// the transient failures with an exponential backoff and dead-lettering the
+// permanent ones, and records the outcome of each notification.
+type Outbox struct {
+	store    OutboxStore
+	notifier Notifier
+	// wake is signaled by the enqueues, to deliver without waiting for the
+	// next poll.
+	wake chan struct{}
+}
+
+// NewOutbox returns the outbox stored in store and delivered by notifier.
+func NewOutbox(store OutboxStore, notifier Notifier) *Outbox {
+	return &Outbox{
+		store:    store,
+		notifier: notifier,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// EnqueueAlert enqueues the alert a of source, the feature sending it, to
+// token. Its ClickAction, CollapseKey and Category are the ones of the
+// template of the partner of the device when it is sent.
+func (o *Outbox) EnqueueAlert(source string, a Alert, token string) error {
+	return o.enqueue(&store.OutboxNotification{
+		Source: source,
+		Kind:   store.OutboxAlert,
+		Type:   a.Type,
+		Token:  token,
+		Title:  a.Title,
+		Body:   a.Body,
+		Data:   a.Data,
+	})
+}
+
+// EnqueueData enqueues a data notification of source to token.
+func (o *Outbox) EnqueueData(source string, data map[string]string, token string) error {
+	return o.enqueue(&store.OutboxNotification{
+		Source: source,
+		Kind:   store.OutboxData,
+		Token:  token,
+		Data:   data,
+	})
+}
+
+func (o *Outbox) enqueue(n *store.OutboxNotification) error {
+	if err := o.store.EnqueueNotification(n); err != nil {
+		return err
+	}
+	o.Wake()
+	return nil
+}
+
+// Wake delivers the notifications enqueued outside of the outbox, such as in
+// a transaction of the store, without waiting for the next poll.
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the notifications of the outbox until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	var retry backoff.Backoff
+	for ctx.Err() == nil {
+		notifications, err := o.store.ClaimNotifications(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			log.Printf("outbox: ClaimNotifications: %v", err)
+			retry.Wait(ctx)
+			continue
+		}
+		retry.Reset()
+		for _, n := range notifications {
+			o.deliver(ctx, n)
+		}
+		if len(notifications) == outboxBatchSize {
+			continue
+		}
+		select {
+		case <-time.After(outboxPollInterval):
+		case <-o.wake:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// deliver sends n and records its outcome.
+func (o *Outbox) deliver(ctx context.Context, n store.OutboxNotification) {
+	var err error
+	switch n.Kind {
+	case store.OutboxData:
+		err = o.notifier.NotifyDataMessage(n.Data, n.Token)
+	default:
+		err = o.notifier.NotifyAlert(Alert{Type: n.Type, Title: n.Title, Body: n.Body, Data: n.Data}, n.Token)
+	}
+
+	var outcome string
+	switch {
+	case err == nil:
+		outcome = "delivered"
+		err = o.store.NotificationDelivered(ctx, n.ID)
+	case IsPermanentError(err) || o.notifier.IsUnregisteredError(err) || n.Attempts >= outboxMaxAttempts:
+		outcome = "dead"
+		log.Printf("outbox: notification %v of %v to %v failed after %v attempts: %v",
+			n.ID, n.Source, logging.Secret(n.Token), n.Attempts, err)
+		err = o.store.DeadLetterNotification(ctx, n.ID, err.Error())
+	default:
+		outcome = "retry"
+		err = o.store.RetryNotification(ctx, n.ID, err.Error(), time.Now().Add(retryDelay(n.Attempts)))
+	}
+	metrics.OutboxNotifications.WithLabelValues(n.Source, outcome).Inc()
+	if err != nil {
+		// The notification is claimed again once its lease expires.
+		log.Printf("outbox: recording the outcome %v of notification %v: %v", outcome, n.ID, err)
+	}
+}
+
+// retryDelay is the delay before the next attempt of a notification which
+// failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	delay := outboxMinRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxRetryDelay)
+}
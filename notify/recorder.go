@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxMessages = 1000
+
+// Message is a push notification or an email recorded by a Recorder.
+type Message struct {
+	Time time.Time `json:"time"`
+	// Kind is alert or data for the push notifications, email for the
+	// emails.
+	Kind string `json:"kind"`
+	// To is the device token of a push notification or the recipients of
+	// an email.
+	To    string            `json:"to"`
+	Title string            `json:"title,omitempty"`
+	Body  string            `json:"body,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+	// Type, ClickAction, CollapseKey and Category are the ones of the
+	// alerts.
+	Type        string `json:"type,omitempty"`
+	ClickAction string `json:"click_action,omitempty"`
+	CollapseKey string `json:"collapse_key,omitempty"`
+	Category    string `json:"category,omitempty"`
+}
+
+// Recorder logs and records the push notifications and the emails instead of
+// sending them. ServeHTTP lists the last ones in JSON.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *Recorder) record(m Message) {
+	m.Time = time.Now()
+	log.Printf("notify: %v to %v: %v %v %v", m.Kind, m.To, m.Title, m.Body, m.Data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, m)
+	if len(r.messages) > maxMessages {
+		r.messages = r.messages[len(r.messages)-maxMessages:]
+	}
+}
+
+func (r *Recorder) NotifyAlert(a Alert, token string) error {
+	r.record(Message{Kind: "alert", To: token, Title: a.Title, Body: a.Body, Data: maps.Clone(a.Data),
+		Type: a.Type, ClickAction: a.ClickAction, CollapseKey: a.CollapseKey, Category: a.Category})
+	return nil
+}
+
+func (r *Recorder) NotifyDataMessage(data map[string]string, token string) error {
+	r.record(Message{Kind: "data", To: token, Data: maps.Clone(data)})
+	return nil
+}
+
+// IsUnregisteredError returns false: every token is valid.
+func (r *Recorder) IsUnregisteredError(err error) bool {
+	return false
+}
+
+func (r *Recorder) SendEmail(to, cc, from, content, subject string) error {
+	r.record(Message{Kind: "email", To: to, Title: subject, Body: content,
+		Data: map[string]string{"cc": cc, "from": from}})
+	return nil
+}
+
+// Messages returns the recorded messages, oldest first.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(make([]Message, 0, len(r.messages)), r.messages...)
+}
+
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.Messages()); err != nil {
+		log.Printf("notify: encoding the messages: %v", err)
+	}
+}
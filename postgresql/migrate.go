@@ -0,0 +1,210 @@
+// Package postgresql holds the migrations of the database schema and applies
+// them.
+//
+// The migrations are embedded in the binary. They are named
+// NNNNNN_description.up.sql and NNNNNN_description.down.sql, numbered
+// sequentially.
+package postgresql
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// ErrSchemaVersion is returned by CheckVersion when the database schema is
+// not usable by this binary.
+var ErrSchemaVersion = errors.New("unsupported database schema version")
+
+// legacyVersions maps the versions of the migrations before they were
+// renumbered to the current ones. -1 is the empty schema.
+var legacyVersions = map[uint]int{
+	0:              -1,
+	20200130081047: 4,
+	20210201173247: 5,
+	20210218140805: 6,
+	20210613162707: 7,
+	20230214205452: 8,
+	20231026125717: 9,
+	20231120103000: 10,
+	20240304161100: 11,
+}
+
+// Migration is an embedded migration.
+type Migration struct {
+	Version     uint
+	Description string
+}
+
+// Status is the state of the schema of a database.
+type Status struct {
+	// Version is the last migration applied, 0 when none is.
+	Version uint
+	// Dirty tells whether the last migration failed half way. It must be
+	// fixed by hand.
+	Dirty      bool
+	Migrations []Migration
+}
+
+// Latest returns the version of the last migration.
+func (s *Status) Latest() uint {
+	if len(s.Migrations) == 0 {
+		return 0
+	}
+	return s.Migrations[len(s.Migrations)-1].Version
+}
+
+// Migrator applies the migrations to a database.
+type Migrator struct {
+	m          *migrate.Migrate
+	migrations []Migration
+}
+
+// NewMigrator connects to the database at databaseURL. The version of a
+// database migrated before the migrations were renumbered is converted.
+func NewMigrator(databaseURL string) (*Migrator, error) {
+	src, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("iofs.New: %w", err)
+	}
+	list, err := listMigrations(src)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open: %w", err)
+	}
+	driver, err := pgxmigrate.WithInstance(db, &pgxmigrate.Config{})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pgx.WithInstance: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("migrate.NewWithInstance: %w", err)
+	}
+	m.Log = logger{}
+	mg := &Migrator{m: m, migrations: list}
+	if err := mg.convertLegacyVersion(); err != nil {
+		mg.Close()
+		return nil, err
+	}
+	return mg, nil
+}
+
+func listMigrations(src source.Driver) ([]Migration, error) {
+	var list []Migration
+	version, err := src.First()
+	for err == nil {
+		r, description, readErr := src.ReadUp(version)
+		if readErr != nil {
+			return nil, fmt.Errorf("ReadUp(%v): %w", version, readErr)
+		}
+		r.Close()
+		list = append(list, Migration{Version: version, Description: description})
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("listing the migrations: %w", err)
+	}
+	return list, nil
+}
+
+func (mg *Migrator) convertLegacyVersion() error {
+	version, dirty, err := mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("migrate.Version: %w", err)
+	}
+	current, ok := legacyVersions[version]
+	if !ok {
+		return nil
+	}
+	if dirty {
+		return fmt.Errorf("legacy migration %v is dirty", version)
+	}
+	log.Printf("Converting the legacy schema version %v to %v", version, current)
+	if err := mg.m.Force(current); err != nil {
+		return fmt.Errorf("migrate.Force(%v): %w", current, err)
+	}
+	return nil
+}
+
+func (mg *Migrator) Close() error {
+	srcErr, dbErr := mg.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// Up applies all the pending migrations.
+func (mg *Migrator) Up() error {
+	if err := mg.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Down reverts the last steps migrations.
+func (mg *Migrator) Down(steps int) error {
+	if err := mg.m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Status returns the version of the database and the embedded migrations.
+func (mg *Migrator) Status() (*Status, error) {
+	version, dirty, err := mg.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("migrate.Version: %w", err)
+	}
+	return &Status{Version: version, Dirty: dirty, Migrations: mg.migrations}, nil
+}
+
+// CheckVersion returns an error if the schema of the database at databaseURL
+// is older than the embedded migrations or dirty. A newer schema is accepted,
+// so that a release can be rolled back without reverting its migrations.
+func CheckVersion(databaseURL string) error {
+	mg, err := NewMigrator(databaseURL)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+	status, err := mg.Status()
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("%w: dirty at version %v", ErrSchemaVersion, status.Version)
+	}
+	if status.Version < status.Latest() {
+		return fmt.Errorf("%w: %v is older than %v", ErrSchemaVersion, status.Version, status.Latest())
+	}
+	return nil
+}
+
+// logger logs the migrations applied.
+type logger struct{}
+
+func (logger) Printf(format string, v ...interface{}) {
+	log.Printf("migrate: "+format, v...)
+}
+
+func (logger) Verbose() bool {
+	return false
+}
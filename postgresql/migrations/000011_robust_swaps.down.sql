@@ -0,0 +1,7 @@
+DROP INDEX public.swap_payments_in_progress;
+
+ALTER TABLE public.swap_payments
+DROP COLUMN lock_height,
+DROP COLUMN confirmation_height,
+DROP COLUMN utxos,
+DROP COLUMN redeem_confirmed;
@@ -0,0 +1,2 @@
+ALTER TABLE public.api_keys
+DROP COLUMN rate_limits;
@@ -0,0 +1,2 @@
+ALTER TABLE public.api_keys
+ADD COLUMN rate_limits jsonb NULL;
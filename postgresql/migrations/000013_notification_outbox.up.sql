@@ -0,0 +1,18 @@
+CREATE TABLE public.notification_outbox (
+	id bigserial NOT NULL,
+	source varchar NOT NULL,
+	kind varchar NOT NULL,
+	token varchar NOT NULL,
+	title varchar NOT NULL DEFAULT '',
+	body varchar NOT NULL DEFAULT '',
+	data jsonb NOT NULL DEFAULT '{}',
+	status varchar NOT NULL DEFAULT 'pending',
+	attempts int4 NOT NULL DEFAULT 0,
+	last_error varchar NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	next_attempt_at timestamptz NOT NULL DEFAULT now(),
+	delivered_at timestamptz NULL,
+	CONSTRAINT notification_outbox_pkey PRIMARY KEY (id)
+);
+CREATE INDEX notification_outbox_pending_idx ON public.notification_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX notification_outbox_token_idx ON public.notification_outbox (token, created_at);
@@ -0,0 +1 @@
+DROP TABLE public.device_locales;
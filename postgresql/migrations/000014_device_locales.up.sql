@@ -0,0 +1,6 @@
+CREATE TABLE public.device_locales (
+	token varchar NOT NULL,
+	locale varchar NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT device_locales_pkey PRIMARY KEY (token)
+);
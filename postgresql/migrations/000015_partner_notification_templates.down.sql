@@ -0,0 +1,7 @@
+ALTER TABLE public.notification_outbox
+DROP COLUMN notification_type;
+
+DROP TABLE public.device_partners;
+
+ALTER TABLE public.api_keys
+DROP COLUMN notification_templates;
@@ -0,0 +1,12 @@
+ALTER TABLE public.api_keys
+ADD COLUMN notification_templates jsonb NULL;
+
+CREATE TABLE public.device_partners (
+	token varchar NOT NULL,
+	api_user varchar NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT device_partners_pkey PRIMARY KEY (token)
+);
+
+ALTER TABLE public.notification_outbox
+ADD COLUMN notification_type varchar NOT NULL DEFAULT '';
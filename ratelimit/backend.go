@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/breez/server/metrics"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Backend applies one request to a rate limit bucket, with the semantics of
+// the redis-cell CL.THROTTLE command.
+type Backend interface {
+	Throttle(key string, maxBurst, tokens, seconds uint) (Result, error)
+}
+
+var ErrNoRedis = errors.New("no redis connection")
+
+// RedisBackend keeps the buckets in redis, using the redis-cell module.
+type RedisBackend struct {
+	pool *redis.Pool
+}
+
+func NewRedisBackend(pool *redis.Pool) *RedisBackend {
+	return &RedisBackend{pool: pool}
+}
+
+func (b *RedisBackend) Throttle(key string, maxBurst, tokens, seconds uint) (Result, error) {
+	if b.pool == nil {
+		return Result{}, ErrNoRedis
+	}
+	redisConn := b.pool.Get()
+	defer redisConn.Close()
+	v, err := redis.Ints(redisConn.Do("CL.THROTTLE", key, maxBurst, tokens, seconds))
+	if err == redis.ErrNil {
+		return Result{RetryAfter: -1}, nil //Don't block
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("CL.THROTTLE: %w", err)
+	}
+	if len(v) < 5 {
+		return Result{}, fmt.Errorf("CL.THROTTLE: unexpected reply %v", v)
+	}
+	return Result{
+		Blocked:    v[0] == 1,
+		Limit:      v[1],
+		Remaining:  v[2],
+		RetryAfter: v[3],
+		Reset:      v[4],
+	}, nil
+}
+
+// FallbackBackend uses Primary, and Secondary when Primary fails. The buckets
+// of the two backends are independent: while Primary is down, clients get a
+// fresh bucket in Secondary.
+type FallbackBackend struct {
+	Primary   Backend
+	Secondary Backend
+
+	primary failureLog
+}
+
+func (b *FallbackBackend) Throttle(key string, maxBurst, tokens, seconds uint) (Result, error) {
+	r, err := b.Primary.Throttle(key, maxBurst, tokens, seconds)
+	b.primary.report("rate limit primary backend", "using fallback", err)
+	if err == nil {
+		return r, nil
+	}
+	metrics.RateLimitBackendErrors.WithLabelValues("primary").Inc()
+	return b.Secondary.Throttle(key, maxBurst, tokens, seconds)
+}
+
+// failureLog logs the transitions of a backend between healthy and failing,
+// rather than every failure: the failures are counted by
+// metrics.RateLimitBackendErrors.
+type failureLog struct {
+	failing atomic.Bool
+}
+
+// report records the result err of a call to the backend named name. action
+// is what is done while it fails.
+func (f *failureLog) report(name, action string, err error) {
+	if err != nil {
+		if !f.failing.Swap(true) {
+			log.Printf("%v is failing: %v; %v", name, err, action)
+		}
+		return
+	}
+	if f.failing.Load() && f.failing.Swap(false) {
+		log.Printf("%v is healthy again", name)
+	}
+}
+
+// NewBackend returns the backend named kind:
+//
+//	redis:    redis-cell only
+//	memory:   in-process GCRA only
+//	fallback: redis-cell, and in-process GCRA when redis fails (default)
+func NewBackend(kind string, pool *redis.Pool) (Backend, error) {
+	switch kind {
+	case "redis":
+		return NewRedisBackend(pool), nil
+	case "memory":
+		return NewMemoryBackend(), nil
+	case "", "fallback":
+		return &FallbackBackend{Primary: NewRedisBackend(pool), Secondary: NewMemoryBackend()}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", kind)
+	}
+}
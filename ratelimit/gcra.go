@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// MemoryBackend is an in-process implementation of the GCRA algorithm used by
+// CL.THROTTLE. Buckets are only shared by the requests handled by this
+// process.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time // theoretical arrival time of every bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		tats: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+func (b *MemoryBackend) Throttle(key string, maxBurst, tokens, seconds uint) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.sweep(now)
+
+	emissionInterval := time.Duration(seconds) * time.Second / time.Duration(tokens)
+	delayTolerance := emissionInterval * time.Duration(maxBurst+1)
+	r := Result{Limit: int(maxBurst + 1), RetryAfter: -1}
+
+	tat, ok := b.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(emissionInterval)
+	allowAt := newTat.Add(-delayTolerance)
+
+	var ttl time.Duration
+	if diff := now.Sub(allowAt); diff < 0 {
+		r.Blocked = true
+		r.RetryAfter = int((-diff).Seconds())
+		ttl = tat.Sub(now)
+	} else {
+		ttl = newTat.Sub(now)
+		b.tats[key] = newTat
+	}
+
+	if next := delayTolerance - ttl; next > -emissionInterval {
+		r.Remaining = int(next / emissionInterval)
+	}
+	r.Reset = int(ttl.Seconds())
+	return r, nil
+}
+
+// sweep removes the buckets that are full again, so that the map doesn't grow
+// with every client ever seen.
+func (b *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+	for key, tat := range b.tats {
+		if tat.Before(now) {
+			delete(b.tats, key)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/breez/server/auth"
+	"github.com/breez/server/clientip"
+)
+
+// httpClientIP returns the client ip resolved by the clientip middleware, or
+// the remote address when the middleware is not installed.
+func httpClientIP(req *http.Request) string {
+	if ip := clientip.FromContext(req.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// HTTPMiddleware returns a middleware enforcing the http rules of the policy
+// on a handler registered in an http.ServeMux. The rule is selected by the
+// pattern which matched the request. per_api_key buckets are keyed by the
+// serial number of the client certificate: for authenticated handlers, the
+// middleware has to be inside auth.CertAuthenticator.AuthenticatedHandler.
+func (l *Limiter) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := l.compiled.Load()
+		r := c.httpRule(req.Pattern)
+		if r == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		keys := func() []keyBucket {
+			if cert := auth.GetCert(req); cert != nil {
+				return []keyBucket{{id: "cert/" + cert.SerialNumber.String(), limit: r.PerAPIKey}}
+			}
+			return nil
+		}
+		res, limited := l.throttle(c.policy, r, "/http/"+req.Pattern, httpClientIP(req), keys, "")
+		if !limited {
+			next.ServeHTTP(w, req)
+			return
+		}
+		for k, v := range res.headers() {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+		if res.Blocked {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// Limit is a CL.THROTTLE bucket: maxBurst requests on top of tokens requests
+// every seconds seconds.
+type Limit struct {
+	MaxBurst uint `yaml:"max_burst" json:"max_burst"`
+	Tokens   uint `yaml:"tokens" json:"tokens"`
+	Seconds  uint `yaml:"seconds" json:"seconds"`
+}
+
+// defaultNodeField is the request field holding the node pubkey of per_node
+// limits.
+const defaultNodeField = "pubkey"
+
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+)
+
+// Rule applies its limits to every method matching one of Methods. PerNode
+// buckets are keyed by the NodeField field of the request (pubkey by
+// default), which the client can set to any node. OnError overrides the fail policy of the Policy for these methods.
+type Rule struct {
+	Methods   []string `yaml:"methods"`
+	PerIP     *Limit   `yaml:"per_ip"`
+	Global    *Limit   `yaml:"global"`
+	PerAPIKey *Limit   `yaml:"per_api_key"`
+	PerNode   *Limit   `yaml:"per_node"`
+	NodeField string   `yaml:"node_field"`
+	OnError   string   `yaml:"on_error"`
+}
+
+// Policy is the declarative rate limit configuration. It is read from a YAML
+// (or JSON) document. OnError is the fail policy used when the backend
+// cannot be reached: requests are let through when it is "open" and rejected
+// when it is "closed" (the default).
+//
+// Rules apply to grpc methods and HTTPRules to the http.ServeMux patterns of
+// the http server. The environment variables referenced as ${NAME} in the
+// patterns of HTTPRules are expanded.
+type Policy struct {
+	Prefix    string `yaml:"prefix"`
+	OnError   string `yaml:"on_error"`
+	Rules     []Rule `yaml:"rules"`
+	HTTPRules []Rule `yaml:"http_rules"`
+}
+
+// ParsePolicy parses a YAML or JSON policy document. The ${NAME} references
+// of the http patterns are replaced by vars[NAME], the settings of the
+// configuration.
+func ParsePolicy(data []byte, vars map[string]string) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("yaml.Unmarshal: %w", err)
+	}
+	if p.Prefix == "" {
+		p.Prefix = "rate-limit"
+	}
+	if p.OnError == "" {
+		p.OnError = FailClosed
+	}
+	for i := range p.Rules {
+		if p.Rules[i].NodeField == "" {
+			p.Rules[i].NodeField = defaultNodeField
+		}
+	}
+	var unknown []string
+	expand := func(name string) string {
+		v, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, name)
+		}
+		return v
+	}
+	for i := range p.HTTPRules {
+		for j, pattern := range p.HTTPRules[i].Methods {
+			p.HTTPRules[i].Methods[j] = os.Expand(pattern, expand)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown settings %v in the http patterns", strings.Join(unknown, ", "))
+	}
+	return &p, nil
+}
+
+// LoadPolicy reads the policy in filename, or the default policy when
+// filename is empty, expanding vars as ParsePolicy.
+func LoadPolicy(filename string, vars map[string]string) (*Policy, error) {
+	if filename == "" {
+		return ParsePolicy(defaultPolicy, vars)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(%v): %w", filename, err)
+	}
+	p, err := ParsePolicy(data, vars)
+	if err != nil {
+		return nil, fmt.Errorf("ParsePolicy(%v): %w", filename, err)
+	}
+	return p, nil
+}
+
+func validFailPolicy(onError string) bool {
+	return onError == "" || onError == FailOpen || onError == FailClosed
+}
+
+func (l *Limit) validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Tokens == 0 || l.Seconds == 0 {
+		return fmt.Errorf("tokens and seconds must be positive")
+	}
+	return nil
+}
+
+// requestDescriptor returns the descriptor of the request message of a
+// method, from the global protobuf registry.
+func requestDescriptor(fullMethod string) (protoreflect.MessageDescriptor, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(fullMethod, "/"), "/", "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("FindDescriptorByName(%v): %w", name, err)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a method", name)
+	}
+	return md.Input(), nil
+}
+
+// checkNodeField checks that the request of a method has a string or bytes
+// field named field.
+func checkNodeField(fullMethod, field string) error {
+	input, err := requestDescriptor(fullMethod)
+	if err != nil {
+		return err
+	}
+	fd := input.Fields().ByName(protoreflect.Name(field))
+	if fd == nil || fd.IsList() || (fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BytesKind) {
+		return fmt.Errorf("%v has no string or bytes field %v", input.FullName(), field)
+	}
+	return nil
+}
+
+// Methods returns the full method names of the services registered in a grpc
+// server, as returned by grpc.Server.GetServiceInfo.
+func Methods(services map[string]grpc.ServiceInfo) []string {
+	var methods []string
+	for name, info := range services {
+		for _, m := range info.Methods {
+			methods = append(methods, "/"+name+"/"+m.Name)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Validate checks the policy against the lists of registered grpc methods and
+// http patterns. Every pattern has to match at least one of them, so that a
+// typo in a method name doesn't silently disable a limit.
+func (p *Policy) Validate(methods, httpPatterns []string) error {
+	if !validFailPolicy(p.OnError) {
+		return fmt.Errorf("on_error: must be %q or %q", FailOpen, FailClosed)
+	}
+	if err := validateRules(p.Rules, methods, true); err != nil {
+		return err
+	}
+	if err := validateRules(p.HTTPRules, httpPatterns, false); err != nil {
+		return fmt.Errorf("http_rules: %w", err)
+	}
+	return nil
+}
+
+func validateRules(rules []Rule, names []string, isGRPC bool) error {
+	seen := make(map[string]int)
+	for i, r := range rules {
+		if !validFailPolicy(r.OnError) {
+			return fmt.Errorf("rule %v: on_error: must be %q or %q", i, FailOpen, FailClosed)
+		}
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("rule %v: no methods", i)
+		}
+		if r.PerIP == nil && r.Global == nil && r.PerAPIKey == nil && r.PerNode == nil {
+			return fmt.Errorf("rule %v: no limits", i)
+		}
+		if r.PerNode != nil && !isGRPC {
+			return fmt.Errorf("rule %v: per_node is only supported for grpc methods", i)
+		}
+		for name, l := range map[string]*Limit{"per_ip": r.PerIP, "global": r.Global, "per_api_key": r.PerAPIKey, "per_node": r.PerNode} {
+			if err := l.validate(); err != nil {
+				return fmt.Errorf("rule %v: %v: %w", i, name, err)
+			}
+		}
+		for _, pattern := range r.Methods {
+			if j, ok := seen[pattern]; ok {
+				return fmt.Errorf("rule %v: %v already used in rule %v", i, pattern, j)
+			}
+			seen[pattern] = i
+			matched := false
+			for _, m := range names {
+				ok, err := path.Match(pattern, m)
+				if err != nil {
+					return fmt.Errorf("rule %v: bad pattern %v: %w", i, pattern, err)
+				}
+				matched = matched || ok
+				if ok && r.PerNode != nil {
+					if err := checkNodeField(m, r.NodeField); err != nil {
+						return fmt.Errorf("rule %v: per_node: %w", i, err)
+					}
+				}
+			}
+			if !matched {
+				return fmt.Errorf("rule %v: %v doesn't match any registered method", i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// failOpen tells whether requests matching r are let through when the backend
+// fails.
+func (p *Policy) failOpen(r *Rule) bool {
+	if r.OnError != "" {
+		return r.OnError == FailOpen
+	}
+	return p.OnError == FailOpen
+}
+
+// rule returns the first rule matching fullMethod.
+func (p *Policy) rule(fullMethod string) *Rule {
+	return matchRule(p.Rules, fullMethod)
+}
+
+// httpRule returns the first http rule matching an http.ServeMux pattern.
+func (p *Policy) httpRule(pattern string) *Rule {
+	return matchRule(p.HTTPRules, pattern)
+}
+
+func matchRule(rules []Rule, name string) *Rule {
+	for i := range rules {
+		for _, pattern := range rules[i].Methods {
+			if ok, _ := path.Match(pattern, name); ok {
+				return &rules[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/breez/server/auth"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	quotaTTL = 5 * time.Minute
+	// quotaNegativeTTL is the time the unknown keys, or whose lookup failed,
+	// are cached: a key costs one lookup, and a partner whose lookup failed
+	// gets its bucket soon.
+	quotaNegativeTTL = 30 * time.Second
+	quotaCacheLimit  = 10000
+)
+
+// QuotaOverrides returns the per_api_key limits of a partner, keyed by method
+// pattern, overriding the limits of the policy, and whether apiKey is the key
+// of a partner at all. It returns nil limits when the partner has no
+// overrides.
+type QuotaOverrides func(apiKey string) (limits map[string]Limit, known bool, err error)
+
+type quotaEntry struct {
+	limits  map[string]Limit
+	known   bool
+	expires time.Time
+}
+
+// quotaCache keeps the overrides of every partner for quotaTTL, so that the
+// database is not queried on every request. The concurrent lookups of a key
+// are merged, and made without holding the cache.
+type quotaCache struct {
+	lookup QuotaOverrides
+	group  singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]quotaEntry
+	now     func() time.Time
+}
+
+func newQuotaCache(lookup QuotaOverrides) *quotaCache {
+	return &quotaCache{
+		lookup:  lookup,
+		entries: make(map[string]quotaEntry),
+		now:     time.Now,
+	}
+}
+
+// overrides returns the cached entry of apiKey, looking it up when missing or
+// expired. The keys are unknown when there is no lookup.
+func (c *quotaCache) overrides(apiKey string) quotaEntry {
+	if c == nil || c.lookup == nil {
+		return quotaEntry{}
+	}
+	hash := auth.KeyHash(apiKey)
+	c.mu.Lock()
+	e, ok := c.entries[hash]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e
+	}
+	v, _, _ := c.group.Do(hash, func() (interface{}, error) {
+		return c.load(apiKey, hash), nil
+	})
+	return v.(quotaEntry)
+}
+
+// load looks up the overrides of apiKey, of hash, and caches them. A key
+// whose lookup failed is cached as unknown.
+func (c *quotaCache) load(apiKey, hash string) quotaEntry {
+	limits, known, err := c.lookup(apiKey)
+	if err != nil {
+		log.Printf("rate limit quota lookup error for %v: %v", hash, err)
+		limits, known = nil, false
+	}
+	for pattern, l := range limits {
+		if err := l.validate(); err != nil {
+			log.Printf("invalid rate limit quota %v for %v: %v", pattern, hash, err)
+			delete(limits, pattern)
+		}
+	}
+	ttl := quotaTTL
+	if !known {
+		ttl = quotaNegativeTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if _, ok := c.entries[hash]; !ok && len(c.entries) >= quotaCacheLimit {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Evict random entries when none expired, rather than growing.
+		for k := range c.entries {
+			if len(c.entries) < quotaCacheLimit {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	e := quotaEntry{limits: limits, known: known, expires: now.Add(ttl)}
+	c.entries[hash] = e
+	return e
+}
+
+// limit returns the per_api_key limit of a partner for fullMethod: the
+// override of the method if there is one, or def. An exact method name
+// overrides a pattern, and a longer pattern overrides a shorter one. It
+// returns false when apiKey is not the key of a partner.
+func (c *quotaCache) limit(apiKey, fullMethod string, def *Limit) (*Limit, bool) {
+	e := c.overrides(apiKey)
+	if !e.known {
+		return nil, false
+	}
+	limits := e.limits
+	var best string
+	var found bool
+	for pattern := range limits {
+		if ok, _ := path.Match(pattern, fullMethod); !ok {
+			continue
+		}
+		if !found || pattern == fullMethod || (best != fullMethod && len(pattern) > len(best)) {
+			best, found = pattern, true
+		}
+	}
+	if !found {
+		return def, true
+	}
+	l := limits[best]
+	return &l, true
+}
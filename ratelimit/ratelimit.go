@@ -2,95 +2,381 @@ package ratelimit
 
 import (
 	"context"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"github.com/breez/server/auth"
+	"github.com/breez/server/clientip"
+	"github.com/breez/server/metrics"
+	"github.com/breez/server/rpcerror"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-func getIP(ctx context.Context, proxyAddress string) string {
+// clientIP returns the client ip resolved by the clientip interceptor, or the
+// peer address when the interceptor is not installed.
+func clientIP(ctx context.Context) string {
+	if ip := clientip.FromContext(ctx); ip != "" {
+		return ip
+	}
 	p, ok := peer.FromContext(ctx)
 	if !ok {
 		log.Printf("peer error.")
+		return ""
 	}
-	var srcIP string
 	switch addr := p.Addr.(type) {
 	case *net.UDPAddr:
-		srcIP = addr.IP.String()
+		return addr.IP.String()
 	case *net.TCPAddr:
-		srcIP = addr.IP.String()
-	}
-	if srcIP == proxyAddress {
-		md, ok := metadata.FromIncomingContext(ctx)
-		//log.Println(ok, md)
-		if ok {
-			realIP := md["x-forwarded-for"]
-			if len(realIP) > 0 {
-				srcIP = realIP[0]
-			}
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// Result is the state of a bucket after a request, as returned by
+// CL.THROTTLE. RetryAfter and Reset are in seconds; RetryAfter is -1 when the
+// request is not blocked.
+type Result struct {
+	Blocked    bool
+	Limit      int
+	Remaining  int
+	RetryAfter int
+	Reset      int
+}
+
+// compiledPolicy is a validated policy with the rule of every registered
+// method and http pattern resolved once, so that a request only costs a map
+// lookup.
+type compiledPolicy struct {
+	policy    *Policy
+	byMethod  map[string]*Rule
+	byPattern map[string]*Rule
+}
+
+func compile(p *Policy, methods, httpPatterns []string) (*compiledPolicy, error) {
+	if err := p.Validate(methods, httpPatterns); err != nil {
+		return nil, err
+	}
+	byMethod := make(map[string]*Rule, len(methods))
+	for _, m := range methods {
+		if r := p.rule(m); r != nil {
+			byMethod[m] = r
 		}
 	}
-	return srcIP
+	byPattern := make(map[string]*Rule, len(httpPatterns))
+	for _, pattern := range httpPatterns {
+		if r := p.httpRule(pattern); r != nil {
+			byPattern[pattern] = r
+		}
+	}
+	return &compiledPolicy{policy: p, byMethod: byMethod, byPattern: byPattern}, nil
+}
+
+func (c *compiledPolicy) rule(fullMethod string) *Rule {
+	if r, ok := c.byMethod[fullMethod]; ok {
+		return r
+	}
+	return c.policy.rule(fullMethod)
+}
+
+func (c *compiledPolicy) httpRule(pattern string) *Rule {
+	if r, ok := c.byPattern[pattern]; ok {
+		return r
+	}
+	return c.policy.httpRule(pattern)
+}
+
+// Limiter is a grpc interceptor and an http middleware enforcing a rate limit
+// Policy.
+type Limiter struct {
+	backend  Backend
+	filename string
+	vars     map[string]string
+	quotas   *quotaCache
+	// backendState logs the failures of backend.
+	backendState failureLog
+
+	mu           sync.Mutex
+	methods      []string
+	httpPatterns []string
+	compiled     atomic.Pointer[compiledPolicy]
+}
+
+// NewLimiter creates a limiter keeping its buckets in backend, for the policy
+// in filename, or for the default policy when filename is empty, whose http
+// patterns reference the settings vars. The per_api_key buckets are applied
+// to the API keys known by quotas, whose limits override the ones of the
+// policy: there are none when quotas is nil. The policy is
+// not enforced until the limiter is validated against the registered
+// services using Validate.
+func NewLimiter(backend Backend, filename string, vars map[string]string, quotas QuotaOverrides) (*Limiter, error) {
+	p, err := LoadPolicy(filename, vars)
+	if err != nil {
+		return nil, err
+	}
+	l := &Limiter{
+		backend:  backend,
+		filename: filename,
+		vars:     vars,
+		quotas:   newQuotaCache(quotas),
+	}
+	l.compiled.Store(&compiledPolicy{policy: p})
+	return l, nil
+}
+
+// Validate checks the policy against the services registered in a grpc server
+// and the patterns registered in the http.ServeMux of the http server, and
+// starts enforcing it.
+func (l *Limiter) Validate(services map[string]grpc.ServiceInfo, httpPatterns []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	methods := Methods(services)
+	c, err := compile(l.compiled.Load().policy, methods, httpPatterns)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit policy: %w", err)
+	}
+	l.methods = methods
+	l.httpPatterns = httpPatterns
+	l.compiled.Store(c)
+	return nil
 }
 
-/*
-limit: X-RateLimit-Limit
-remaining: X-RateLimit-Remaining
-retryAfter: Retry-After
-reset: X-RateLimit-Reset
-*/
-func getThrottle(redisPool *redis.Pool, key string, maxBurst, tokens, seconds uint) (blocked bool, limit, remaining, retryAfter, reset int) {
-	redisConn := redisPool.Get()
-	defer redisConn.Close()
-	r, err := redis.Ints(redisConn.Do("CL.THROTTLE", key, maxBurst, tokens, seconds))
-	if err == redis.ErrNil {
-		return //Don't block
+// Reload reads the policy file again. The current policy is kept if the new
+// one is invalid.
+func (l *Limiter) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, err := LoadPolicy(l.filename, l.vars)
+	if err != nil {
+		return err
 	}
+	c, err := compile(p, l.methods, l.httpPatterns)
 	if err != nil {
-		log.Printf("getThrottle error: %v", err)
+		return fmt.Errorf("invalid rate limit policy: %w", err)
+	}
+	l.compiled.Store(c)
+	log.Printf("rate limit policy reloaded: %v rules, %v http rules", len(p.Rules), len(p.HTTPRules))
+	return nil
+}
+
+// nodeID returns the value of the field of a request holding a node pubkey,
+// hex encoded if it is a bytes field, or "" if there is none.
+func nodeID(req interface{}, field string) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	msg := m.ProtoReflect()
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(field))
+	if fd == nil || fd.IsList() {
+		return ""
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return msg.Get(fd).String()
+	case protoreflect.BytesKind:
+		return hex.EncodeToString(msg.Get(fd).Bytes())
+	}
+	return ""
+}
+
+// keyBucket is the per_api_key bucket of a client key: an API key or a
+// client certificate.
+type keyBucket struct {
+	id    string
+	limit *Limit
+}
+
+// throttle applies the buckets of the rule to a request and returns the most
+// restrictive result. name identifies the method or http pattern in the
+// bucket keys. keys returns the per_api_key buckets, when not nil: it is
+// called once the per_ip bucket passed, so that the clients blocked by it
+// cost no quota lookup. When the backend fails, the request is let through
+// or blocked according to the fail policy of the rule.
+func (l *Limiter) throttle(p *Policy, r *Rule, name, ip string, keys func() []keyBucket, node string) (res Result, limited bool) {
+	prefix := p.Prefix
+	apply := func(bucket, key string, limit *Limit) bool {
+		t, err := l.backend.Throttle(key, limit.MaxBurst, limit.Tokens, limit.Seconds)
+		l.backendState.report("rate limit backend", "applying the fail policies", err)
+		if err != nil {
+			metrics.RateLimitBackendErrors.WithLabelValues(name).Inc()
+			if p.failOpen(r) {
+				return false
+			}
+			t = Result{Blocked: true, RetryAfter: 1}
+		}
+		if !limited || t.Blocked || (!res.Blocked && t.Remaining < res.Remaining) {
+			res = t
+		}
+		limited = true
+		if t.Blocked {
+			metrics.RateLimitRejected.WithLabelValues(name, bucket).Inc()
+		}
+		return t.Blocked
+	}
+	if r.PerIP != nil {
+		if apply("per_ip", prefix+"/ip/"+ip+name, r.PerIP) {
+			return
+		}
+	}
+	var buckets []keyBucket
+	if keys != nil {
+		buckets = keys()
+	}
+	for _, k := range buckets {
+		if k.limit != nil && apply("per_api_key", prefix+"/"+k.id+name, k.limit) {
+			return
+		}
+	}
+	if r.PerNode != nil && node != "" {
+		if apply("per_node", prefix+"/node/"+node+name, r.PerNode) {
+			return
+		}
 	}
-	if len(r) >= 5 {
-		blocked = r[0] == 1
-		limit = r[1]
-		remaining = r[2]
-		retryAfter = r[3]
-		reset = r[4]
-	} else {
-		blocked = true
+	if r.Global != nil {
+		apply("global", prefix+name, r.Global)
 	}
 	return
 }
 
-func UnaryRateLimiter(redisPool *redis.Pool, prefix, fullMethod string, maxBurst, tokens, seconds uint) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if info.FullMethod != fullMethod {
-			return handler(ctx, req)
+// headers returns the rate limit state in the form of the usual HTTP rate
+// limit headers.
+func (r Result) headers() metadata.MD {
+	md := metadata.Pairs(
+		"x-ratelimit-limit", strconv.Itoa(r.Limit),
+		"x-ratelimit-remaining", strconv.Itoa(r.Remaining),
+		"x-ratelimit-reset", strconv.Itoa(r.Reset),
+	)
+	if r.Blocked {
+		md.Set("retry-after", strconv.Itoa(r.RetryAfter))
+	}
+	return md
+}
+
+// exhausted returns the ResourceExhausted error of a blocked request, with a
+// RetryInfo detail telling the client when to retry.
+func (r Result) exhausted(fullMethod string) error {
+	retryAfter := max(r.RetryAfter, 0)
+	err := rpcerror.New(codes.ResourceExhausted, rpcerror.ReasonRateLimited,
+		fmt.Sprintf("%s is rejected by ratelimit, please retry later.", fullMethod))
+	err.Details = append(err.Details, &errdetails.RetryInfo{
+		RetryDelay: durationpb.New(time.Duration(retryAfter) * time.Second),
+	})
+	return err
+}
+
+// keyBuckets returns the function returning the per_api_key bucket of the
+// bearer API key of a request. Only the first key is looked up, and only the
+// keys of a partner get a bucket: a client cannot dodge the limit, nor load
+// the database, by sending random keys.
+func (l *Limiter) keyBuckets(ctx context.Context, fullMethod string, r *Rule) func() []keyBucket {
+	return func() []keyBucket {
+		keys := auth.GetHeaderKeys(ctx)
+		if len(keys) == 0 {
+			return nil
 		}
-		blocked, limit, remaining, retryAfter, reset := getThrottle(redisPool, prefix+"/method"+info.FullMethod, maxBurst, tokens, seconds)
-		if blocked {
-			_, _, _, _ = limit, remaining, retryAfter, reset //Need to add headers
-			return nil, status.Errorf(codes.ResourceExhausted, "%s is rejected by ratelimit, please retry later.", info.FullMethod)
+		limit, ok := l.quotas.limit(keys[0], fullMethod, r.PerAPIKey)
+		if !ok {
+			return nil
 		}
-		return handler(ctx, req)
+		return []keyBucket{{id: "key/" + auth.KeyHash(keys[0]), limit: limit}}
 	}
 }
 
-func PerIPUnaryRateLimiter(redisPool *redis.Pool, proxyAddress, prefix, fullMethod string, maxBurst, tokens, seconds uint) grpc.UnaryServerInterceptor {
+// UnaryInterceptor returns the interceptor enforcing the policy. The state of
+// the most restrictive bucket is sent to the client in the response headers.
+func (l *Limiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if info.FullMethod != fullMethod {
+		c := l.compiled.Load()
+		r := c.rule(info.FullMethod)
+		if r == nil {
 			return handler(ctx, req)
 		}
-		srcIP := getIP(ctx, proxyAddress)
-		blocked, limit, remaining, retryAfter, reset := getThrottle(redisPool, prefix+"/ip/"+srcIP+"/method"+info.FullMethod, maxBurst, tokens, seconds)
-		if blocked {
-			_, _, _, _ = limit, remaining, retryAfter, reset //Need to add headers
-			return nil, status.Errorf(codes.ResourceExhausted, "%s is rejected by ratelimit, please retry later.", info.FullMethod)
+		var node string
+		if r.PerNode != nil {
+			node = nodeID(req, r.NodeField)
+		}
+		res, limited := l.throttle(c.policy, r, "/method"+info.FullMethod, clientIP(ctx), l.keyBuckets(ctx, info.FullMethod, r), node)
+		if !limited {
+			return handler(ctx, req)
+		}
+		if err := grpc.SetHeader(ctx, res.headers()); err != nil {
+			log.Printf("grpc.SetHeader error: %v", err)
+		}
+		if res.Blocked {
+			grpc.SetTrailer(ctx, res.headers())
+			return nil, res.exhausted(info.FullMethod)
 		}
 		return handler(ctx, req)
 	}
 }
+
+// StreamInterceptor returns the interceptor enforcing the policy on streaming
+// methods. The buckets are applied once, when the stream is opened, except
+// for the per_node bucket which is applied to the first message received from
+// the client, holding the node pubkey.
+func (l *Limiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		c := l.compiled.Load()
+		r := c.rule(info.FullMethod)
+		if r == nil {
+			return handler(srv, ss)
+		}
+		res, limited := l.throttle(c.policy, r, "/method"+info.FullMethod, clientIP(ctx), l.keyBuckets(ctx, info.FullMethod, r), "")
+		if limited {
+			if err := ss.SetHeader(res.headers()); err != nil {
+				log.Printf("SetHeader error: %v", err)
+			}
+			if res.Blocked {
+				ss.SetTrailer(res.headers())
+				return res.exhausted(info.FullMethod)
+			}
+		}
+		if r.PerNode != nil {
+			ss = &nodeLimitedStream{ServerStream: ss, limiter: l, policy: c.policy, rule: r, fullMethod: info.FullMethod}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// nodeLimitedStream applies the per_node bucket of a rule to the first
+// message of a stream.
+type nodeLimitedStream struct {
+	grpc.ServerStream
+	limiter    *Limiter
+	policy     *Policy
+	rule       *Rule
+	fullMethod string
+	received   bool
+}
+
+func (s *nodeLimitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil || s.received {
+		return err
+	}
+	s.received = true
+	node := nodeID(m, s.rule.NodeField)
+	if node == "" {
+		return nil
+	}
+	nodeRule := &Rule{PerNode: s.rule.PerNode, OnError: s.rule.OnError}
+	res, _ := s.limiter.throttle(s.policy, nodeRule, "/method"+s.fullMethod, "", nil, node)
+	if res.Blocked {
+		s.SetTrailer(res.headers())
+		return res.exhausted(s.fullMethod)
+	}
+	return nil
+}
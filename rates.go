@@ -14,7 +14,7 @@ const (
 
 func (s *server) Rates(ctx context.Context, in *breez.RatesRequest) (*breez.RatesReply, error) {
 
-	ratesMap, err := getKeyFields(ratesKey)
+	ratesMap, err := s.redis.GetKeyFields(ratesKey)
 	if err != nil {
 		log.Printf("Error in getKeyFields(\"%s\"): %v", ratesKey, err)
 		return nil, err
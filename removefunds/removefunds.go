@@ -0,0 +1,171 @@
+// Package removefunds moves the funds of a user on chain: the user pays an
+// invoice and the server sends the amount received to a bitcoin address.
+package removefunds
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/breez/server/breez"
+	"github.com/breez/server/rpcerror"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/message"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	removeFundTimeout = 3600
+	minRemoveFund     = 50000
+)
+
+// LightningClient is the part of lnrpc.LightningClient used to receive the
+// payments and send the coins.
+type LightningClient interface {
+	AddInvoice(ctx context.Context, in *lnrpc.Invoice, opts ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error)
+	LookupInvoice(ctx context.Context, in *lnrpc.PaymentHash, opts ...grpc.CallOption) (*lnrpc.Invoice, error)
+	SendCoins(ctx context.Context, in *lnrpc.SendCoinsRequest, opts ...grpc.CallOption) (*lnrpc.SendCoinsResponse, error)
+}
+
+// Store is the storage of the requests, by payment hash.
+type Store interface {
+	UpdateKeyFields(key string, fields map[string]string) error
+	GetKeyFields(key string) (map[string]string, error)
+}
+
+// Server implements the RemoveFund and RedeemRemovedFunds methods of
+// breez.FundManagerServer.
+type Server struct {
+	network     *chaincfg.Params
+	macaroonHex string
+	client      LightningClient
+	store       Store
+	payReqGroup singleflight.Group
+}
+
+func NewServer(network *chaincfg.Params, macaroonHex string, client LightningClient, store Store) *Server {
+	return &Server{
+		network:     network,
+		macaroonHex: macaroonHex,
+		client:      client,
+		store:       store,
+	}
+}
+
+func (s *Server) RemoveFund(ctx context.Context, in *breez.RemoveFundRequest) (*breez.RemoveFundReply, error) {
+	address := in.Address
+	amount := in.Amount
+	if address == "" {
+		return nil, rpcerror.InvalidArgument("address", "Destination address must not be empty")
+	}
+
+	_, err := btcutil.DecodeAddress(address, s.network)
+	if err != nil {
+		log.Println("Destination address must be a valid bitcoin address")
+		return nil, rpcerror.InvalidArgument("address", "Destination address must be a valid bitcoin address").Wrap(err)
+	}
+
+	if amount <= 0 {
+		return nil, rpcerror.InvalidArgument("amount", "Amount must be positive")
+	}
+
+	if amount < minRemoveFund {
+		p := message.NewPrinter(message.MatchLanguage("en"))
+		satFormatted := strings.Replace(p.Sprintf("%d", minRemoveFund), ",", " ", 1)
+		btcFormatted := strconv.FormatFloat(float64(minRemoveFund)/float64(100000000), 'f', -1, 64)
+		errorStr := fmt.Sprintf("Removed funds must be more than  %v BTC (%v Sat).", btcFormatted, satFormatted)
+		return &breez.RemoveFundReply{ErrorMessage: errorStr}, nil
+	}
+
+	paymentRequest, err := s.createRemoveFundPaymentRequest(amount, address)
+	if err != nil {
+		log.Printf("createRemoveFundPaymentRequest: failed %v", err)
+		return nil, err
+	}
+
+	return &breez.RemoveFundReply{PaymentRequest: paymentRequest}, nil
+}
+
+func (s *Server) RedeemRemovedFunds(ctx context.Context, in *breez.RedeemRemovedFundsRequest) (*breez.RedeemRemovedFundsReply, error) {
+	txID, err := s.ensureOnChainPaymentSent(in.Paymenthash)
+	if err != nil {
+		log.Printf("ReceiveOnChainPayment failed: %v", err)
+		return nil, err
+	}
+	return &breez.RedeemRemovedFundsReply{Txid: txID}, nil
+}
+
+func (s *Server) createRemoveFundPaymentRequest(amount int64, address string) (string, error) {
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.macaroonHex)
+	addInoiceResp, err := s.client.AddInvoice(clientCtx, &lnrpc.Invoice{Value: amount, Memo: "Bitcoin Transfer", Expiry: removeFundTimeout})
+	if err != nil {
+		log.Printf("createPaymentRequest: failed to add invoice %v", err)
+		return "", err
+	}
+	payReqHash := addInoiceResp.RHash
+	err = s.store.UpdateKeyFields(hex.EncodeToString(payReqHash[:]), map[string]string{"address": address})
+	return addInoiceResp.PaymentRequest, err
+}
+
+func (s *Server) ensureOnChainPaymentSent(payReqHash string) (string, error) {
+	txID, err, _ := s.payReqGroup.Do(payReqHash, func() (interface{}, error) {
+		return s.sendCoinsForReceivedPayment(payReqHash)
+	})
+	return txID.(string), err
+}
+
+func (s *Server) sendCoinsForReceivedPayment(payReqHash string) (string, error) {
+	removeFundRequest, err := s.store.GetKeyFields(payReqHash)
+	if err != nil {
+		log.Printf("error querying payment request hash, %v", err)
+		return "", err
+	}
+	address := removeFundRequest["address"]
+	txID := removeFundRequest["txid"]
+
+	//no fund request associated with invoice, continue
+	if address == "" {
+		return "", rpcerror.New(codes.NotFound, rpcerror.ReasonRemoveFundNotFound,
+			fmt.Sprintf("no address associated with hash: %v", payReqHash))
+	}
+
+	//if we already payed
+	if txID != "" {
+		return txID, nil
+	}
+
+	log.Printf("paying on chain to destination address: %v", address)
+
+	//1. fetch the invoice and check settled amount
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.macaroonHex)
+	invoice, err := s.client.LookupInvoice(clientCtx, &lnrpc.PaymentHash{RHashStr: payReqHash})
+	if err != nil {
+		return "", err
+	}
+	if !invoice.Settled {
+		return "", rpcerror.New(codes.FailedPrecondition, rpcerror.ReasonRemoveFundNotPaid, "fail to pay, haven't received any payment")
+	}
+
+	//2. send coins to the user
+	response, err := s.client.SendCoins(clientCtx, &lnrpc.SendCoinsRequest{Addr: address, Amount: invoice.AmtPaidSat, TargetConf: 48})
+	if err != nil {
+		return "", rpcerror.Internal(rpcerror.ReasonSendCoinsFailed, "fail to send coins", err)
+	}
+
+	log.Printf("successfully sent coins to address %v", address)
+
+	//3. save txId in fund request
+	if err := s.store.UpdateKeyFields(payReqHash, map[string]string{"txid": response.Txid}); err != nil {
+		log.Printf("Fail to save tx id %v associated with invoice %v", response.Txid, invoice.PaymentRequest)
+	}
+
+	return response.Txid, nil
+}
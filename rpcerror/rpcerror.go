@@ -0,0 +1,237 @@
+// Package rpcerror builds the errors returned by the grpc services: a grpc
+// code, a google.rpc.ErrorInfo detail with a stable reason the clients can
+// branch on, and a google.rpc.BadRequest detail naming the invalid fields of
+// the request. The message is for humans and may change; the reason may not.
+package rpcerror
+
+import (
+	"context"
+	"errors"
+	"maps"
+
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// Domain is the domain of the ErrorInfo details.
+const Domain = "breez.technology"
+
+// Reason is the machine-readable reason of an error, sent in its ErrorInfo
+// detail. The reasons are UPPER_SNAKE_CASE and never change once released.
+type Reason string
+
+// The reasons common to all the services. They are also the reasons of the
+// errors without a more specific one, after their grpc code.
+const (
+	ReasonInvalidArgument    Reason = "INVALID_ARGUMENT"
+	ReasonNotAuthorized      Reason = "NOT_AUTHORIZED"
+	ReasonNotFound           Reason = "NOT_FOUND"
+	ReasonRateLimited        Reason = "RATE_LIMITED"
+	ReasonUnavailable        Reason = "UNAVAILABLE"
+	ReasonInternal           Reason = "INTERNAL"
+	ReasonCanceled           Reason = "CANCELLED"
+	ReasonDeadlineExceeded   Reason = "DEADLINE_EXCEEDED"
+	ReasonStorageUnavailable Reason = "STORAGE_UNAVAILABLE"
+)
+
+// The reasons specific to a service.
+const (
+	ReasonUnknownNode            Reason = "UNKNOWN_NODE"
+	ReasonNotificationFailed     Reason = "NOTIFICATION_FAILED"
+	ReasonUploadFailed           Reason = "UPLOAD_FAILED"
+	ReasonSessionNotFound        Reason = "SESSION_NOT_FOUND"
+	ReasonKeyNotSupported        Reason = "KEY_NOT_SUPPORTED"
+	ReasonInvalidSignature       Reason = "INVALID_SIGNATURE"
+	ReasonValueNotFound          Reason = "VALUE_NOT_FOUND"
+	ReasonLSPNotFound            Reason = "LSP_NOT_FOUND"
+	ReasonDepositLimitFailed     Reason = "DEPOSIT_LIMIT_FAILED"
+	ReasonInvalidPaymentRequest  Reason = "INVALID_PAYMENT_REQUEST"
+	ReasonSwapNoUTXOs            Reason = "SWAP_NO_UTXOS"
+	ReasonSwapFeesUnavailable    Reason = "SWAP_FEES_UNAVAILABLE"
+	ReasonBlockHeightUnavailable Reason = "BLOCK_HEIGHT_UNAVAILABLE"
+	ReasonSwapRejected           Reason = "SWAP_REJECTED"
+	ReasonPaymentFailed          Reason = "PAYMENT_FAILED"
+	ReasonNoRoute                Reason = "NO_ROUTE"
+	ReasonRemoveFundNotFound     Reason = "REMOVE_FUND_NOT_FOUND"
+	ReasonRemoveFundNotPaid      Reason = "REMOVE_FUND_NOT_PAID"
+	ReasonSendCoinsFailed        Reason = "SEND_COINS_FAILED"
+	ReasonStatusUnavailable      Reason = "STATUS_UNAVAILABLE"
+	ReasonReportFailed           Reason = "REPORT_FAILED"
+)
+
+// Error is an error of a grpc service. Err, the cause of the error, is logged
+// but not sent to the client.
+type Error struct {
+	Code       codes.Code
+	Reason     Reason
+	Message    string
+	Metadata   map[string]string
+	Violations []*errdetails.BadRequest_FieldViolation
+	// Details are more details of the status, such as a RetryInfo.
+	Details []protoiface.MessageV1
+	Err     error
+}
+
+// New returns an error with code, reason and the message msg.
+func New(c codes.Code, reason Reason, msg string) *Error {
+	return &Error{Code: c, Reason: reason, Message: msg}
+}
+
+// InvalidArgument returns the error of a request whose field is invalid.
+func InvalidArgument(field, description string) *Error {
+	return New(codes.InvalidArgument, ReasonInvalidArgument, description).WithViolation(field, description)
+}
+
+// NotAuthorized returns the error of a request without the credentials
+// required by the method.
+func NotAuthorized() *Error {
+	return New(codes.PermissionDenied, ReasonNotAuthorized, "Not authorized")
+}
+
+// Internal returns the error of a request failed by err, which is not sent to
+// the client.
+func Internal(reason Reason, msg string, err error) *Error {
+	return New(codes.Internal, reason, msg).Wrap(err)
+}
+
+// WithMetadata returns a copy of e with the metadata key and value in its
+// ErrorInfo.
+func (e *Error) WithMetadata(key, value string) *Error {
+	c := *e
+	c.Metadata = maps.Clone(e.Metadata)
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[key] = value
+	return &c
+}
+
+// WithViolation returns a copy of e with the invalid field in its BadRequest.
+func (e *Error) WithViolation(field, description string) *Error {
+	c := *e
+	c.Violations = append(e.Violations[:len(e.Violations):len(e.Violations)],
+		&errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	return &c
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the status sent to the client.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+	details := []protoiface.MessageV1{&errdetails.ErrorInfo{
+		Reason:   string(e.Reason),
+		Domain:   Domain,
+		Metadata: e.Metadata,
+	}}
+	if len(e.Violations) > 0 {
+		details = append(details, &errdetails.BadRequest{FieldViolations: e.Violations})
+	}
+	details = append(details, e.Details...)
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// codeReason is the reason of the errors with code c without a more specific
+// one: the name of the code.
+func codeReason(c codes.Code) Reason {
+	return Reason(code.Code(c).String())
+}
+
+// FromError returns err as the *Error sent to the client. The errors with a
+// grpc status, such as the errors of lnd or the errors wrapping an *Error,
+// keep its code, message and details, and get the reason of their code when
+// they have no ErrorInfo of this domain. The context errors become Canceled
+// and DeadlineExceeded, and the other errors are Internal, without their
+// message.
+func FromError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		st := se.GRPCStatus()
+		e := &Error{Code: st.Code(), Reason: codeReason(st.Code()), Message: st.Message(), Err: err}
+		for _, d := range st.Details() {
+			if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == Domain {
+				e.Reason, e.Metadata = Reason(info.Reason), info.Metadata
+			} else if bad, ok := d.(*errdetails.BadRequest); ok {
+				e.Violations = append(e.Violations, bad.FieldViolations...)
+			} else if m, ok := d.(protoiface.MessageV1); ok {
+				e.Details = append(e.Details, m)
+			}
+		}
+		return e
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return New(codes.Canceled, ReasonCanceled, "request cancelled").Wrap(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(codes.DeadlineExceeded, ReasonDeadlineExceeded, "deadline exceeded").Wrap(err)
+	}
+	return Internal(ReasonInternal, "internal error", err)
+}
+
+// ReasonOf returns the reason of err, a status error returned by a service,
+// or "".
+func ReasonOf(err error) Reason {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == Domain {
+			return Reason(info.Reason)
+		}
+	}
+	return ""
+}
+
+// UnaryServerInterceptor converts the errors returned by the services with
+// FromError.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, FromError(err)
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor converts the errors returned by the streaming
+// services with FromError.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := handler(srv, ss); err != nil {
+			return FromError(err)
+		}
+		return nil
+	}
+}
@@ -7,34 +7,50 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"crypto/x509"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"image/png"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/storage"
 	"github.com/breez/server/auth"
+	"github.com/breez/server/backoff"
 	"github.com/breez/server/breez"
+	"github.com/breez/server/clientip"
+	"github.com/breez/server/config"
+	"github.com/breez/server/ctp"
+	"github.com/breez/server/health"
+	"github.com/breez/server/i18n"
 	"github.com/breez/server/liquid"
+	"github.com/breez/server/logging"
 	"github.com/breez/server/lsp"
+	"github.com/breez/server/metrics"
+	"github.com/breez/server/nodeinfo"
+	"github.com/breez/server/notify"
+	"github.com/breez/server/postgresql"
 	"github.com/breez/server/ratelimit"
+	"github.com/breez/server/removefunds"
+	"github.com/breez/server/rpcerror"
 	"github.com/breez/server/signer"
+	"github.com/breez/server/store"
 	"github.com/breez/server/support"
 	"github.com/breez/server/swapd"
 	"github.com/breez/server/swapper"
-	"github.com/btcsuite/btcd/btcutil"
-	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/breez/server/tracing"
+	"github.com/breez/server/txnotify"
+	"github.com/breez/server/validate"
 	"github.com/go-git/go-billy/v6/osfs"
 	"github.com/go-git/go-git/v6/backend"
 	"github.com/go-git/go-git/v6/plumbing/transport"
@@ -46,45 +62,31 @@ import (
 	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
 	"github.com/rs/cors"
 
-	"golang.org/x/text/message"
-	"google.golang.org/api/option"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
 )
 
 const (
 	imageDimensionLength = 200
 	channelAmount        = 1000000
-	minRemoveFund        = 50000
 	liquidAPIPrefix      = "/liquid/api"
 )
 
-var client, ssClient lnrpc.LightningClient
-var subswapClient submarineswaprpc.SubmarineSwapperClient
-var walletKitClient, ssWalletKitClient walletrpc.WalletKitClient
-var chainNotifierClient chainrpc.ChainNotifierClient
-var ssRouterClient routerrpc.RouterClient
-var network *chaincfg.Params
-
-var swapperServer *swapper.Server
-
-// server is used to implement breez.InvoicerServer and breez.PosServer
+// server implements the grpc services of the main package.
 type server struct {
 	breez.UnimplementedInvoicerServer
 	breez.UnimplementedPosServer
 	breez.UnimplementedInformationServer
 	breez.UnimplementedCardOrdererServer
 	breez.UnimplementedFundManagerServer
-	breez.UnimplementedCTPServer
 	breez.UnimplementedSyncNotifierServer
-	breez.UnimplementedPushTxNotifierServer
 	breez.UnimplementedInactiveNotifierServer
-	breez.UnimplementedNodeInfoServer
+	*services
+	swapperServer    *swapper.Server
+	removeFunds      *removefunds.Server
 	chainApiServers  []*breez.ChainApiServersReply_ChainAPIServer
 	orchestraBaseURL string
 	orchestraApiKey  string
@@ -96,12 +98,12 @@ func (s *server) RegisterDevice(ctx context.Context, in *breez.RegisterRequest)
 		nodeID, err := hex.DecodeString(in.LightningID)
 		if err != nil {
 			log.Printf("hex.DecodeString(%v) error: %v", in.LightningID, err)
-			return nil, fmt.Errorf("hex.DecodeString(%v) error: %w", in.LightningID, err)
+			return nil, rpcerror.InvalidArgument("lightningID", "lightningID must be a hex encoded node ID")
 		}
-		err = deviceNode(nodeID, in.DeviceID)
+		err = s.db.DeviceNode(nodeID, in.DeviceID)
 		if err != nil {
-			log.Printf("deviceNode(%x, %v) error: %v", nodeID, in.DeviceID, err)
-			return nil, fmt.Errorf("deviceNode(%x, %v) error: %w", nodeID, in.DeviceID, err)
+			slog.ErrorContext(ctx, "deviceNode failed", "node_id", in.LightningID, "device_id", in.DeviceID, "error", err)
+			return nil, err
 		}
 	}
 	return &breez.RegisterReply{BreezID: in.DeviceID}, nil
@@ -116,18 +118,19 @@ func (s *server) SendInvoice(ctx context.Context, in *breez.PaymentRequest) (*br
 		"payment_request": in.Invoice,
 	}
 
-	err := notifyAlertMessage(
-		in.Payee,
-		"is requesting you to pay "+strconv.FormatInt(in.Amount, 10)+" Sat",
-		notificationData,
-		in.BreezID)
+	err := s.notifier.NotifyAlert(notify.Alert{
+		Type:  notify.TypePaymentRequest,
+		Title: in.Payee,
+		Body:  s.localizer.Render(in.BreezID, i18n.PaymentRequestBody, strconv.FormatInt(in.Amount, 10)),
+		Data:  notificationData,
+	}, in.BreezID)
 
 	if err != nil {
 		log.Println(err)
-		return &breez.InvoiceReply{Error: err.Error()}, err
+		return nil, rpcerror.New(codes.Unavailable, rpcerror.ReasonNotificationFailed, "Failed to send the notification").Wrap(err)
 	}
 
-	return &breez.InvoiceReply{Error: ""}, nil
+	return &breez.InvoiceReply{}, nil
 }
 
 func (s *server) UploadLogo(ctx context.Context, in *breez.UploadFileRequest) (*breez.UploadFileReply, error) {
@@ -137,14 +140,14 @@ func (s *server) UploadLogo(ctx context.Context, in *breez.UploadFileRequest) (*
 	img, err := png.Decode(fileDataReader)
 	if err != nil {
 		log.Println("Failes to decode image", err)
-		return nil, status.Errorf(codes.InvalidArgument, "Image must be of type png")
+		return nil, rpcerror.InvalidArgument("content", "Image must be of type png")
 	}
 
 	//validate image size
 	imageMaxBounds := img.Bounds().Max
 	if imageMaxBounds.X != imageDimensionLength || imageMaxBounds.Y != imageDimensionLength {
 		log.Println("Image not in right required dimensions", imageMaxBounds)
-		return nil, status.Errorf(codes.InvalidArgument, "Image size must be 200 X 200 pixels")
+		return nil, rpcerror.InvalidArgument("content", "Image size must be 200 X 200 pixels")
 	}
 
 	//hash content and calculate file path
@@ -152,48 +155,20 @@ func (s *server) UploadLogo(ctx context.Context, in *breez.UploadFileRequest) (*
 	hashHex := hex.EncodeToString(fileHash[0:])
 	objectPath := fmt.Sprintf("%v/%v/%v.png", hashHex[:4], hashHex[4:8], hashHex[8:])
 
-	gcContext := context.Background()
-	gcCredsFile := os.Getenv("GOOGLE_CLOUD_SERVICE_FILE")
-	gcBucketName := os.Getenv("GOOGLE_CLOUD_IMAGES_BUCKET_NAME")
-	gcClient, err := storage.NewClient(gcContext, option.WithCredentialsFile(gcCredsFile))
-
-	if err != nil {
-		log.Println("Failed to create google cloud client", err)
-		return nil, status.Errorf(codes.Internal, "Failed to save image")
-	}
-	bucket := gcClient.Bucket(gcBucketName)
-	obj := bucket.Object(objectPath)
-
-	writer := obj.NewWriter(gcContext)
-	if _, err := writer.Write(in.Content); err != nil {
-		log.Println("Failed to write to bucket stream", err)
-		return nil, status.Errorf(codes.Internal, "Failed to save image")
-	}
-
-	if err := writer.Close(); err != nil {
-		log.Println("Failed to close bucket stream", err)
-		return nil, status.Errorf(codes.Internal, "Failed to save image")
-	}
-
-	if err := obj.ACL().Set(gcContext, storage.AllUsers, storage.RoleReader); err != nil {
-		log.Println("Failed to set read permissions on object", err)
-		return nil, status.Errorf(codes.Internal, "Failed to save image")
-	}
-
-	objAttrs, err := obj.Attrs(gcContext)
+	url, err := s.blobs.Put(context.Background(), objectPath, in.Content)
 	if err != nil {
-		log.Println("Failed read object attributes", err)
-		return nil, status.Errorf(codes.Internal, "Failed to save image")
+		log.Println("Failed to save image", err)
+		return nil, rpcerror.Internal(rpcerror.ReasonUploadFailed, "Failed to save image", err)
 	}
 
-	log.Println("Succesfully uploaded image", objAttrs.MediaLink)
-	return &breez.UploadFileReply{Url: objAttrs.MediaLink}, nil
+	log.Println("Succesfully uploaded image", url)
+	return &breez.UploadFileReply{Url: url}, nil
 }
 
 // Workaround until LND PR #1595 is merged
 func (s *server) UpdateChannelPolicy(ctx context.Context, in *breez.UpdateChannelPolicyRequest) (*breez.UpdateChannelPolicyReply, error) {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
-	nodeChannels, err := getNodeChannels(in.PubKey)
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.cfg.LND.MacaroonHex)
+	nodeChannels, err := s.getNodeChannels(in.PubKey)
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +184,7 @@ func (s *server) UpdateChannelPolicy(ctx context.Context, in *breez.UpdateChanne
 		channelPoint.OutputIndex = uint32(outputIndex)
 		channelPoint.FundingTxid = &lnrpc.ChannelPoint_FundingTxidStr{FundingTxidStr: strings.Split(c.ChannelPoint, ":")[0]}
 
-		client.UpdateChannelPolicy(clientCtx, &lnrpc.PolicyUpdateRequest{BaseFeeMsat: 1000, FeeRate: 0.000001, TimeLockDelta: 144, Scope: &lnrpc.PolicyUpdateRequest_ChanPoint{ChanPoint: &channelPoint}})
+		s.lnd.UpdateChannelPolicy(clientCtx, &lnrpc.PolicyUpdateRequest{BaseFeeMsat: 1000, FeeRate: 0.000001, TimeLockDelta: 144, Scope: &lnrpc.PolicyUpdateRequest_ChanPoint{ChanPoint: &channelPoint}})
 		if err != nil {
 			return nil, err
 		}
@@ -219,64 +194,30 @@ func (s *server) UpdateChannelPolicy(ctx context.Context, in *breez.UpdateChanne
 }
 
 func (s *server) AddFundInit(ctx context.Context, in *breez.AddFundInitRequest) (*breez.AddFundInitReply, error) {
-	return swapperServer.AddFundInitLegacy(ctx, in)
+	return s.swapperServer.AddFundInitLegacy(ctx, in)
 }
 
 func (s *server) AddFundStatus(ctx context.Context, in *breez.AddFundStatusRequest) (*breez.AddFundStatusReply, error) {
-	return swapperServer.AddFundStatus(ctx, in)
+	return s.swapperServer.AddFundStatus(ctx, in)
 }
 
 func (s *server) GetSwapPayment(ctx context.Context, in *breez.GetSwapPaymentRequest) (*breez.GetSwapPaymentReply, error) {
-	return swapperServer.GetSwapPaymentLegacy(ctx, in)
+	return s.swapperServer.GetSwapPaymentLegacy(ctx, in)
 }
 
 func (s *server) RemoveFund(ctx context.Context, in *breez.RemoveFundRequest) (*breez.RemoveFundReply, error) {
-	address := in.Address
-	amount := in.Amount
-	if address == "" {
-		return nil, errors.New("Destination address must not be empty")
-	}
-
-	_, err := btcutil.DecodeAddress(address, network)
-	if err != nil {
-		log.Println("Destination address must be a valid bitcoin address")
-		return nil, err
-	}
-
-	if amount <= 0 {
-		return nil, errors.New("Amount must be positive")
-	}
-
-	if amount < minRemoveFund {
-		p := message.NewPrinter(message.MatchLanguage("en"))
-		satFormatted := strings.Replace(p.Sprintf("%d", minRemoveFund), ",", " ", 1)
-		btcFormatted := strconv.FormatFloat(float64(minRemoveFund)/float64(100000000), 'f', -1, 64)
-		errorStr := fmt.Sprintf("Removed funds must be more than  %v BTC (%v Sat).", btcFormatted, satFormatted)
-		return &breez.RemoveFundReply{ErrorMessage: errorStr}, nil
-	}
-
-	paymentRequest, err := createRemoveFundPaymentRequest(amount, address)
-	if err != nil {
-		log.Printf("createRemoveFundPaymentRequest: failed %v", err)
-		return nil, err
-	}
-
-	return &breez.RemoveFundReply{PaymentRequest: paymentRequest}, nil
+	return s.removeFunds.RemoveFund(ctx, in)
 }
 
 func (s *server) RedeemRemovedFunds(ctx context.Context, in *breez.RedeemRemovedFundsRequest) (*breez.RedeemRemovedFundsReply, error) {
-	txID, err := ensureOnChainPaymentSent(in.Paymenthash)
-	if err != nil {
-		log.Printf("ReceiveOnChainPayment failed: %v", err)
-		return nil, err
-	}
-	return &breez.RedeemRemovedFundsReply{Txid: txID}, nil
+	return s.removeFunds.RedeemRemovedFunds(ctx, in)
 }
 
 // RegisterDevice implements breez.InvoicerServer
 func (s *server) Order(ctx context.Context, in *breez.OrderRequest) (*breez.OrderReply, error) {
-	log.Printf("Order a card for: %#v", *in)
-	err := sendCardOrderNotification(in)
+	// The order holds the name, email and address of the customer.
+	slog.InfoContext(ctx, "Order a card", "full_name", in.FullName, "email", in.Email)
+	err := sendCardOrderNotification(s.mailer, s.cfg.CardNotification, in)
 	if err != nil {
 		log.Printf("Error in sendCardOrderNotification: %v", err)
 	}
@@ -286,38 +227,25 @@ func (s *server) Order(ctx context.Context, in *breez.OrderRequest) (*breez.Orde
 // InactiveNotify send a notification to an inactive nodeid
 func (s *server) InactiveNotify(ctx context.Context, in *breez.InactiveNotifyRequest) (*breez.InactiveNotifyResponse, error) {
 	data := make(map[string]string)
-	token, err := getDeviceToken(in.Pubkey)
+	token, err := s.db.DeviceToken(in.Pubkey)
 	if err != nil {
 		return nil, err
 	}
 	if token == "" {
-		return nil, fmt.Errorf("Unknown nodeID: %v", in.Pubkey)
-	}
-	body := fmt.Sprintf("You haven't made any payments with Breez for %v days, so your LSP might have to close your channels. Open Breez for more information.", in.Days)
-	err = notifyAlertMessage("Inactive Channels", body, data, token)
+		return nil, rpcerror.New(codes.NotFound, rpcerror.ReasonUnknownNode, fmt.Sprintf("Unknown nodeID: %x", in.Pubkey))
+	}
+	err = s.notifier.NotifyAlert(notify.Alert{
+		Type:  notify.TypeInactive,
+		Title: s.localizer.Render(token, i18n.InactiveTitle),
+		Body:  s.localizer.Render(token, i18n.InactiveBody, strconv.FormatInt(int64(in.Days), 10)),
+		Data:  data,
+	}, token)
 	if err != nil {
-		return nil, err
+		return nil, rpcerror.New(codes.Unavailable, rpcerror.ReasonNotificationFailed, "Failed to send the notification").Wrap(err)
 	}
 	return &breez.InactiveNotifyResponse{}, nil
 }
 
-// JoinCTPSession is used by both payer/payee to join a CTP session.
-func (s *server) JoinCTPSession(ctx context.Context, in *breez.JoinCTPSessionRequest) (*breez.JoinCTPSessionResponse, error) {
-	sessionID, expiry, err := joinSession(in.SessionID, in.NotificationToken, in.PartyName, in.PartyType == breez.JoinCTPSessionRequest_PAYER)
-	if err != nil {
-		return nil, err
-	}
-	return &breez.JoinCTPSessionResponse{SessionID: sessionID, Expiry: expiry}, nil
-}
-
-func (s *server) TerminateCTPSession(ctx context.Context, in *breez.TerminateCTPSessionRequest) (*breez.TerminateCTPSessionResponse, error) {
-	err := terminateSession(in.SessionID)
-	if err != nil {
-		return nil, err
-	}
-	return &breez.TerminateCTPSessionResponse{}, nil
-}
-
 func (s *server) RegisterTransactionConfirmation(ctx context.Context, in *breez.RegisterTransactionConfirmationRequest) (*breez.RegisterTransactionConfirmationResponse, error) {
 	var notifyType string
 	if in.NotificationType == breez.RegisterTransactionConfirmationRequest_READY_RECEIVE_PAYMENT {
@@ -327,9 +255,9 @@ func (s *server) RegisterTransactionConfirmation(ctx context.Context, in *breez.
 		notifyType = channelOpenedType
 	}
 	if notifyType == "" {
-		return nil, errors.New("Invalid notification type")
+		return nil, rpcerror.InvalidArgument("notificationType", "Invalid notification type")
 	}
-	err := registerTransacionConfirmation(in.TxID, in.NotificationToken, notifyType)
+	err := s.registerTransacionConfirmation(in.TxID, in.NotificationToken, notifyType)
 	if err != nil {
 		return nil, err
 	}
@@ -337,15 +265,15 @@ func (s *server) RegisterTransactionConfirmation(ctx context.Context, in *breez.
 }
 
 func (s *server) RegisterPeriodicSync(ctx context.Context, in *breez.RegisterPeriodicSyncRequest) (*breez.RegisterPeriodicSyncResponse, error) {
-	if err := registerSyncNotification(in.NotificationToken); err != nil {
+	if err := s.registerSyncNotification(in.NotificationToken); err != nil {
 		return nil, err
 	}
 	return &breez.RegisterPeriodicSyncResponse{}, nil
 }
 
-func getNodeChannels(nodeID string) ([]*lnrpc.Channel, error) {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
-	listResponse, err := client.ListChannels(clientCtx, &lnrpc.ListChannelsRequest{})
+func (s *server) getNodeChannels(nodeID string) ([]*lnrpc.Channel, error) {
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.cfg.LND.MacaroonHex)
+	listResponse, err := s.lnd.ListChannels(clientCtx, &lnrpc.ListChannelsRequest{})
 	if err != nil {
 		return nil, err
 	}
@@ -374,39 +302,173 @@ func (s *server) OrchestraConfig(ctx context.Context, in *breez.OrchestraConfigR
 	}, nil
 }
 
+// patternMux is an http.ServeMux remembering its patterns, to validate the
+// rate limit policy against them.
+type patternMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *patternMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *patternMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// reloadRateLimitPolicyOnHUP reloads the rate limit policy file every time the
+// process receives SIGHUP, until ctx is done.
+func reloadRateLimitPolicyOnHUP(ctx context.Context, limiter *ratelimit.Limiter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			if err := limiter.Reload(); err != nil {
+				log.Printf("limiter.Reload() error: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func main() {
+	dev := flag.Bool("dev", false, "replace lnd, swapd, lspd, the push notifications, the emails and the blob storage by in-process fakes")
+	flag.Parse()
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:], *dev); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "notifications" {
+		if err := runNotifications(flag.Args()[1:], *dev); err != nil {
+			log.Fatalf("notifications: %v", err)
+		}
+		return
+	}
+
+	loadConfig, newBackends := config.Load, connectBackends
+	if *dev {
+		loadConfig, newBackends = config.LoadDev, fakeBackends
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up the logging: %v", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio)
+	if err != nil {
+		log.Fatalf("Failed to set up the tracing: %v", err)
+	}
+	defer func() {
+		// The spans of the shutdown itself are flushed last.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("tracing shutdown: %v", err)
+		}
+	}()
+	if cfg.Dev {
+		log.Printf("Running in the -dev mode, with fakes of the outside services")
+		if err := applyDevDefaults(cfg); err != nil {
+			log.Fatalf("Failed to set the -dev defaults: %v", err)
+		}
+	}
 
-	switch os.Getenv("NETWORK") {
-	case "simnet":
-		network = &chaincfg.SimNetParams
-	case "testnet":
-		network = &chaincfg.TestNet3Params
-	default:
-		network = &chaincfg.MainNetParams
+	ipResolver, err := clientip.NewResolver(cfg.TrustedProxies, cfg.TrustedProxyHeader)
+	if err != nil {
+		log.Fatalf("Failed to parse the trusted proxies: %v", err)
 	}
 
-	lisGRPC, err := net.Listen("tcp", os.Getenv("GRPC_LISTEN_ADDRESS"))
+	redisStore := store.NewRedis(cfg.RedisURL, cfg.RedisDB)
+	pg, err := store.NewPostgres(context.Background(), cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		log.Fatalf("Failed to create the postgres pool: %v", err)
+	}
+	if cfg.Dev {
+		// The -dev mode applies the migrations itself.
+		if err := migrateUp(cfg.DatabaseURL); err != nil {
+			log.Printf("Failed to migrate the database: %v", err)
+		}
+	}
+	if err := postgresql.CheckVersion(cfg.DatabaseURL); err != nil {
+		if errors.Is(err, postgresql.ErrSchemaVersion) {
+			log.Fatalf("%v, run `server migrate up`", err)
+		}
+		log.Printf("Failed to check the database schema version: %v", err)
 	}
 
-	lisHTTP, err := net.Listen("tcp", os.Getenv("HTTP_LISTEN_ADDRESS"))
+	rateLimitBackend, err := ratelimit.NewBackend(cfg.RateLimitBackend, redisStore.Pool())
+	if err != nil {
+		log.Fatalf("Failed to create the rate limit backend: %v", err)
+	}
+	// The public channel tokens are partner keys too, without overrides.
+	quotas := func(apiKey string) (map[string]ratelimit.Limit, bool, error) {
+		if _, ok := cfg.PublicChannelTokens[apiKey]; ok {
+			return nil, true, nil
+		}
+		return pg.APIKeyRateLimits(apiKey)
+	}
+	limiter, err := ratelimit.NewLimiter(rateLimitBackend, cfg.RateLimitPolicyFile,
+		map[string]string{"STATIC_FILES_PREFIX": cfg.StaticFilesPrefix}, quotas)
+	if err != nil {
+		log.Fatalf("Failed to load the rate limit policy: %v", err)
+	}
+
+	lisGRPC, err := net.Listen("tcp", cfg.GRPCListenAddress)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
-	liquidEsploraBaseURL, err := url.Parse(os.Getenv("LIQUID_ESPLORA_API_BASE_URL"))
+
+	lisHTTP, err := net.Listen("tcp", cfg.HTTPListenAddress)
 	if err != nil {
-		log.Fatalf("Failed to parse %v: %v", os.Getenv("LIQUID_ESPLORA_API_BASE_URL"), err)
+		log.Fatalf("Failed to listen: %v", err)
 	}
+	liquidEsploraBaseURL := cfg.LiquidEsploraAPIBaseURL
+	var httpServers []*http.Server
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/fees/v1/btc-fee-estimates.json", func(w http.ResponseWriter, req *http.Request) {
+	b, err := newBackends(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to the backends: %v", err)
+	}
+	defer b.close()
+
+	healthChecker := health.NewChecker(5 * time.Second)
+
+	mux := &patternMux{ServeMux: http.NewServeMux()}
+	mux.HandleFunc("/healthz", healthChecker.HealthzHandler)
+	mux.HandleFunc("/readyz", healthChecker.ReadyzHandler)
+	for pattern, handler := range b.handlers {
+		mux.Handle(pattern, handler)
+	}
+	// The metrics are served only on their own listener: the http server is
+	// public and has no authentication.
+	if metricsAddress := cfg.MetricsListenAddress; metricsAddress != "" {
+		metricsServer := &http.Server{Addr: metricsAddress, Handler: metrics.Handler()}
+		httpServers = append(httpServers, metricsServer)
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("metrics server: %v", err)
+			}
+		}()
+	} else {
+		log.Printf("METRICS_LISTEN_ADDRESS is not set: the metrics are not served")
+	}
+	mux.Handle("/fees/v1/btc-fee-estimates.json", limiter.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(feeEstimates))
-	})
-	staticDir := os.Getenv("STATIC_FILES_DIRECTORY")
-	staticFilesPrefix := os.Getenv("STATIC_FILES_PREFIX")
-	staticFilesAuth := os.Getenv("STATIC_FILES_AUTHENTICATION")
+	})))
+	staticDir := cfg.StaticFilesDirectory
+	staticFilesPrefix := cfg.StaticFilesPrefix
+	staticFilesAuth := cfg.StaticFilesAuthentication
 	staticFilesHandler := http.StripPrefix(staticFilesPrefix+"/", http.FileServer(http.Dir(staticDir)))
 	gitBackend := &backend.Backend{
 		Loader: transport.NewFilesystemLoader(osfs.New(staticDir), false),
@@ -448,240 +510,275 @@ func main() {
 			staticFilesHandler.ServeHTTP(w, r)
 		}
 	})
-	mux.Handle(staticFilesPrefix+".git/", withFilesAuth(withoutTimeout(gitBackend)))
-	mux.Handle(staticFilesPrefix+"/", withFilesAuth(filesHandler))
-	mux.HandleFunc("/api/jwt", auth.AuthenticatedHandler("", http.HandlerFunc(auth.JWTHandler), nil))
-	mux.HandleFunc("/api/crl", http.HandlerFunc(auth.CRLHandler))
-	var chainApiServers []*breez.ChainApiServersReply_ChainAPIServer
-	json.Unmarshal([]byte(os.Getenv("CHAIN_API_SERVERS")), &chainApiServers)
+	mux.Handle(staticFilesPrefix+".git/", limiter.HTTPMiddleware(withFilesAuth(withoutTimeout(gitBackend))))
+	mux.Handle(staticFilesPrefix+"/", limiter.HTTPMiddleware(withFilesAuth(filesHandler)))
+	certAuth := auth.NewCertAuthenticator(cfg.BreezCACert, cfg.BreezCACRL, cfg.JWTPrivateKey)
+	mux.HandleFunc("/api/jwt", certAuth.AuthenticatedHandler("", limiter.HTTPMiddleware(http.HandlerFunc(certAuth.JWTHandler)), nil))
+	mux.Handle("/api/crl", limiter.HTTPMiddleware(http.HandlerFunc(certAuth.CRLHandler)))
+	chainApiServers := cfg.ChainAPIServers
 	broadcastProxy := httputil.NewSingleHostReverseProxy(liquidEsploraBaseURL)
-	mux.HandleFunc(fmt.Sprint("POST ", liquidAPIPrefix, "/tx"), liquid.BroadcastHandler(chainApiServers, liquidAPIPrefix, broadcastProxy, liquidEsploraBaseURL))
+	broadcastProxy.Transport = tracing.Transport(http.DefaultTransport)
+	metrics.InstrumentProxy(broadcastProxy)
+	mux.Handle(fmt.Sprint("POST ", liquidAPIPrefix, "/tx"), limiter.HTTPMiddleware(http.HandlerFunc(liquid.BroadcastHandler(chainApiServers, cfg.BreezCACert, liquidAPIPrefix, broadcastProxy, liquidEsploraBaseURL))))
 	simpleProxy := httputil.NewSingleHostReverseProxy(liquidEsploraBaseURL)
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/fee-estimates"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/server_recipient"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/hex"), liquid.UnauthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/raw"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/status"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v1/waterfalls"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v1/server_recipient"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v2/waterfalls"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/blocks/tip/hash"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/blocks/tip/height"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/raw"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/header"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/txids"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/status"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block-height/{height}"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}/txs"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}/txs"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}/utxo"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}/utxo"), auth.AuthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))
-	handler := cors.AllowAll().Handler(mux)
+	simpleProxy.Transport = tracing.Transport(http.DefaultTransport)
+	metrics.InstrumentProxy(simpleProxy)
+	limitedProxy := limiter.HTTPMiddleware(simpleProxy)
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/fee-estimates"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/server_recipient"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.Handle(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/hex"), limiter.HTTPMiddleware(http.HandlerFunc(liquid.UnauthenticatedHandler(liquidAPIPrefix, simpleProxy, liquidEsploraBaseURL))))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/raw"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/tx/{txid}/status"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v1/waterfalls"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v1/server_recipient"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/v2/waterfalls"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/blocks/tip/hash"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/blocks/tip/height"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/raw"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/header"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/txids"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block/{hash}/status"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/block-height/{height}"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}/txs"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}/txs"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/scripthash/{hash}/utxo"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	mux.HandleFunc(fmt.Sprint("GET ", liquidAPIPrefix, "/address/{address}/utxo"), certAuth.AuthenticatedHandler(liquidAPIPrefix, limitedProxy, liquidEsploraBaseURL))
+	handler := cors.AllowAll().Handler(ipResolver.Middleware(logging.Middleware(tracing.Middleware(mux))))
 	HTTPServer := &http.Server{
 		Handler:        handler,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	go HTTPServer.Serve(lisHTTP)
-
-	// Creds file to connect to LND gRPC
-	cp := x509.NewCertPool()
-	if !cp.AppendCertsFromPEM([]byte(strings.Replace(os.Getenv("LND_CERT"), "\\n", "\n", -1))) {
-		log.Fatalf("credentials: failed to append certificates")
-	}
-	creds := credentials.NewClientTLSFromCert(cp, "")
-
-	// Address of an LND instance
-	conn, err := grpc.Dial(os.Getenv("LND_ADDRESS"), grpc.WithTransportCredentials(creds))
-	if err != nil {
-		log.Fatalf("Failed to connect to LND gRPC: %v", err)
-	}
-	defer conn.Close()
-	client = lnrpc.NewLightningClient(conn)
-	walletKitClient = walletrpc.NewWalletKitClient(conn)
-	chainNotifierClient = chainrpc.NewChainNotifierClient(conn)
-
-	ssCp := x509.NewCertPool()
-	if !ssCp.AppendCertsFromPEM([]byte(strings.Replace(os.Getenv("SUBSWAPPER_LND_CERT"), "\\n", "\n", -1))) {
-		log.Fatalf("credentials: failed to append certificates")
-	}
-	ssCreds := credentials.NewClientTLSFromCert(ssCp, "")
-	// Address of an LND instance
-	subswapConn, err := grpc.Dial(os.Getenv("SUBSWAPPER_LND_ADDRESS"), grpc.WithTransportCredentials(ssCreds), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(128*1024*1024)))
-	if err != nil {
-		log.Fatalf("Failed to connect to LND gRPC: %v", err)
-	}
-	defer subswapConn.Close()
-	ssClient = lnrpc.NewLightningClient(subswapConn)
-	subswapClient = submarineswaprpc.NewSubmarineSwapperClient(subswapConn)
-	ssWalletKitClient = walletrpc.NewWalletKitClient(subswapConn)
-	ssRouterClient = routerrpc.NewRouterClient(subswapConn)
-
-	ctx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
-	go subscribeTransactions(ctx, client)
-	go handlePastTransactions(ctx, client)
-	go subscribeChannelAcceptor(ctx, client, os.Getenv("LND_CHANNEL_ACCEPTOR"))
-
-	ssCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("SUBSWAPPER_LND_MACAROON_HEX"))
-	go subscribeTransactions(ssCtx, ssClient)
-	go handlePastTransactions(ssCtx, ssClient)
-	go subscribeChannelAcceptor(ssCtx, ssClient, os.Getenv("SUBSWAPPER_LND_CHANNEL_ACCEPTOR"))
-
-	startFeeEstimates()
-
-	err = redisConnect()
-	if err != nil {
-		log.Println("redisConnect error:", err)
-	}
-	go deliverSyncNotifications()
-
-	err = pgConnect()
-	if err != nil {
-		log.Printf("pgConnect error: %v", err)
-	}
-	go registerPastBoltzReverseSwapTxNotifications()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	redeemer := swapper.NewRedeemer(ssClient, ssRouterClient, subswapClient,
-		updateSubswapTxid, updateSubswapPreimage, getInProgressRedeems,
-		setSubswapConfirmed)
-	redeemer.Start(ctx)
-
-	lsp.InitLSP()
-
-	proxyAddress := os.Getenv("PROXY_ADDRESS")
+	httpServers = append(httpServers, HTTPServer)
+	go func() {
+		if err := HTTPServer.Serve(lisHTTP); err != http.ErrServerClosed {
+			log.Printf("http server: %v", err)
+		}
+	}()
+
+	client := lnrpc.NewLightningClient(b.lnd)
+	walletKitClient := walletrpc.NewWalletKitClient(b.lnd)
+	chainNotifierClient := chainrpc.NewChainNotifierClient(b.lnd)
+
+	ssClient := lnrpc.NewLightningClient(b.subswapperLND)
+	subswapClient := submarineswaprpc.NewSubmarineSwapperClient(b.subswapperLND)
+	ssWalletKitClient := walletrpc.NewWalletKitClient(b.subswapperLND)
+	ssRouterClient := routerrpc.NewRouterClient(b.subswapperLND)
+
+	// invalidator purges the tokens which are no longer registered from the
+	// storage.
+	invalidator := notify.NewInvalidator(b.notifier, pg, redisStore)
+	// branded sends the alerts with the templates of the partners.
+	branded := notify.NewBranded(invalidator, pg)
+	svc := &services{
+		cfg:           cfg,
+		lnd:           client,
+		walletKit:     walletKitClient,
+		chainNotifier: chainNotifierClient,
+		redis:         redisStore,
+		db:            pg,
+		notifier:      branded,
+		outbox:        notify.NewOutbox(pg, branded),
+		localizer:     i18n.New(pg),
+		mailer:        b.mailer,
+		blobs:         b.blobs,
+	}
+
+	// workerCtx is cancelled on shutdown. The goroutines outliving the
+	// request which started them derive their context from it and are
+	// tracked by workers.
+	workerCtx, cancelWorkers := context.WithCancel(context.Background())
+	defer cancelWorkers()
+	var workers sync.WaitGroup
+
+	ctx := metadata.AppendToOutgoingContext(workerCtx, "macaroon", cfg.LND.MacaroonHex)
+	workers.Go(func() { svc.subscribeTransactions(ctx, client) })
+	workers.Go(func() { svc.handlePastTransactions(ctx, client) })
+	workers.Go(func() { subscribeChannelAcceptor(ctx, client, cfg.LND.ChannelAcceptor) })
+
+	ssCtx := metadata.AppendToOutgoingContext(workerCtx, "macaroon", cfg.SubswapperLND.MacaroonHex)
+	workers.Go(func() { svc.subscribeTransactions(ssCtx, ssClient) })
+	workers.Go(func() { svc.handlePastTransactions(ssCtx, ssClient) })
+	workers.Go(func() { subscribeChannelAcceptor(ssCtx, ssClient, cfg.SubswapperLND.ChannelAcceptor) })
+
+	workers.Go(func() { svc.watchFeeEstimates(workerCtx) })
+
+	workers.Go(func() { svc.deliverSyncNotifications(workerCtx) })
+	workers.Go(func() { svc.outbox.Run(workerCtx) })
+	workers.Go(func() { invalidator.Run(workerCtx) })
+
+	txNotifier := txnotify.NewServer(workerCtx, cfg.LND.MacaroonHex, client, chainNotifierClient, pg, b.lockupTx)
+	workers.Go(func() { backoff.Retry(workerCtx, "txNotifier.RegisterPast", txNotifier.RegisterPast) })
+	redeemer := swapper.NewRedeemer(cfg.SubswapperLND.MacaroonHex, ssClient, ssRouterClient, subswapClient,
+		pg.UpdateSubswapTxid, pg.UpdateSubswapPreimage, pg.GetInProgressRedeems,
+		pg.SetSubswapConfirmed)
+	redeemer.Start(workerCtx)
+
+	if err := lsp.InitLSP(cfg.LSPConfig, b.dialLSP); err != nil {
+		log.Printf("lsp.InitLSP error: %v", err)
+	}
+
+	validator := validate.New(cfg.Network)
 	s := grpc.NewServer(
+		tracing.ServerOption(),
 		grpc_middleware.WithUnaryServerChain(
-			auth.UnaryMultiAuth("/breez.PublicChannelOpener/", os.Getenv("PUBLIC_CHANNEL_TOKENS")),
-			auth.UnaryAuth("/breez.InactiveNotifier/", os.Getenv("INACTIVE_NOTIFIER_TOKEN")),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Invoicer/RegisterDevice", 3, 10, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Invoicer/RegisterDevice", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Invoicer/SendInvoice", 3, 100, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Invoicer/SendInvoice", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.CardOrderer/Order", 3, 10, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.CardOrderer/Order", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Pos/RegisterDevice", 10, 20, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Pos/RegisterDevice", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Pos/UploadLogo", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Pos/UploadLogo", 10000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Information/Ping", 10000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Information/Ping", 100000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Information/Rates", 10000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Information/Rates", 100000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Information/ReceiverInfo", 10000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Information/ReceiverInfo", 100000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Information/ChainApiServers", 10000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Information/ChainApiServers", 100000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Information/OrchestraConfig", 10000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Information/OrchestraConfig", 100000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/UpdateChannelPolicy", 1000, 100000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/UpdateChannelPolicy", 100000, 1000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/AddFundInit", 20, 200, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/AddFundInit", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/AddFundStatus", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/AddFundStatus", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/RemoveFund", 3, 10, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/RemoveFund", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/RedeemRemovedFunds", 10, 100, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/RedeemRemovedFunds", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/GetSwapPayment", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/GetSwapPayment", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.FundManager/RegisterTransactionConfirmation", 10, 100, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.FundManager/RegisterTransactionConfirmation", 100, 10000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Swapper/AddFundInit", 20, 200, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Swapper/AddFundInit", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Swapper/AddFundStatus", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Swapper/AddFundStatus", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Swapper/GetSwapPayment", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Swapper/GetSwapPayment", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Swapper/GetReverseRoutingNode", 10000, 100000, 7200),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Swapper/GetReverseRoutingNode", 10000, 100000, 7200),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.CTP/JoinCTPSession", 1000, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.CTP/JoinCTPSession", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.CTP/TerminateCTPSession", 1000, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.CTP/TerminateCTPSession", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.ChannelOpener/LSPList", 10000, 10000000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.ChannelOpener/LSPList", 10000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.ChannelOpener/LSPFullList", 10000, 10000000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.ChannelOpener/LSPFullList", 10000, 10000000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.PushTxNotifier/RegisterTxNotification", 10, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.PushTxNotifier/RegisterTxNotification", 1000, 1000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez/InactiveNotifier/InactiveNotify", 1000, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez/InactiveNotifier/InactiveNotify", 1000, 1000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.NodeInfo/SetNodeInfo", 1000, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.NodeInfo/SetNodeInfo", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.NodeInfo/GetNodeInfo", 1000, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.NodeInfo/GetNodeInfo", 1000, 100000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Signer/SignUrl", 10, 10000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Signer/SignUrl", 1000, 1000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Support/ReportPaymentFailure", 10, 20, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Support/ReportPaymentFailure", 100, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.Support/BreezStatus", 100, 2000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.Support/BreezStatus", 1000, 100000, 86400),
-
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.TaprootSwapper/CreateSwap", 20, 200, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.TaprootSwapper/CreateSwap", 1000, 100000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.TaprootSwapper/PaySwap", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.TaprootSwapper/PaySwap", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.TaprootSwapper/Refundwap", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.TaprootSwapper/RefundSwap", 1000, 10000, 86400),
-			ratelimit.PerIPUnaryRateLimiter(redisPool, proxyAddress, "rate-limit", "/breez.TaprootSwapper/SwapParameters", 100, 1000, 86400),
-			ratelimit.UnaryRateLimiter(redisPool, "rate-limit", "/breez.TaprootSwapper/SwapParameters", 1000, 10000, 86400),
+			metrics.UnaryServerInterceptor(),
+			ipResolver.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(),
+			rpcerror.UnaryServerInterceptor(),
+			auth.UnaryMultiAuth("/breez.PublicChannelOpener/", cfg.PublicChannelTokens),
+			auth.UnaryAuth("/breez.InactiveNotifier/", cfg.InactiveNotifierToken),
+			limiter.UnaryInterceptor(),
+			validator.UnaryServerInterceptor(),
+			svc.localizer.UnaryServerInterceptor(),
+			branded.UnaryServerInterceptor(),
+		),
+		grpc_middleware.WithStreamServerChain(
+			metrics.StreamServerInterceptor(),
+			ipResolver.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(),
+			rpcerror.StreamServerInterceptor(),
+			auth.StreamMultiAuth("/breez.PublicChannelOpener/", cfg.PublicChannelTokens),
+			auth.StreamAuth("/breez.InactiveNotifier/", cfg.InactiveNotifierToken),
+			limiter.StreamInterceptor(),
+			validator.StreamServerInterceptor(),
 		),
 	)
 
-	supportServer := support.NewServer(sendPaymentFailureNotification, breezStatus, lspFullList)
+	supportServer := support.NewServer(func(in *breez.ReportPaymentFailureRequest, keys []string) error {
+		return sendPaymentFailureNotification(svc.mailer, cfg.PaymentFailureNotification, in, keys)
+	}, pg.BreezStatus, pg.LSPFullList)
 	breez.RegisterSupportServer(s, supportServer)
 
-	swapperServer = swapper.NewServer(network, redisPool, client, ssClient, subswapClient, redeemer, ssWalletKitClient, ssRouterClient,
-		insertSubswapPayment, updateSubswapPreimage, hasFilteredAddress)
+	swapperServer := swapper.NewServer(cfg.Network, cfg.LND.MacaroonHex, cfg.SubswapperLND.MacaroonHex, cfg.ReverseSwapRoutingNode,
+		redisStore, client, ssClient, subswapClient, redeemer, ssWalletKitClient, ssRouterClient,
+		pg.InsertSubswapPayment, pg.UpdateSubswapPreimage, pg.HasFilteredAddress, b.senderAddresses)
 	breez.RegisterSwapperServer(s, swapperServer)
 
 	lspServer := &lsp.Server{
-		DBLSPList:     lspList,
-		DBLSPFullList: lspFullList,
+		DBLSPList:     pg.LSPList,
+		DBLSPFullList: pg.LSPFullList,
 	}
 
-	taprootSwapperConn, err := grpc.Dial(os.Getenv("SWAPD_ADDRESS"), grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("Failed to connect to swapd gRPC: %v", err)
-	}
-	taprootSwapperClient := breez.NewTaprootSwapperClient(taprootSwapperConn)
+	taprootSwapperClient := breez.NewTaprootSwapperClient(b.swapd)
 	taprootSwapperServer := swapd.NewServer(taprootSwapperClient)
 
-	informationServer := &server{
+	healthChecker.Add("lnd", true, lndCheck(client, cfg.LND.MacaroonHex))
+	healthChecker.Add("subswapper-lnd", true, lndCheck(ssClient, cfg.SubswapperLND.MacaroonHex))
+	// The server runs degraded without postgres or redis: the features using
+	// them return Unavailable until they are back.
+	healthChecker.Add("postgres", false, storeCheck(pg))
+	healthChecker.Add("redis", false, storeCheck(redisStore))
+	healthChecker.Add("swapd", false, swapdCheck(taprootSwapperClient))
+	healthChecker.Add("lspd", false, lsp.Check)
+	healthChecker.Add("liquid-esplora", false, esploraCheck(liquidEsploraBaseURL))
+
+	mainServer := &server{
+		services:         svc,
+		swapperServer:    swapperServer,
+		removeFunds:      removefunds.NewServer(cfg.Network, cfg.LND.MacaroonHex, client, redisStore),
 		chainApiServers:  chainApiServers,
-		orchestraBaseURL: os.Getenv("ORCHESTRA_BASE_URL"),
-		orchestraApiKey:  os.Getenv("ORCHESTRA_API_KEY"),
+		orchestraBaseURL: cfg.OrchestraBaseURL,
+		orchestraApiKey:  cfg.OrchestraAPIKey,
 	}
 	breez.RegisterChannelOpenerServer(s, lspServer)
 	breez.RegisterPaymentNotifierServer(s, lspServer)
-	breez.RegisterInvoicerServer(s, &server{})
-	breez.RegisterPosServer(s, &server{})
-	breez.RegisterInformationServer(s, informationServer)
-	breez.RegisterCardOrdererServer(s, &server{})
-	breez.RegisterFundManagerServer(s, &server{})
-	breez.RegisterCTPServer(s, &server{})
-	breez.RegisterSyncNotifierServer(s, &server{})
-	breez.RegisterPushTxNotifierServer(s, &server{})
-	breez.RegisterInactiveNotifierServer(s, &server{})
-	breez.RegisterNodeInfoServer(s, &server{})
-	breez.RegisterSignerServer(s, &signer.Server{})
+	breez.RegisterInvoicerServer(s, mainServer)
+	breez.RegisterPosServer(s, mainServer)
+	breez.RegisterInformationServer(s, mainServer)
+	breez.RegisterCardOrdererServer(s, mainServer)
+	breez.RegisterFundManagerServer(s, mainServer)
+	breez.RegisterCTPServer(s, ctp.NewServer(redisStore, svc.outbox, svc.localizer))
+	breez.RegisterSyncNotifierServer(s, mainServer)
+	breez.RegisterPushTxNotifierServer(s, txNotifier)
+	breez.RegisterInactiveNotifierServer(s, mainServer)
+	breez.RegisterNodeInfoServer(s, nodeinfo.NewServer(redisStore))
+	breez.RegisterSignerServer(s, signer.NewServer(cfg.MoonPaySecret))
 	breez.RegisterTaprootSwapperServer(s, taprootSwapperServer)
+	healthpb.RegisterHealthServer(s, healthChecker.GRPCServer())
+	metrics.RegisterGRPCServer(s)
 
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
-	if err := s.Serve(lisGRPC); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+
+	if err := limiter.Validate(s.GetServiceInfo(), mux.patterns); err != nil {
+		log.Fatalf("Rate limiter: %v", err)
+	}
+	workers.Go(func() { reloadRateLimitPolicyOnHUP(workerCtx, limiter) })
+
+	stop, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	// The health checker reports NOT_SERVING as soon as the shutdown starts,
+	// so that the load balancers stop sending new requests.
+	workers.Go(func() { healthChecker.Run(stop, 15*time.Second) })
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve(lisGRPC) }()
+	select {
+	case err := <-serveErr:
+		log.Printf("failed to serve: %v", err)
+	case <-stop.Done():
+		log.Printf("Shutting down")
+	}
+	shutdown(cfg.ShutdownTimeout, s, httpServers, cancelWorkers, []waiter{&workers, redeemer, txNotifier}, redisStore, pg)
+}
+
+// waiter is a group of background goroutines.
+type waiter interface {
+	Wait()
+}
+
+// shutdown stops the servers, letting the in-flight requests complete, then
+// cancels the background workers and closes the storages. It gives up waiting
+// after timeout.
+func shutdown(timeout time.Duration, s *grpc.Server, httpServers []*http.Server,
+	cancelWorkers context.CancelFunc, workers []waiter, redisStore *store.Redis, pg *store.Postgres) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var servers sync.WaitGroup
+	for _, srv := range httpServers {
+		servers.Go(func() {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("http server shutdown: %v", err)
+				srv.Close()
+			}
+		})
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("grpc server shutdown: %v", ctx.Err())
+		s.Stop()
+	}
+	servers.Wait()
+
+	cancelWorkers()
+	done := make(chan struct{})
+	go func() {
+		for _, w := range workers {
+			w.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("background workers still running after %v", timeout)
+	}
+
+	pg.Close()
+	if err := redisStore.Close(); err != nil {
+		log.Printf("redisStore.Close: %v", err)
 	}
 }
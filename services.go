@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/breez/server/config"
+	"github.com/breez/server/i18n"
+	"github.com/breez/server/notify"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/chainrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+)
+
+// redisStore is the part of the Redis storage used by the main package.
+type redisStore interface {
+	UpdateKeyFields(key string, fields map[string]string) error
+	GetKeyFields(key string) (map[string]string, error)
+	DeleteKey(key string) error
+	SetKeyExpiration(key string, seconds int64) error
+	AddToSet(set, member string) error
+	PopFromSet(set string, count int) ([]string, error)
+	IsSetMember(set, member string) (bool, error)
+	SetMembers(set string) ([]string, error)
+	RemoveFromSet(set, member string) (int64, error)
+	PushWithScore(set string, key string, score int64) (bool, error)
+	IndexToken(token, key, member string) error
+	SetSize(set string) (int64, error)
+	PopMinScore(set string, timeout time.Duration) (string, float64, error)
+}
+
+// database is the part of the Postgres storage used by the main package.
+type database interface {
+	BreezAppVersions() ([]string, error)
+	DeviceNode(nodeID []byte, deviceID string) error
+	DeviceToken(nodeID []byte) (string, error)
+}
+
+// mailer sends the notification emails.
+type mailer interface {
+	SendEmail(to, cc, from, content, subject string) error
+}
+
+// blobStore stores the uploaded files.
+type blobStore interface {
+	// Put stores content under the slash separated path name and returns
+	// its public URL.
+	Put(ctx context.Context, name string, content []byte) (string, error)
+}
+
+// services holds the backends shared by the grpc services and the background
+// workers of the main package. The backends are interfaces, so that they can
+// be replaced by fakes.
+type services struct {
+	cfg           *config.Config
+	lnd           lnrpc.LightningClient
+	walletKit     walletrpc.WalletKitClient
+	chainNotifier chainrpc.ChainNotifierClient
+	redis         redisStore
+	db            database
+	notifier      notify.Notifier
+	// outbox delivers the notifications which must survive the failures of
+	// the providers.
+	outbox *notify.Outbox
+	// localizer renders the texts of the notifications in the language of
+	// their device.
+	localizer *i18n.Localizer
+	mailer    mailer
+	blobs     blobStore
+}
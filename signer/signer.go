@@ -5,22 +5,26 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
 	"net/url"
-	"os"
 
 	"github.com/breez/server/breez"
+	"github.com/breez/server/rpcerror"
 )
 
 type Server struct {
 	breez.UnimplementedSignerServer
+	moonPaySecret string
+}
+
+func NewServer(moonPaySecret string) *Server {
+	return &Server{moonPaySecret: moonPaySecret}
 }
 
 func (s *Server) SignUrl(ctx context.Context, in *breez.SignUrlRequest) (*breez.SignUrlResponse, error) {
 	if in.BaseUrl != "https://buy.moonpay.io" {
-		return nil, fmt.Errorf("invalid URL")
+		return nil, rpcerror.InvalidArgument("baseUrl", "invalid URL")
 	}
-	h := hmac.New(sha256.New, []byte(os.Getenv("MOONPAY_SECRET")))
+	h := hmac.New(sha256.New, []byte(s.moonPaySecret))
 	h.Write([]byte(in.QueryString))
 	var out breez.SignUrlResponse
 	out.FullUrl = in.BaseUrl + in.QueryString + "&signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(h.Sum(nil)))
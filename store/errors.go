@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/breez/server/rpcerror"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrUnavailable is wrapped by the errors of the storage when its server
+// cannot be reached. The grpc status of these errors is Unavailable, so that
+// the features depending on a storage which is down fail with a clear error
+// while the rest of the server keeps working.
+var ErrUnavailable = errors.New("storage unavailable")
+
+type unavailableError struct {
+	name string
+	err  error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%v is unavailable: %v", e.name, e.err)
+}
+
+func (e *unavailableError) Unwrap() []error {
+	return []error{ErrUnavailable, e.err}
+}
+
+func (e *unavailableError) GRPCStatus() *status.Status {
+	return rpcerror.New(codes.Unavailable, rpcerror.ReasonStorageUnavailable,
+		fmt.Sprintf("%v is temporarily unavailable", e.name)).WithMetadata("storage", e.name).GRPCStatus()
+}
+
+// unavailable wraps err in an unavailableError when it is a connection
+// failure to the storage name.
+func unavailable(name string, err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) || !isConnectionError(err) {
+		return err
+	}
+	return &unavailableError{name: name, err: err}
+}
+
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &netErr) ||
+		errors.As(err, &connectErr) ||
+		errors.Is(err, redis.ErrPoolExhausted) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The statuses of the notifications of the outbox.
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	// OutboxDead is the status of the notifications which failed
+	// permanently, or too many times.
+	OutboxDead = "dead"
+)
+
+// The kinds of the notifications of the outbox.
+const (
+	OutboxAlert = "alert"
+	OutboxData  = "data"
+)
+
+// OutboxNotification is a push notification of the outbox.
+type OutboxNotification struct {
+	ID int64
+	// Source is the feature sending the notification, such as ctp or
+	// tx_confirmation.
+	Source string
+	// Kind is alert or data.
+	Kind string
+	// Type is the type of an alert, selecting the template of the partner
+	// of the device.
+	Type          string
+	Token         string
+	Title         string
+	Body          string
+	Data          map[string]string
+	Status        string
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	DeliveredAt   *time.Time
+}
+
+const outboxColumns = `id, source, kind, notification_type, token, title, body, data, status, attempts,
+	last_error, created_at, next_attempt_at, delivered_at`
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// EnqueueNotification adds n to the outbox, to be delivered as soon as
+// possible.
+func (p *Postgres) EnqueueNotification(n *OutboxNotification) error {
+	return enqueueNotification(context.Background(), p.pool, n)
+}
+
+func enqueueNotification(ctx context.Context, db execer, n *OutboxNotification) error {
+	data := n.Data
+	if data == nil {
+		data = map[string]string{}
+	}
+	_, err := db.Exec(ctx,
+		`INSERT INTO notification_outbox
+		  (source, kind, notification_type, token, title, body, data)
+		  VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		n.Source, n.Kind, n.Type, n.Token, n.Title, n.Body, data)
+	if err != nil {
+		return fmt.Errorf("INSERT INTO notification_outbox: %w", unavailable("postgres", err))
+	}
+	return nil
+}
+
+// ClaimNotifications returns up to limit notifications due for delivery,
+// and counts their attempt. They are not returned again before lease, so
+// that the concurrent workers, of this server or the others, skip them.
+func (p *Postgres) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]OutboxNotification, error) {
+	rows, err := p.pool.Query(ctx,
+		`UPDATE notification_outbox o
+		 SET attempts = o.attempts + 1, next_attempt_at = now() + make_interval(secs => $2)
+		 FROM (
+		   SELECT id FROM notification_outbox
+		   WHERE status = 'pending' AND next_attempt_at <= now()
+		   ORDER BY next_attempt_at
+		   LIMIT $1
+		   FOR UPDATE SKIP LOCKED
+		 ) due
+		 WHERE o.id = due.id
+		 RETURNING o.id, o.source, o.kind, o.notification_type, o.token, o.title, o.body, o.data, o.status, o.attempts,
+		   o.last_error, o.created_at, o.next_attempt_at, o.delivered_at`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("pgxPool.Query(): %w", err)
+	}
+	return scanOutbox(rows)
+}
+
+// NotificationDelivered records the delivery of the notification id.
+func (p *Postgres) NotificationDelivered(ctx context.Context, id int64) error {
+	_, err := p.pool.Exec(ctx,
+		`UPDATE notification_outbox
+		 SET status = 'delivered', delivered_at = now(), last_error = NULL
+		 WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	return nil
+}
+
+// RetryNotification records the failure of the notification id, to be sent
+// again at at.
+func (p *Postgres) RetryNotification(ctx context.Context, id int64, lastError string, at time.Time) error {
+	_, err := p.pool.Exec(ctx,
+		`UPDATE notification_outbox
+		 SET last_error = $2, next_attempt_at = $3
+		 WHERE id = $1`, id, lastError, at)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	return nil
+}
+
+// DeadLetterNotification records the final failure of the notification id,
+// which is not sent again.
+func (p *Postgres) DeadLetterNotification(ctx context.Context, id int64, lastError string) error {
+	_, err := p.pool.Exec(ctx,
+		`UPDATE notification_outbox
+		 SET status = 'dead', last_error = $2
+		 WHERE id = $1`, id, lastError)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	return nil
+}
+
+// Notifications returns the last limit notifications of the outbox, newest
+// first, of token and of status when they are not empty.
+func (p *Postgres) Notifications(ctx context.Context, token, status string, limit int) ([]OutboxNotification, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT `+outboxColumns+`
+		 FROM notification_outbox
+		 WHERE ($1::varchar = '' OR token = $1::varchar) AND ($2::varchar = '' OR status = $2::varchar)
+		 ORDER BY id DESC
+		 LIMIT $3`,
+		token, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("pgxPool.Query(): %w", err)
+	}
+	return scanOutbox(rows)
+}
+
+func scanOutbox(rows pgx.Rows) ([]OutboxNotification, error) {
+	defer rows.Close()
+	var notifications []OutboxNotification
+	for rows.Next() {
+		var n OutboxNotification
+		var lastError *string
+		err := rows.Scan(&n.ID, &n.Source, &n.Kind, &n.Type, &n.Token, &n.Title, &n.Body, &n.Data, &n.Status, &n.Attempts,
+			&lastError, &n.CreatedAt, &n.NextAttemptAt, &n.DeliveredAt)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		if lastError != nil {
+			n.LastError = *lastError
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, unavailable("postgres", rows.Err())
+}
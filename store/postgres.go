@@ -0,0 +1,614 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/breez/server/breez"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/ratelimit"
+	"github.com/breez/server/swapper"
+	"github.com/breez/server/tracing"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	TypeUnknown = iota
+	TypeBoltzReverseSwapLockup
+)
+
+const (
+	StatusUnknown = iota
+	StatusUnconfirmed
+	StatusNotified
+)
+
+type BoltzReverseSwapInfo struct {
+	ID                 string `json:"id"`
+	TimeoutBlockHeight uint32 `json:"timeout_block_height"`
+}
+
+// TxNotification is a registration for the notification of a transaction
+// confirmation.
+type TxNotification struct {
+	ID                   uuid.UUID
+	BoltzReverseSwapInfo BoltzReverseSwapInfo
+	Title                string
+	Body                 string
+	DeviceID             string
+	TxHash               []byte
+	Script               []byte
+	BlockHeightHint      uint32
+}
+
+// Postgres is the Postgres storage.
+type Postgres struct {
+	pool pgPool
+}
+
+// NewPostgres returns the storage of the database at databaseURL.
+// Connections are made on demand.
+func NewPostgres(ctx context.Context, databaseURL string) (*Postgres, error) {
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool.ParseConfig: %w", err)
+	}
+	config.ConnConfig.Tracer = tracing.PostgresTracer{}
+	if config.ConnConfig.ConnectTimeout == 0 {
+		config.ConnConfig.ConnectTimeout = connectTimeout
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool.New: %w", err)
+	}
+	return &Postgres{pool: pgPool{pool}}, nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	return unavailable("postgres", p.pool.Ping(ctx))
+}
+
+// pgPool is a pgxpool.Pool whose connection failures wrap ErrUnavailable.
+type pgPool struct {
+	*pgxpool.Pool
+}
+
+func (p pgPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	commandTag, err := p.Pool.Exec(ctx, sql, args...)
+	return commandTag, unavailable("postgres", err)
+}
+
+func (p pgPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := p.Pool.Query(ctx, sql, args...)
+	return rows, unavailable("postgres", err)
+}
+
+func (p pgPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return pgRow{p.Pool.QueryRow(ctx, sql, args...)}
+}
+
+type pgRow struct {
+	pgx.Row
+}
+
+func (r pgRow) Scan(dest ...any) error {
+	return unavailable("postgres", r.Row.Scan(dest...))
+}
+
+func (p *Postgres) InsertSubswapPayment(paymentHash, paymentRequest string, lockheight, confirmationheight int32, utxos []string) error {
+	commandTag, err := p.pool.Exec(context.Background(),
+		`INSERT INTO swap_payments
+          (payment_hash, payment_request, lock_height, confirmation_height, utxos, redeem_confirmed)
+          VALUES ($1, $2, $3, $4, $5, false)
+          ON CONFLICT DO NOTHING`,
+		paymentHash,
+		paymentRequest,
+		lockheight,
+		confirmationheight,
+		utxos,
+	)
+	if err != nil {
+		log.Printf("pgxPool.Exec('INSERT INTO swap_payments(%v, %v): %v",
+			paymentHash, paymentRequest, err)
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	log.Printf("pgxPool.Exec('INSERT INTO swap_payments(%v, %v)'; RowsAffected(): %v'",
+		paymentHash, paymentRequest, commandTag.RowsAffected())
+	return nil
+}
+
+func (p *Postgres) GetInProgressRedeems(blockheight int32) ([]*swapper.InProgressRedeem, error) {
+	ignoreBefore := blockheight - (288 * 100)
+	rows, err := p.pool.Query(context.Background(),
+		`SELECT payment_hash
+		 ,      payment_preimage
+		 ,      lock_height
+		 ,      confirmation_height
+		 ,      utxos
+		 ,      txid
+		 FROM swap_payments
+		 WHERE redeem_confirmed = false
+		 	AND payment_preimage is not null
+		 	AND confirmation_height > $1
+		 ORDER BY confirmation_height
+	`, ignoreBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query swap_payments: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*swapper.InProgressRedeem
+	for rows.Next() {
+		var payment_hash string
+		var payment_preimage *string
+		var lock_height int32
+		var confirmation_height int32
+		var utxos []string
+		var txid []string
+		err = rows.Scan(
+			&payment_hash,
+			&payment_preimage,
+			&lock_height,
+			&confirmation_height,
+			&utxos,
+			&txid,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan() error: %w", err)
+		}
+
+		result = append(result, &swapper.InProgressRedeem{
+			PaymentHash:        payment_hash,
+			Preimage:           payment_preimage,
+			LockHeight:         lock_height,
+			ConfirmationHeight: confirmation_height,
+			Utxos:              utxos,
+			RedeemTxids:        txid,
+		})
+	}
+
+	return result, nil
+}
+
+func (p *Postgres) UpdateSubswapPreimage(paymentHash, paymentPreimage string) error {
+	commandTag, err := p.pool.Exec(context.Background(),
+		`UPDATE swap_payments
+         SET
+          payment_preimage=$2
+         WHERE payment_hash=$1`, paymentHash, paymentPreimage)
+	if err != nil {
+		log.Printf("updateSubswapPreimage(%v, %v): %v",
+			paymentHash, paymentPreimage, err)
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	log.Printf("updateSubswapPreimage(%v, %v)'; RowsAffected(): %v'",
+		paymentHash, paymentPreimage, commandTag.RowsAffected())
+	return nil
+}
+
+func (p *Postgres) UpdateSubswapTxid(paymentHash, txid string) error {
+	commandTag, err := p.pool.Exec(context.Background(),
+		`UPDATE swap_payments
+         SET
+          txid=txid||$2
+         WHERE payment_hash=$1`, paymentHash, []string{txid})
+	if err != nil {
+		log.Printf("updateSubswapTxid(%v, %v): %v",
+			paymentHash, txid, err)
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	log.Printf("updateSubswapTxid(%v, %v)'; RowsAffected(): %v'",
+		paymentHash, txid, commandTag.RowsAffected())
+	return nil
+}
+
+func (p *Postgres) SetSubswapConfirmed(paymentHash string) error {
+	_, err := p.pool.Exec(context.Background(),
+		`UPDATE swap_payments
+		 SET redeem_confirmed = true
+		 WHERE payment_hash = $1
+		`,
+		paymentHash,
+	)
+	return err
+}
+
+func (p *Postgres) InsertTxNotification(in *breez.PushTxNotificationRequest) (*uuid.UUID, error) {
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("uuid.NewRandom(): %w", err)
+	}
+	var additionalInfo []byte
+	var txType int32
+	switch x := in.Info.(type) {
+	case *breez.PushTxNotificationRequest_BoltzReverseSwapLockupTxInfo:
+		additionalInfo, _ = json.Marshal(BoltzReverseSwapInfo{
+			ID:                 x.BoltzReverseSwapLockupTxInfo.BoltzId,
+			TimeoutBlockHeight: x.BoltzReverseSwapLockupTxInfo.TimeoutBlockHeight,
+		})
+		txType = TypeBoltzReverseSwapLockup
+	default:
+		txType = TypeUnknown
+	}
+	commandTag, err := p.pool.Exec(context.Background(),
+		`INSERT INTO tx_notifications
+		  (id, tx_type, status, additional_info, title, body, device_id, tx_hash, script, block_height_hint)
+		  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		  ON CONFLICT (device_id, script) DO NOTHING`,
+		pgtype.UUID{Bytes: u, Status: pgtype.Present},
+		txType,
+		StatusUnconfirmed,
+		additionalInfo,
+		in.Title,
+		in.Body,
+		in.DeviceId,
+		in.TxHash,
+		in.Script,
+		in.BlockHeightHint,
+	)
+	if err != nil {
+		log.Printf("pgxPool.Exec(): %v", err)
+		return nil, fmt.Errorf("pgxPool.Exec(): %w", unavailable("postgres", err))
+	}
+	log.Printf("pgxPool.Exec('INSERT INTO tx_notification()'; RowsAffected(): %v'", commandTag.RowsAffected())
+	if commandTag.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+// TxNotified records the confirmation of the transaction of the
+// registration u and enqueues its notification in the same transaction.
+func (p *Postgres) TxNotified(u uuid.UUID, txHash chainhash.Hash, tx []byte, blockHeigh uint32, blockHash []byte, txIndex uint32, notification *OutboxNotification) error {
+	ctx := context.Background()
+	dbTx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Begin(): %w", unavailable("postgres", err))
+	}
+	defer dbTx.Rollback(ctx)
+	commandTag, err := dbTx.Exec(ctx,
+		`UPDATE tx_notifications
+		 SET status = $2, tx_hash=$3, tx=$4, block_height=$5, block_hash=$6, tx_index=$7
+		 WHERE id=$1`,
+		u, StatusNotified, txHash.CloneBytes(), tx, blockHeigh, blockHash, txIndex,
+	)
+	if err != nil {
+		log.Printf("pgxPool.Exec(): %v", err)
+		return fmt.Errorf("pgxPool.Exec(): %w", unavailable("postgres", err))
+	}
+	log.Printf("pgxPool.Exec('UPDATE tx_notifications'; RowsAffected(): %v'", commandTag.RowsAffected())
+	// The registrations of the purged tokens are deleted: their
+	// notification would fail.
+	if notification != nil && commandTag.RowsAffected() > 0 {
+		if err := enqueueNotification(ctx, dbTx, notification); err != nil {
+			return err
+		}
+	}
+	if err := dbTx.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit(): %w", unavailable("postgres", err))
+	}
+	return nil
+}
+
+// BoltzReverseSwapToNotify returns the unconfirmed boltz reverse swap lockup
+// transactions whose timeout is after currentHeight.
+func (p *Postgres) BoltzReverseSwapToNotify(currentHeight uint32) ([]TxNotification, error) {
+	rows, err := p.pool.Query(context.Background(),
+		`SELECT id, additional_info, title, body, device_id, tx_hash, script, block_height_hint
+		 FROM tx_notifications tn
+		 WHERE tn.tx_type=$1 AND tn.status=$2 AND cast(tn.additional_info->>'timeout_block_height' as int)>$3`,
+		TypeBoltzReverseSwapLockup, StatusUnconfirmed, currentHeight,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pgxPool.Query(): %w", err)
+	}
+	defer rows.Close()
+	var notifications []TxNotification
+	for rows.Next() {
+		var n TxNotification
+		err = rows.Scan(&n.ID, &n.BoltzReverseSwapInfo, &n.Title, &n.Body, &n.DeviceID, &n.TxHash, &n.Script, &n.BlockHeightHint)
+		if err != nil {
+			log.Printf("rows.Scan: %v", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (p *Postgres) BreezAppVersions() ([]string, error) {
+	rows, err := p.pool.Query(context.Background(),
+		`SELECT version FROM breez_app_versions`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pgxPool.Query(): %w", err)
+	}
+	defer rows.Close()
+	var versions []string
+	for rows.Next() {
+		var version string
+		err = rows.Scan(&version)
+		if err != nil {
+			log.Printf("rows.Scan: %v", err)
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (p *Postgres) BreezStatus() (string, error) {
+	var statusCode string
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT value->>'code' as status
+		FROM breez_info
+		WHERE "key"='status'
+		ORDER by "timestamp" DESC
+		LIMIT 1`).Scan(&statusCode)
+	return statusCode, err
+}
+
+func (p *Postgres) DeviceNode(nodeID []byte, deviceID string) error {
+	commandTag, err := p.pool.Exec(context.Background(),
+		`INSERT INTO deviceid_nodeid
+		  (nodeid, deviceid, first_registration)
+		  VALUES ($1, $2, NOW())
+		  ON CONFLICT (nodeid) DO UPDATE SET deviceid=$2`,
+		nodeID, deviceID)
+	if err != nil {
+		log.Printf("pgxPool.Exec(): %v", err)
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	log.Printf("pgxPool.Exec('INSERT INTO deviceid_nodeid(%x, %v)'; RowsAffected(): %v'", nodeID, logging.Secret(deviceID), commandTag.RowsAffected())
+	return nil
+}
+
+func (p *Postgres) DeviceToken(nodeID []byte) (string, error) {
+	var token string
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT deviceid
+		  FROM deviceid_nodeid
+		  WHERE nodeid=$1`, nodeID).Scan(&token)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SetDeviceLocale records locale, a BCP 47 language tag, as the locale of
+// the device of the notification token.
+func (p *Postgres) SetDeviceLocale(token, locale string) error {
+	_, err := p.pool.Exec(context.Background(),
+		`INSERT INTO device_locales
+		  (token, locale)
+		  VALUES ($1, $2)
+		  ON CONFLICT (token) DO UPDATE SET locale=$2, updated_at=now()
+		  WHERE device_locales.locale <> $2`,
+		token, locale)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	return nil
+}
+
+// DeviceLocale returns the locale of the device of the notification token,
+// or "" when it is not known.
+func (p *Postgres) DeviceLocale(token string) (string, error) {
+	var locale string
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT locale
+		  FROM device_locales
+		  WHERE token=$1`, token).Scan(&locale)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return locale, nil
+}
+
+func (p *Postgres) HasFilteredAddress(addrs []string) (bool, error) {
+	var count int
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT count(*)
+		  FROM filtered_addresses
+		  WHERE address = ANY ($1)`, addrs).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (p *Postgres) LSPList(apiKeys []string) ([]string, error) {
+	type void struct{}
+	var member void
+
+	rows, err := p.pool.Query(context.Background(),
+		`SELECT COALESCE(lsp_ids->'active', lsp_ids) as lsp_ids, api_user FROM api_keys
+			WHERE api_key = ANY($1)`, apiKeys)
+	if err != nil {
+		log.Printf("error in pgxPool.Query: %v", err)
+		return nil, fmt.Errorf("error in pgxPool.Query: %w", err)
+	}
+	defer rows.Close()
+	lspSet := make(map[string]void)
+	for rows.Next() {
+		var lspIds []string
+		var apiUser string
+		err = rows.Scan(&lspIds, &apiUser)
+		if err != nil {
+			log.Printf("error in rows.Scan: %v", err)
+			continue
+		}
+		log.Printf("ids: %#v, user: %#v", lspIds, apiUser)
+		for _, l := range lspIds {
+			lspSet[l] = member
+		}
+	}
+	var lspList []string
+	for l := range lspSet {
+		lspList = append(lspList, l)
+	}
+	return lspList, nil
+}
+
+func (p *Postgres) LSPFullList(apiKeys []string) ([]string, []string, error) {
+	type void struct{}
+	var member void
+
+	rows, err := p.pool.Query(context.Background(),
+		`SELECT
+			COALESCE(lsp_ids->'active', lsp_ids) as active_lsp_ids,
+			COALESCE(lsp_ids->'inactive','[]') as inactive_lsp_ids,
+			api_user
+		FROM api_keys
+        WHERE api_key = ANY($1)`, apiKeys)
+	if err != nil {
+		log.Printf("error in pgxPool.Query: %v", err)
+		return nil, nil, fmt.Errorf("error in pgxPool.Query: %w", err)
+	}
+	defer rows.Close()
+	var activeWithDups, inactiveWithDups []string
+	for rows.Next() {
+		var thisActive, thisInactive []string
+		var apiUser string
+		err = rows.Scan(&thisActive, &thisInactive, &apiUser)
+		if err != nil {
+			log.Printf("error in rows.Scan: %v", err)
+			continue
+		}
+		log.Printf("active ids: %#v, inactive ids: %#v, user: %#v", thisActive, thisInactive, apiUser)
+		activeWithDups = append(activeWithDups, thisActive...)
+		inactiveWithDups = append(inactiveWithDups, thisInactive...)
+	}
+	lspSet := make(map[string]void)
+	var active, inactive []string
+	for _, l := range activeWithDups {
+		if _, ok := lspSet[l]; !ok {
+			active = append(active, l)
+			lspSet[l] = member
+		}
+	}
+	for _, l := range inactiveWithDups {
+		if _, ok := lspSet[l]; !ok {
+			inactive = append(inactive, l)
+			lspSet[l] = member
+		}
+	}
+	return active, inactive, nil
+}
+
+// APIKeyRateLimits returns the rate limits overridden for a partner in the
+// rate_limits column of api_keys, keyed by method pattern, and whether apiKey
+// is in api_keys.
+func (p *Postgres) APIKeyRateLimits(apiKey string) (map[string]ratelimit.Limit, bool, error) {
+	var data []byte
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT rate_limits
+		  FROM api_keys
+		  WHERE api_key=$1`, apiKey).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("pgxPool.QueryRow(): %w", err)
+	}
+	if data == nil {
+		return nil, true, nil
+	}
+	var limits map[string]ratelimit.Limit
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, true, fmt.Errorf("json.Unmarshal(rate_limits): %w", err)
+	}
+	return limits, true, nil
+}
+
+// SetDevicePartner records the partner of apiKeys, their api_user, as the
+// partner of the device of the notification token.
+func (p *Postgres) SetDevicePartner(token string, apiKeys []string) error {
+	_, err := p.pool.Exec(context.Background(),
+		`INSERT INTO device_partners
+		  (token, api_user)
+		  SELECT $1, api_user FROM api_keys
+		  WHERE api_key = ANY($2)
+		  ORDER BY api_user
+		  LIMIT 1
+		  ON CONFLICT (token) DO UPDATE SET api_user=EXCLUDED.api_user, updated_at=now()
+		  WHERE device_partners.api_user <> EXCLUDED.api_user`,
+		token, apiKeys)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec(): %w", err)
+	}
+	return nil
+}
+
+// NotificationTemplates returns the notification_templates column of
+// api_keys of the partner of the device of the notification token, or nil
+// when the device has no partner or the partner no templates.
+func (p *Postgres) NotificationTemplates(token string) ([]byte, error) {
+	var data []byte
+	err := p.pool.QueryRow(context.Background(),
+		`SELECT k.notification_templates
+		  FROM device_partners d
+		  JOIN api_keys k ON k.api_user = d.api_user
+		  WHERE d.token=$1 AND k.notification_templates IS NOT NULL
+		  ORDER BY k.api_key
+		  LIMIT 1`, token).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pgxPool.QueryRow(): %w", err)
+	}
+	return data, nil
+}
+
+// PurgeToken forgets the notification token of a device which is no longer
+// registered: its node, transaction notifications, locale and partner are
+// deleted, and its pending notifications are dead-lettered. The delivered
+// notifications are kept as the history of the outbox.
+func (p *Postgres) PurgeToken(ctx context.Context, token string) error {
+	dbTx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Begin(): %w", unavailable("postgres", err))
+	}
+	defer dbTx.Rollback(ctx)
+	statements := []string{
+		`DELETE FROM deviceid_nodeid WHERE deviceid=$1`,
+		`DELETE FROM tx_notifications WHERE device_id=$1`,
+		`DELETE FROM device_locales WHERE token=$1`,
+		`DELETE FROM device_partners WHERE token=$1`,
+	}
+	for _, statement := range statements {
+		if _, err := dbTx.Exec(ctx, statement, token); err != nil {
+			return fmt.Errorf("pgxPool.Exec(%q): %w", statement, unavailable("postgres", err))
+		}
+	}
+	_, err = dbTx.Exec(ctx,
+		`UPDATE notification_outbox
+		 SET status=$2, last_error=$3
+		 WHERE token=$1 AND status=$4`,
+		token, OutboxDead, "unregistered token", OutboxPending)
+	if err != nil {
+		return fmt.Errorf("pgxPool.Exec('UPDATE notification_outbox'): %w", unavailable("postgres", err))
+	}
+	if err := dbTx.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit(): %w", unavailable("postgres", err))
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+// Package store holds the Redis and Postgres storage of the server.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/breez/server/tracing"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// tokenIndexPrefix is the prefix of the sets of the registrations of a
+	// notification token, of their key and member.
+	tokenIndexPrefix = "token-registrations:"
+	// tokenIndexTTL is the time the registrations of a token are kept after
+	// its last one. The registrations are refreshed by the apps, such as
+	// every sync notification.
+	tokenIndexTTL = 90 * 24 * 3600
+)
+
+// connectTimeout bounds the connection to a storage, so that the requests
+// fail fast when it is down.
+const connectTimeout = 5 * time.Second
+
+// Redis is the Redis storage.
+type Redis struct {
+	pool *redis.Pool
+}
+
+// NewRedis returns the storage of the database db of the Redis server at
+// address. Connections are made on demand.
+func NewRedis(address string, db int) *Redis {
+	return &Redis{pool: &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", address, redis.DialDatabase(db), redis.DialConnectTimeout(connectTimeout))
+			if err != nil {
+				return nil, err
+			}
+			return tracing.RedisConn(c), err
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}}
+}
+
+// Pool returns the connection pool, for the packages issuing their own
+// commands.
+func (r *Redis) Pool() *redis.Pool {
+	return r.pool
+}
+
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	redisConn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return unavailable("redis", err)
+	}
+	defer redisConn.Close()
+	_, err = redis.DoContext(redisConn, ctx, "PING")
+	return unavailable("redis", err)
+}
+
+// do runs a command on a connection of the pool.
+func (r *Redis) do(command string, args ...interface{}) (interface{}, error) {
+	redisConn := r.pool.Get()
+	defer redisConn.Close()
+	reply, err := redisConn.Do(command, args...)
+	return reply, unavailable("redis", err)
+}
+
+// doContext runs a command on a connection of the pool, until ctx is done.
+func (r *Redis) doContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	redisConn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, unavailable("redis", err)
+	}
+	defer redisConn.Close()
+	reply, err := redis.DoContext(redisConn, ctx, command, args...)
+	return reply, unavailable("redis", err)
+}
+
+func (r *Redis) UpdateKeyFields(key string, fields map[string]string) error {
+	var args []interface{}
+	args = append(args, key)
+	for k, value := range fields {
+		args = append(args, k)
+		args = append(args, value)
+	}
+	_, err := r.do("HMSET", args...)
+	return err
+}
+
+func (r *Redis) GetKeyFields(key string) (map[string]string, error) {
+	res, err := redis.StringMap(r.do("HGETALL", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return res, err
+}
+
+func (r *Redis) DeleteKey(key string) error {
+	_, err := r.do("DEL", key)
+	return err
+}
+
+func (r *Redis) KeyExists(key string) (bool, error) {
+	return redis.Bool(r.do("EXISTS", key))
+}
+
+func (r *Redis) SetKeyExpiration(key string, seconds int64) error {
+	_, err := redis.Bool(r.do("EXPIRE", key, seconds))
+	return err
+}
+
+func (r *Redis) GetKeyExpiration(key string) (int64, error) {
+	ttl, err := redis.Int64(r.do("TTL", key))
+	return ttl, err
+}
+
+func (r *Redis) AddToSet(set, member string) error {
+	_, err := r.do("SADD", set, member)
+	return err
+}
+
+// PopFromSet removes and returns at most count random members of set.
+func (r *Redis) PopFromSet(set string, count int) ([]string, error) {
+	return redis.Strings(r.do("SPOP", set, count))
+}
+
+func (r *Redis) IsSetMember(set, member string) (bool, error) {
+	return redis.Bool(r.do("SISMEMBER", set, member))
+}
+
+func (r *Redis) SetMembers(set string) ([]string, error) {
+	return redis.Strings(r.do("SMEMBERS", set))
+}
+
+// RemoveFromSet removes member from set, and returns the number of members
+// left.
+func (r *Redis) RemoveFromSet(set, member string) (int64, error) {
+	if _, err := r.do("SREM", set, member); err != nil {
+		return 0, err
+	}
+	return redis.Int64(r.do("SCARD", set))
+}
+
+func (r *Redis) PushWithScore(set string, key string, score int64) (bool, error) {
+	_, err := redis.Int64(r.do("ZREM", set, key))
+	if err != nil {
+		return false, err
+	}
+	count, err := redis.Int64(r.do("ZADD", set, score, key))
+	return count == 1, err
+}
+
+func (r *Redis) SetSize(set string) (int64, error) {
+	return redis.Int64(r.do("ZCARD", set))
+}
+
+// PopMinScore pops the member of set with the lowest score, waiting at most
+// timeout for one. It returns an empty key when the timeout expires.
+func (r *Redis) PopMinScore(set string, timeout time.Duration) (string, float64, error) {
+	multi, err := redis.MultiBulk(r.do("BZPOPMIN", set, timeout.Seconds()))
+	if err == redis.ErrNil {
+		return "", 0, nil
+	}
+	var key string
+	var score float64
+	if multi != nil && len(multi) == 3 {
+		key, err = redis.String(multi[1], nil)
+		if err != nil {
+			return "", 0, err
+		}
+		score, err = redis.Float64(multi[2], nil)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return key, score, err
+}
+
+// IndexToken records that member, holding the notification token, was
+// stored in key: it is a member of the set or sorted set key, or the field of
+// the hash key whose value is token. PurgeToken removes it.
+func (r *Redis) IndexToken(token, key, member string) error {
+	index := tokenIndexPrefix + token
+	redisConn := r.pool.Get()
+	defer redisConn.Close()
+	redisConn.Send("MULTI")
+	redisConn.Send("SADD", index, tokenIndexEntry(key, member))
+	redisConn.Send("EXPIRE", index, tokenIndexTTL)
+	_, err := redisConn.Do("EXEC")
+	return unavailable("redis", err)
+}
+
+// tokenIndexEntry returns the member of the index of a token recording
+// member of key: a key holds several members of a token, such as its
+// registrations to several notifications of a transaction.
+func tokenIndexEntry(key, member string) string {
+	entry, _ := json.Marshal([2]string{key, member})
+	return string(entry)
+}
+
+// PurgeToken removes token, a notification token which is no longer
+// registered, from the keys recorded by IndexToken. The emptied sets are
+// deleted.
+func (r *Redis) PurgeToken(ctx context.Context, token string) error {
+	index := tokenIndexPrefix + token
+	entries, err := redis.Strings(r.doContext(ctx, "SMEMBERS", index))
+	if err != nil {
+		return fmt.Errorf("SMEMBERS %v: %w", index, err)
+	}
+	for _, entry := range entries {
+		var e [2]string
+		if err := json.Unmarshal([]byte(entry), &e); err != nil {
+			return fmt.Errorf("json.Unmarshal(%v): %w", index, err)
+		}
+		key, member := e[0], e[1]
+		kind, err := redis.String(r.doContext(ctx, "TYPE", key))
+		if err != nil {
+			return fmt.Errorf("TYPE %v: %w", key, err)
+		}
+		switch kind {
+		case "set":
+			var card int64
+			if _, err = r.doContext(ctx, "SREM", key, member); err == nil {
+				card, err = redis.Int64(r.doContext(ctx, "SCARD", key))
+			}
+			if err == nil && card == 0 {
+				_, err = r.doContext(ctx, "DEL", key)
+			}
+		case "zset":
+			_, err = r.doContext(ctx, "ZREM", key, member)
+		case "hash":
+			// The field may hold the token of another device since.
+			var value string
+			value, err = redis.String(r.doContext(ctx, "HGET", key, member))
+			if err == nil && value == token {
+				_, err = r.doContext(ctx, "HDEL", key, member)
+			}
+			if err == redis.ErrNil {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("purging %v: %w", key, err)
+		}
+	}
+	_, err = r.doContext(ctx, "DEL", index)
+	return err
+}
+
+// IndexRegistrations indexes the notification tokens stored in the keys
+// matching pattern, as IndexToken does, for the registrations made before
+// the tokens were indexed. token returns the token held by a member of a set
+// or sorted set, or by a field of a hash and its value, or "" when it holds
+// none. It returns the number of registrations indexed.
+func (r *Redis) IndexRegistrations(ctx context.Context, pattern string, token func(member, value string) string) (int, error) {
+	var indexed int
+	cursor := "0"
+	for {
+		reply, err := redis.Values(r.doContext(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return indexed, fmt.Errorf("SCAN %v: %w", pattern, err)
+		}
+		var keys []string
+		if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+			return indexed, fmt.Errorf("SCAN %v: %w", pattern, err)
+		}
+		for _, key := range keys {
+			n, err := r.indexKey(ctx, key, token)
+			indexed += n
+			if err != nil {
+				return indexed, err
+			}
+		}
+		if cursor == "0" {
+			return indexed, nil
+		}
+	}
+}
+
+// indexKey indexes the notification tokens stored in key.
+func (r *Redis) indexKey(ctx context.Context, key string, token func(member, value string) string) (int, error) {
+	kind, err := redis.String(r.doContext(ctx, "TYPE", key))
+	if err != nil {
+		return 0, fmt.Errorf("TYPE %v: %w", key, err)
+	}
+	values := make(map[string]string)
+	switch kind {
+	case "set":
+		members, err := redis.Strings(r.doContext(ctx, "SMEMBERS", key))
+		if err != nil {
+			return 0, fmt.Errorf("SMEMBERS %v: %w", key, err)
+		}
+		for _, m := range members {
+			values[m] = m
+		}
+	case "zset":
+		members, err := redis.Strings(r.doContext(ctx, "ZRANGE", key, 0, -1))
+		if err != nil {
+			return 0, fmt.Errorf("ZRANGE %v: %w", key, err)
+		}
+		for _, m := range members {
+			values[m] = m
+		}
+	case "hash":
+		if values, err = redis.StringMap(r.doContext(ctx, "HGETALL", key)); err != nil {
+			return 0, fmt.Errorf("HGETALL %v: %w", key, err)
+		}
+	}
+	var indexed int
+	for member, value := range values {
+		t := token(member, value)
+		if t == "" {
+			continue
+		}
+		if err := r.IndexToken(t, key, member); err != nil {
+			return indexed, fmt.Errorf("indexing %v: %w", key, err)
+		}
+		indexed++
+	}
+	return indexed, nil
+}
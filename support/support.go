@@ -2,14 +2,13 @@ package support
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"log"
 
 	"github.com/breez/server/auth"
 	breezrpc "github.com/breez/server/breez"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/rpcerror"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 // Server implements support grpc functions
@@ -36,7 +35,7 @@ func (s *Server) ReportPaymentFailure(ctx context.Context, in *breezrpc.ReportPa
 		return nil, err
 	}
 	if err := s.emailNotifier(in, keys); err != nil {
-		return nil, errors.New("failed to report payment failure")
+		return nil, rpcerror.Internal(rpcerror.ReasonReportFailed, "failed to report payment failure", err)
 	}
 	return &breezrpc.ReportPaymentFailureReply{}, nil
 }
@@ -48,7 +47,7 @@ func (s *Server) BreezStatus(ctx context.Context, in *breezrpc.BreezStatusReques
 	status, err := s.getStatus()
 	if err != nil {
 		log.Printf("s.getStatus() error: %v", err)
-		return nil, fmt.Errorf("breezstatus error")
+		return nil, rpcerror.New(codes.Unavailable, rpcerror.ReasonStatusUnavailable, "breezstatus error").Wrap(err)
 	}
 	log.Printf("BreezStatus: %v", status)
 	return &breezrpc.BreezStatusReply{
@@ -60,12 +59,12 @@ func (s *Server) validateRequest(ctx context.Context) ([]string, error) {
 	keys := auth.GetHeaderKeys(ctx)
 	active, _, err := s.DBLSPFullList(keys)
 	if err != nil {
-		log.Printf("Error in DBLSPList(%#v): %v", keys, err)
-		return []string{}, status.Errorf(codes.PermissionDenied, "Not authorized")
+		log.Printf("Error in DBLSPList(%v): %v", logging.Secrets(keys), err)
+		return []string{}, rpcerror.NotAuthorized()
 	}
 	if len(active) == 0 {
-		log.Printf("No lsps found: %#v", keys)
-		return []string{}, status.Errorf(codes.PermissionDenied, "Not authorized")
+		log.Printf("No lsps found: %v", logging.Secrets(keys))
+		return []string{}, rpcerror.NotAuthorized()
 	}
 	return keys, nil
 }
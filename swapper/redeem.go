@@ -10,11 +10,11 @@ import (
 	"log"
 	"math"
 	"net/http"
-	"os"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/breez/server/metrics"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/wire"
@@ -37,6 +37,7 @@ type InProgressRedeem struct {
 }
 
 type Redeemer struct {
+	ssMacaroonHex         string
 	ssClient              lnrpc.LightningClient
 	ssRouterClient        routerrpc.RouterClient
 	subswapClient         submarineswaprpc.SubmarineSwapperClient
@@ -47,9 +48,11 @@ type Redeemer struct {
 	feesLastUpdated       time.Time
 	currentFees           *whatthefeeBody
 	mtx                   sync.RWMutex
+	wg                    sync.WaitGroup
 }
 
 func NewRedeemer(
+	ssMacaroonHex string,
 	ssClient lnrpc.LightningClient,
 	ssRouterClient routerrpc.RouterClient,
 	subswapClient submarineswaprpc.SubmarineSwapperClient,
@@ -59,6 +62,7 @@ func NewRedeemer(
 	setSubswapConfirmed func(paymentHash string) error,
 ) *Redeemer {
 	return &Redeemer{
+		ssMacaroonHex:         ssMacaroonHex,
 		ssClient:              ssClient,
 		ssRouterClient:        ssRouterClient,
 		subswapClient:         subswapClient,
@@ -69,10 +73,16 @@ func NewRedeemer(
 	}
 }
 
+// Start watches the redeem transactions and the fee rates until ctx is done.
 func (r *Redeemer) Start(ctx context.Context) {
 	log.Printf("REDEEM - before r.watchRedeemTxns()")
-	go r.watchRedeemTxns(ctx)
-	go r.watchFeeRate(ctx)
+	r.wg.Go(func() { r.watchRedeemTxns(ctx) })
+	r.wg.Go(func() { r.watchFeeRate(ctx) })
+}
+
+// Wait waits for the goroutines started by Start to return.
+func (r *Redeemer) Wait() {
+	r.wg.Wait()
 }
 
 func (r *Redeemer) watchFeeRate(ctx context.Context) {
@@ -202,7 +212,7 @@ func (r *Redeemer) getFeeRate(blocks int32) (float64, error) {
 func (r *Redeemer) checkRedeems() {
 	log.Printf("REDEEM - checkRedeems() begin")
 
-	subswapClientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("SUBSWAPPER_LND_MACAROON_HEX"))
+	subswapClientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", r.ssMacaroonHex)
 
 	info, err := r.ssClient.GetInfo(subswapClientCtx, &lnrpc.GetInfoRequest{})
 	if err != nil {
@@ -217,6 +227,7 @@ func (r *Redeemer) checkRedeems() {
 		return
 	}
 	log.Printf("REDEEM - checkRedeems() after r.getInProgressRedeems(%v) - count: %v", int32(info.BlockHeight), len(inProgressRedeems))
+	metrics.RedeemsInProgress.Set(float64(len(inProgressRedeems)))
 
 	syncHeight := int32(info.BlockHeight)
 	for _, inProgressRedeem := range inProgressRedeems {
@@ -327,6 +338,7 @@ func (r *Redeemer) checkRedeem(blockHeight int32, inProgressRedeem *InProgressRe
 
 	// Attempt to redeem again with the higher fees.
 	log.Printf("RedeemWithFees - preimage: %x, blocksLeft: %v fee: %v", preimage, blocksLeft, satPerVbyte)
+	metrics.RedeemFeeBumps.Inc()
 	_, err = r.RedeemWithFees(preimage, blocksLeft, int64(satPerVbyte))
 	return err
 }
@@ -368,16 +380,20 @@ func (r *Redeemer) doRedeem(preimage []byte, targetConf int32, satPerByte int64)
 		targetConf = 0
 	}
 
-	subswapClientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("SUBSWAPPER_LND_MACAROON_HEX"))
+	subswapClientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", r.ssMacaroonHex)
 	redeem, err := r.subswapClient.SubSwapServiceRedeem(subswapClientCtx, &submarineswaprpc.SubSwapServiceRedeemRequest{
 		Preimage:   preimage,
 		TargetConf: targetConf,
 		SatPerByte: satPerByte,
 	})
+	metrics.Redeems.WithLabelValues(metrics.Result(err)).Inc()
 	if err != nil {
 		log.Printf("doRedeem - couldn't redeem funds for preimage: %x, targetConf: %d, satPerByte %d, error: %v", preimage, targetConf, satPerByte, err)
 		return "", err
 	}
+	if satPerByte > 0 {
+		metrics.RedeemFeeRate.Observe(float64(satPerByte))
+	}
 
 	log.Printf("doRedeem - redeem tx broadcast: %s", redeem.Txid)
 	err = r.updateSubswapTxid(hex.EncodeToString(ph[:]), redeem.Txid)
@@ -5,14 +5,14 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 
-	"github.com/breez/server/bitcoind"
 	"github.com/breez/server/breez"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/rpcerror"
+	"github.com/breez/server/tracing"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/gomodule/redigo/redis"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/submarineswaprpc"
@@ -21,7 +21,6 @@ import (
 	"golang.org/x/text/message"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 const (
@@ -31,11 +30,24 @@ const (
 	chanReserve                   = 600
 )
 
+// Store is the storage of the swap addresses and of the notification tokens
+// of their owners.
+type Store interface {
+	UpdateKeyFields(key string, fields map[string]string) error
+	GetKeyFields(key string) (map[string]string, error)
+	AddToSet(set, member string) error
+	// IndexToken records the notification token added to a set, so that it
+	// is purged with the token.
+	IndexToken(token, key, member string) error
+}
+
 // Server implements lsp grpc functions
 type Server struct {
 	breez.UnimplementedSwapperServer
 	network               *chaincfg.Params
-	redisPool             *redis.Pool
+	lndMacaroonHex        string
+	ssMacaroonHex         string
+	store                 Store
 	client                lnrpc.LightningClient
 	ssClient              lnrpc.LightningClient
 	subswapClient         submarineswaprpc.SubmarineSwapperClient
@@ -45,12 +57,15 @@ type Server struct {
 	insertSubswapPayment  func(paymentHash, paymentRequest string, lockheight, confirmationheight int32, utxos []string) error
 	updateSubswapPreimage func(paymentHash, paymentPreimage string) error
 	hasFilteredAddress    func(addrs []string) (bool, error)
+	senderAddresses       func(txids []string) ([]string, error)
 	ReverseRoutingNodeID  []byte
 }
 
 func NewServer(
 	network *chaincfg.Params,
-	redisPool *redis.Pool,
+	lndMacaroonHex, ssMacaroonHex string,
+	reverseRoutingNodeID []byte,
+	store Store,
 	client, ssClient lnrpc.LightningClient,
 	subswapClient submarineswaprpc.SubmarineSwapperClient,
 	redeemer *Redeemer,
@@ -59,14 +74,13 @@ func NewServer(
 	insertSubswapPayment func(paymentHash, paymentRequest string, lockheight, confirmationheight int32, utxos []string) error,
 	updateSubswapPreimage func(paymentHash, paymentPreimage string) error,
 	hasFilteredAddress func(addrs []string) (bool, error),
+	senderAddresses func(txids []string) ([]string, error),
 ) *Server {
-	nodeID, err := hex.DecodeString(os.Getenv("REVERSE_SWAP_ROUTING_NODE"))
-	if err != nil {
-		log.Panicf("GetReverseRoutingNode error in hex.DecodeString(%v): %v", os.Getenv("REVERSE_SWAP_ROUTING_NODE"), err)
-	}
 	return &Server{
 		network:               network,
-		redisPool:             redisPool,
+		lndMacaroonHex:        lndMacaroonHex,
+		ssMacaroonHex:         ssMacaroonHex,
+		store:                 store,
 		client:                client,
 		ssClient:              ssClient,
 		subswapClient:         subswapClient,
@@ -76,7 +90,8 @@ func NewServer(
 		insertSubswapPayment:  insertSubswapPayment,
 		updateSubswapPreimage: updateSubswapPreimage,
 		hasFilteredAddress:    hasFilteredAddress,
-		ReverseRoutingNodeID:  nodeID,
+		senderAddresses:       senderAddresses,
+		ReverseRoutingNodeID:  reverseRoutingNodeID,
 	}
 }
 
@@ -88,11 +103,14 @@ func (s *Server) AddFundInit(ctx context.Context, in *breez.AddFundInitRequest)
 }
 
 func (s *Server) addFundInit(ctx context.Context, in *breez.AddFundInitRequest, max int64) (*breez.AddFundInitReply, error) {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("SUBSWAPPER_LND_MACAROON_HEX"))
+	// The calls are not cancelled with the request, but are part of its
+	// trace.
+	ctx = context.WithoutCancel(ctx)
+	clientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.ssMacaroonHex)
 
-	maxAllowedDeposit, err := s.getMaxAllowedDeposit(in.NodeID, max)
+	maxAllowedDeposit, err := s.getMaxAllowedDeposit(ctx, in.NodeID, max)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to calculate max allowed deposit amount")
+		return nil, rpcerror.Internal(rpcerror.ReasonDepositLimitFailed, "failed to calculate max allowed deposit amount", err)
 	}
 
 	if maxAllowedDeposit == 0 {
@@ -128,17 +146,19 @@ func (s *Server) addFundInit(ctx context.Context, in *breez.AddFundInitRequest,
 	}
 
 	address := subSwapServiceInitResponse.Address
-	redisConn := s.redisPool.Get()
-	defer redisConn.Close()
-	_, err = redisConn.Do("HMSET", "input-address:"+address, "hash", in.Hash)
+	err = s.store.UpdateKeyFields("input-address:"+address, map[string]string{"hash": string(in.Hash)})
 	if err != nil {
 		return nil, err
 	}
-	_, err = redisConn.Do("SADD", "input-address-notification:"+address, in.NotificationToken)
+	err = s.store.AddToSet("input-address-notification:"+address, in.NotificationToken)
 	if err != nil {
 		return nil, err
 	}
-	_, err = redisConn.Do("SADD", "fund-addresses", address)
+	err = s.store.IndexToken(in.NotificationToken, "input-address-notification:"+address, in.NotificationToken)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.AddToSet("fund-addresses", address)
 	if err != nil {
 		return nil, err
 	}
@@ -154,37 +174,38 @@ func (s *Server) addFundInit(ctx context.Context, in *breez.AddFundInitRequest,
 
 func (s *Server) AddFundStatus(ctx context.Context, in *breez.AddFundStatusRequest) (*breez.AddFundStatusReply, error) {
 	statuses := make(map[string]*breez.AddFundStatusReply_AddressStatus)
-	redisConn := s.redisPool.Get()
-	defer redisConn.Close()
 	for _, address := range in.Addresses {
-		m, err := redis.StringMap(redisConn.Do("HGETALL", "input-address:"+address))
+		m, err := s.store.GetKeyFields("input-address:" + address)
 		if err != nil {
 			log.Println("AddFundStatus error:", err)
 			continue
 		}
-		s := &breez.AddFundStatusReply_AddressStatus{}
+		status := &breez.AddFundStatusReply_AddressStatus{}
 		if tx, confirmed := m["tx:TxHash"]; confirmed {
-			s.BlockHash = m["tx:BlockHash"]
-			s.Confirmed = true
-			s.Tx = tx
+			status.BlockHash = m["tx:BlockHash"]
+			status.Confirmed = true
+			status.Tx = tx
 			if amt, err := strconv.ParseInt(m["tx:Amount"], 10, 64); err == nil {
-				s.Amount = amt
+				status.Amount = amt
 			}
 		} else {
 			if tx, unconfirmed := m["utx:TxHash"]; unconfirmed {
-				s.Confirmed = false
-				s.Tx = tx
+				status.Confirmed = false
+				status.Tx = tx
 				if amt, err := strconv.ParseInt(m["utx:Amount"], 10, 64); err == nil {
-					s.Amount = amt
+					status.Amount = amt
 				}
 			}
 		}
-		_, err = redisConn.Do("SADD", "input-address-notification:"+address, in.NotificationToken)
+		err = s.store.AddToSet("input-address-notification:"+address, in.NotificationToken)
+		if err == nil {
+			err = s.store.IndexToken(in.NotificationToken, "input-address-notification:"+address, in.NotificationToken)
+		}
 		if err != nil {
-			log.Println("AddFundStatus error adding token:", "input-address-notification:"+address, in.NotificationToken, err)
+			log.Println("AddFundStatus error adding token:", "input-address-notification:"+address, logging.Secret(in.NotificationToken), err)
 		}
-		if s.Tx != "" {
-			statuses[address] = s
+		if status.Tx != "" {
+			statuses[address] = status
 		}
 	}
 
@@ -199,11 +220,14 @@ func (s *Server) GetSwapPaymentLegacy(ctx context.Context, in *breez.GetSwapPaym
 }
 
 func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentRequest, max int64) (*breez.GetSwapPaymentReply, error) {
+	// The payment goes on when the request is cancelled, but its calls are
+	// part of the trace of the request.
+	ctx = context.WithoutCancel(ctx)
 	// Decode the the client's payment request
 	decodedPayReq, err := zpay32.Decode(in.PaymentRequest, s.network)
 	if err != nil {
 		log.Printf("GetSwapPayment - Error in zpay32.Decode: %v", err)
-		return nil, status.Errorf(codes.Internal, "payment request is not valid")
+		return nil, rpcerror.InvalidArgument("paymentRequest", "payment request is not valid")
 	}
 
 	decodedAmt := int64(0)
@@ -211,10 +235,10 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 		decodedAmt = int64(decodedPayReq.MilliSat.ToSatoshis())
 	}
 
-	maxAllowedDeposit, err := s.getMaxAllowedDeposit(hex.EncodeToString(decodedPayReq.Destination.SerializeCompressed()), max)
+	maxAllowedDeposit, err := s.getMaxAllowedDeposit(ctx, hex.EncodeToString(decodedPayReq.Destination.SerializeCompressed()), max)
 	if err != nil {
 		log.Printf("GetSwapPayment - getMaxAllowedDeposit error: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to calculate max allowed deposit amount")
+		return nil, rpcerror.Internal(rpcerror.ReasonDepositLimitFailed, "failed to calculate max allowed deposit amount", err)
 	}
 	if decodedAmt > maxAllowedDeposit {
 		log.Printf("GetSwapPayment - decodedAmt > maxAllowedDeposit: %v > %v", decodedAmt, maxAllowedDeposit)
@@ -226,14 +250,14 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 	}
 	log.Printf("GetSwapPayment - paying node %x amt = %v, maxAllowed = %v", decodedPayReq.Destination.SerializeCompressed(), decodedAmt, maxAllowedDeposit)
 
-	subswapClientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("SUBSWAPPER_LND_MACAROON_HEX"))
+	subswapClientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.ssMacaroonHex)
 	utxos, err := s.subswapClient.UnspentAmount(subswapClientCtx, &submarineswaprpc.UnspentAmountRequest{Hash: decodedPayReq.PaymentHash[:]})
 	if err != nil {
 		return nil, err
 	}
 
 	if len(utxos.Utxos) == 0 {
-		return nil, status.Errorf(codes.Internal, "there are no UTXOs related to payment request")
+		return nil, rpcerror.New(codes.FailedPrecondition, rpcerror.ReasonSwapNoUTXOs, "there are no UTXOs related to payment request")
 	}
 
 	fees, err := s.subswapClient.SubSwapServiceRedeemFees(subswapClientCtx, &submarineswaprpc.SubSwapServiceRedeemFeesRequest{
@@ -242,7 +266,7 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 	})
 	if err != nil {
 		log.Printf("GetSwapPayment - SubSwapServiceRedeemFees error: %v", err)
-		return nil, status.Errorf(codes.Internal, "couldn't determine the redeem transaction fees")
+		return nil, rpcerror.Internal(rpcerror.ReasonSwapFeesUnavailable, "couldn't determine the redeem transaction fees", err)
 	}
 	log.Printf("GetSwapPayment - SubSwapServiceRedeemFees: %v for amount in utxos: %v amount in payment request: %v", fees.Amount, utxos.Amount, decodedAmt)
 	if 2*utxos.Amount < 3*fees.Amount {
@@ -265,11 +289,11 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 	}
 
 	// Get the current blockheight
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
+	clientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.lndMacaroonHex)
 	chainInfo, err := s.client.GetInfo(clientCtx, &lnrpc.GetInfoRequest{})
 	if err != nil {
 		log.Printf("GetSwapPayment - GetInfo error: %v", err)
-		return nil, status.Errorf(codes.Internal, "couldn't determine the current blockheight")
+		return nil, rpcerror.Internal(rpcerror.ReasonBlockHeightUnavailable, "couldn't determine the current blockheight", err)
 	}
 
 	// Get the oldest height of the utxos and the utxo ids
@@ -295,13 +319,18 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 	for _, u := range utxos.Utxos {
 		txids = append(txids, u.Txid)
 	}
-	addrs, _ := bitcoind.GetSenderAddresses(txids)
-	hasFiltered, _ := s.hasFilteredAddress(addrs)
+	_, span := tracing.Start(ctx, "bitcoind GetSenderAddresses")
+	addrs, err := s.senderAddresses(txids)
+	tracing.End(span, err)
+	_, span = tracing.Start(ctx, "postgres HasFilteredAddress")
+	hasFiltered, err := s.hasFilteredAddress(addrs)
+	tracing.End(span, err)
 	if hasFiltered {
 		log.Printf("GetSwapPayment - hasSanc")
-		return nil, status.Errorf(codes.Internal, "fa internal error")
+		return nil, rpcerror.New(codes.FailedPrecondition, rpcerror.ReasonSwapRejected, "the swap cannot be completed")
 	}
 
+	_, span = tracing.Start(ctx, "postgres InsertSubswapPayment")
 	err = s.insertSubswapPayment(
 		hex.EncodeToString(decodedPayReq.PaymentHash[:]),
 		in.PaymentRequest,
@@ -309,6 +338,7 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 		minHeight,
 		utxoids,
 	)
+	tracing.End(span, err)
 	if err != nil {
 		log.Printf("GetSwapPayment - insertSubswapPayment paymentRequest: %v, error: %v", in.PaymentRequest, err)
 		return nil, fmt.Errorf("error in insertSubswapPayment: %w", err)
@@ -324,9 +354,11 @@ func (s *Server) getSwapPayment(ctx context.Context, in *breez.GetSwapPaymentReq
 	}
 	if sendResponse.PaymentError != "" {
 		if strings.Contains(sendResponse.PaymentError, "no_route") {
-			err = fmt.Errorf("error in payment response: %v", sendResponse.PaymentError+" - TemporaryChannelFailure")
+			err = rpcerror.New(codes.Unavailable, rpcerror.ReasonNoRoute,
+				fmt.Sprintf("error in payment response: %v", sendResponse.PaymentError+" - TemporaryChannelFailure"))
 		} else {
-			err = fmt.Errorf("error in payment response: %v", sendResponse.PaymentError)
+			err = rpcerror.New(codes.Aborted, rpcerror.ReasonPaymentFailed,
+				fmt.Sprintf("error in payment response: %v", sendResponse.PaymentError))
 		}
 
 		log.Printf("GetSwapPayment - SendPaymentSync paymentRequest: %v, Amount: %v, Preimage: %x error: %v", in.PaymentRequest, decodedAmt, sendResponse.PaymentPreimage, err)
@@ -356,9 +388,9 @@ func (s *Server) RedeemSwapPayment(ctx context.Context, in *breez.RedeemSwapPaym
 }
 
 // Calculate the max allowed deposit for a node
-func (s *Server) getMaxAllowedDeposit(nodeID string, max int64) (int64, error) {
+func (s *Server) getMaxAllowedDeposit(ctx context.Context, nodeID string, max int64) (int64, error) {
 	log.Println("getMaxAllowedDeposit node ID: ", nodeID)
-	nodeChannels, err := s.getNodeChannels(nodeID)
+	nodeChannels, err := s.getNodeChannels(ctx, nodeID)
 	if err != nil {
 		return 0, err
 	}
@@ -373,8 +405,8 @@ func (s *Server) getMaxAllowedDeposit(nodeID string, max int64) (int64, error) {
 	return maxAllowedToDeposit, nil
 }
 
-func (s *Server) getNodeChannels(nodeID string) ([]*lnrpc.Channel, error) {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
+func (s *Server) getNodeChannels(ctx context.Context, nodeID string) ([]*lnrpc.Channel, error) {
+	clientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.lndMacaroonHex)
 	listResponse, err := s.client.ListChannels(clientCtx, &lnrpc.ListChannelsRequest{})
 	if err != nil {
 		return nil, err
@@ -393,7 +425,7 @@ func (s *Server) GetReverseRoutingNode(ctx context.Context, in *breez.GetReverse
 }
 
 func (s *Server) RedeemSwapPayments() {
-	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", os.Getenv("LND_MACAROON_HEX"))
+	clientCtx := metadata.AppendToOutgoingContext(context.Background(), "macaroon", s.lndMacaroonHex)
 	listPaymentsResponse, err := s.client.ListPayments(clientCtx, &lnrpc.ListPaymentsRequest{
 		IncludeIncomplete: true,
 		MaxPayments:       100000,
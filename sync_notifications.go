@@ -1,63 +1,104 @@
 package main
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
+
+	"github.com/breez/server/backoff"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/metrics"
 )
 
 const (
 	syncSetName  = "sync_notifications_set"
 	syncInterval = time.Duration(time.Minute * 30)
 	syncJobName  = "chainSync"
+
+	// syncPopTimeout bounds the wait for the next registration, so that
+	// deliverSyncNotifications notices the shutdown.
+	syncPopTimeout = 5 * time.Second
 )
 
 // registerSyncNotification registeres a device for a periodic sync notification.
 // the client will get a data message every "syncInterval" and will be responsible
 // to execute a sync.
-func registerSyncNotification(deviceToken string) error {
-	_, err := pushWithScore(
+func (s *services) registerSyncNotification(deviceToken string) error {
+	_, err := s.redis.PushWithScore(
 		syncSetName, deviceToken, time.Now().Add(syncInterval).Unix())
-	return err
+	s.updateSyncQueueDepth()
+	if err != nil {
+		return err
+	}
+	return s.redis.IndexToken(deviceToken, syncSetName, deviceToken)
+}
+
+// updateSyncQueueDepth exports the number of registered devices.
+func (s *services) updateSyncQueueDepth() {
+	depth, err := s.redis.SetSize(syncSetName)
+	if err != nil {
+		log.Println("failed to get the sync notification queue depth ", err)
+		return
+	}
+	metrics.SyncQueueDepth.Set(float64(depth))
 }
 
 // deliverSyncNotifications executes the main loop of runnig over existing registration
-// and sending sync messags on time.
-func deliverSyncNotifications() {
-	for {
-		deviceToken, score, err := popMinScore(syncSetName)
+// and sending sync messags on time, until ctx is done.
+func (s *services) deliverSyncNotifications(ctx context.Context) {
+	var sending sync.WaitGroup
+	defer sending.Wait()
+	var retry backoff.Backoff
+	for ctx.Err() == nil {
+		deviceToken, score, err := s.redis.PopMinScore(syncSetName, syncPopTimeout)
 		if err != nil {
 			log.Println("failed to pop next sync notification ", err)
+			retry.Wait(ctx)
+			continue
+		}
+		retry.Reset()
+		if deviceToken == "" {
 			continue
 		}
+		s.updateSyncQueueDepth()
 		fireTime := time.Unix(int64(score), 0)
-		<-time.After(fireTime.Sub(time.Now()))
-		go func() {
-			unreg, err := sendClientSyncMessage(deviceToken)
+		select {
+		case <-time.After(fireTime.Sub(time.Now())):
+		case <-ctx.Done():
+			// Put the token back so that it is not lost on shutdown.
+			if _, err := s.redis.PushWithScore(syncSetName, deviceToken, int64(score)); err != nil {
+				log.Println("failed to restore sync notification for token:", logging.Secret(deviceToken))
+			}
+			return
+		}
+		sending.Go(func() {
+			unreg, err := s.sendClientSyncMessage(deviceToken)
 			if err != nil {
 				log.Println("error in sending sync message:", err)
 			}
 
 			//if this token is still valid, register for the next sync time.
 			if !unreg {
-				if err = registerSyncNotification(deviceToken); err != nil {
-					log.Println("failed to re-regiseter sync notification for token: ", deviceToken)
+				if err = s.registerSyncNotification(deviceToken); err != nil {
+					log.Println("failed to re-regiseter sync notification for token:", logging.Secret(deviceToken))
 				}
 			}
-		}()
+		})
 	}
 }
 
 // sendClientSyncMessage is the function that actualy sends the sync message
 // to the client. It also returns a value indicates if this token needs to be
 // unregistered.
-func sendClientSyncMessage(sendToToken string) (bool, error) {
+func (s *services) sendClientSyncMessage(sendToToken string) (bool, error) {
 	data := map[string]string{
 		"_job": syncJobName,
 	}
 
-	err := notifyDataMessage(data, sendToToken)
+	err := s.notifier.NotifyDataMessage(data, sendToToken)
 	if err != nil {
-		if isUnregisteredError(err) {
+		if s.notifier.IsUnregisteredError(err) {
 			return true, nil
 		}
 		return false, err
@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// otlpExporter sends the spans to an OpenTelemetry collector with OTLP over
+// grpc.
+type otlpExporter struct {
+	conn   *grpc.ClientConn
+	client coltracepb.TraceServiceClient
+}
+
+// newOTLPExporter connects to the collector at endpoint: http://host:port
+// without TLS, or https://host:port with the system certificates.
+func newOTLPExporter(endpoint *url.URL) (*otlpExporter, error) {
+	if endpoint == nil {
+		return nil, fmt.Errorf("no OTLP endpoint")
+	}
+	creds := insecure.NewCredentials()
+	switch endpoint.Scheme {
+	case "http":
+	case "https":
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("x509.SystemCertPool: %w", err)
+		}
+		creds = credentials.NewClientTLSFromCert(pool, "")
+	default:
+		return nil, fmt.Errorf("invalid OTLP endpoint scheme %q", endpoint.Scheme)
+	}
+	conn, err := grpc.NewClient(endpoint.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("grpc.NewClient(%v): %w", endpoint.Host, err)
+	}
+	return &otlpExporter{conn: conn, client: coltracepb.NewTraceServiceClient(conn)}, nil
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	_, err := e.client.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: resourceSpans(spans)})
+	return err
+}
+
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	return e.conn.Close()
+}
+
+// resourceSpans groups the spans by resource and instrumentation scope.
+func resourceSpans(spans []sdktrace.ReadOnlySpan) []*tracepb.ResourceSpans {
+	var rss []*tracepb.ResourceSpans
+	byResource := make(map[*resource.Resource]*tracepb.ResourceSpans)
+	byScope := make(map[*resource.Resource]map[instrumentation.Scope]*tracepb.ScopeSpans)
+	for _, s := range spans {
+		r := s.Resource()
+		rs, ok := byResource[r]
+		if !ok {
+			rs = &tracepb.ResourceSpans{
+				Resource:  &resourcepb.Resource{Attributes: keyValues(r.Attributes())},
+				SchemaUrl: r.SchemaURL(),
+			}
+			byResource[r] = rs
+			byScope[r] = make(map[instrumentation.Scope]*tracepb.ScopeSpans)
+			rss = append(rss, rs)
+		}
+		scope := s.InstrumentationScope()
+		ss, ok := byScope[r][scope]
+		if !ok {
+			ss = &tracepb.ScopeSpans{
+				Scope:     &commonpb.InstrumentationScope{Name: scope.Name, Version: scope.Version},
+				SchemaUrl: scope.SchemaURL,
+			}
+			byScope[r][scope] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, span(s))
+	}
+	return rss
+}
+
+func span(s sdktrace.ReadOnlySpan) *tracepb.Span {
+	sc := s.SpanContext()
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	p := &tracepb.Span{
+		TraceId:                traceID[:],
+		SpanId:                 spanID[:],
+		TraceState:             sc.TraceState().String(),
+		Flags:                  uint32(sc.TraceFlags()),
+		Name:                   s.Name(),
+		Kind:                   tracepb.Span_SpanKind(s.SpanKind()),
+		StartTimeUnixNano:      uint64(s.StartTime().UnixNano()),
+		EndTimeUnixNano:        uint64(s.EndTime().UnixNano()),
+		Attributes:             keyValues(s.Attributes()),
+		DroppedAttributesCount: uint32(s.DroppedAttributes()),
+		DroppedEventsCount:     uint32(s.DroppedEvents()),
+		DroppedLinksCount:      uint32(s.DroppedLinks()),
+		Status:                 status(s.Status()),
+	}
+	if parent := s.Parent(); parent.IsValid() {
+		parentID := parent.SpanID()
+		p.ParentSpanId = parentID[:]
+	}
+	for _, e := range s.Events() {
+		p.Events = append(p.Events, &tracepb.Span_Event{
+			TimeUnixNano:           uint64(e.Time.UnixNano()),
+			Name:                   e.Name,
+			Attributes:             keyValues(e.Attributes),
+			DroppedAttributesCount: uint32(e.DroppedAttributeCount),
+		})
+	}
+	for _, l := range s.Links() {
+		traceID, spanID := l.SpanContext.TraceID(), l.SpanContext.SpanID()
+		p.Links = append(p.Links, &tracepb.Span_Link{
+			TraceId:                traceID[:],
+			SpanId:                 spanID[:],
+			TraceState:             l.SpanContext.TraceState().String(),
+			Attributes:             keyValues(l.Attributes),
+			DroppedAttributesCount: uint32(l.DroppedAttributeCount),
+			Flags:                  uint32(l.SpanContext.TraceFlags()),
+		})
+	}
+	return p
+}
+
+func status(s sdktrace.Status) *tracepb.Status {
+	code := tracepb.Status_STATUS_CODE_UNSET
+	switch s.Code {
+	case codes.Ok:
+		code = tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		code = tracepb.Status_STATUS_CODE_ERROR
+	}
+	return &tracepb.Status{Code: code, Message: s.Description}
+}
+
+func keyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, &commonpb.KeyValue{Key: string(a.Key), Value: anyValue(a.Value)})
+	}
+	return kvs
+}
+
+func anyValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.STRING:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.AsString()}}
+	case attribute.BOOLSLICE:
+		return arrayValue(v.AsBoolSlice(), func(b bool) *commonpb.AnyValue {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: b}}
+		})
+	case attribute.INT64SLICE:
+		return arrayValue(v.AsInt64Slice(), func(i int64) *commonpb.AnyValue {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		})
+	case attribute.FLOAT64SLICE:
+		return arrayValue(v.AsFloat64Slice(), func(f float64) *commonpb.AnyValue {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+		})
+	case attribute.STRINGSLICE:
+		return arrayValue(v.AsStringSlice(), func(s string) *commonpb.AnyValue {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+		})
+	}
+	return &commonpb.AnyValue{}
+}
+
+func arrayValue[T any](values []T, value func(T) *commonpb.AnyValue) *commonpb.AnyValue {
+	array := &commonpb.ArrayValue{Values: make([]*commonpb.AnyValue, len(values))}
+	for i, v := range values {
+		array.Values[i] = value(v)
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: array}}
+}
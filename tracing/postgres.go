@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PostgresTracer traces the queries run in a traced request. The queries of
+// the background workers, run without a span in their context, are not
+// traced. The arguments of the queries are not recorded.
+type PostgresTracer struct{}
+
+func (PostgresTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	operation, _, _ := strings.Cut(strings.TrimSpace(data.SQL), " ")
+	ctx, _ = Start(ctx, "postgres "+strings.ToUpper(operation),
+		attribute.String("db.system.name", "postgresql"),
+		attribute.String("db.query.text", data.SQL))
+	return ctx
+}
+
+func (PostgresTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	End(span, data.Err)
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisConn traces the commands of c run with a context in a traced request,
+// with DoContext. The commands run without a context are not traced, not to
+// make a trace of every command of the background workers.
+func RedisConn(c redis.Conn) redis.Conn {
+	return &redisConn{Conn: c}
+}
+
+type redisConn struct {
+	redis.Conn
+}
+
+func (c *redisConn) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return redis.DoContext(c.Conn, ctx, command, args...)
+	}
+	ctx, span := Start(ctx, "redis "+command, attribute.String("db.system.name", "redis"))
+	reply, err := redis.DoContext(c.Conn, ctx, command, args...)
+	End(span, err)
+	return reply, err
+}
+
+func (c *redisConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redis.ReceiveContext(c.Conn, ctx)
+}
+
+func (c *redisConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, command, args...)
+}
+
+func (c *redisConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
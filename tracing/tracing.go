@@ -0,0 +1,159 @@
+// Package tracing sets up the OpenTelemetry tracing of the server: the spans
+// of the incoming grpc and http requests and of the outbound calls to lnd,
+// lspd, swapd, Redis, Postgres, FCM and Esplora, exported with OTLP to a
+// collector or written to stdout.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	serviceName = "breez-server"
+	tracerName  = "github.com/breez/server/tracing"
+)
+
+// Setup installs the tracer provider exporting the spans with exporter:
+// "none", "otlp" to the collector at endpoint, or "stdout". sampleRatio is the
+// fraction of the traces started by the server which are recorded; the
+// traces started by the callers follow their sampling decision. The returned
+// function flushes the spans and stops the exporter.
+func Setup(ctx context.Context, exporter string, endpoint *url.URL, sampleRatio float64) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var e sdktrace.SpanExporter
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var err error
+		e, err = newOTLPExporter(endpoint)
+		if err != nil {
+			return nil, err
+		}
+	case "stdout":
+		e = newStdoutExporter(os.Stdout)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	r, err := resource.New(ctx, resource.WithFromEnv(), resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)))
+	if err != nil {
+		e.Shutdown(ctx)
+		return nil, fmt.Errorf("resource.New: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(e),
+		sdktrace.WithResource(r),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// ignored are the grpc methods and http paths polled by the infrastructure,
+// which are not traced.
+var ignored = map[string]bool{
+	"/grpc.health.v1.Health/Check": true,
+	"/grpc.health.v1.Health/Watch": true,
+	"/healthz":                     true,
+	"/readyz":                      true,
+	"/metrics":                     true,
+}
+
+// ServerOption traces the calls of a grpc server.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(func(info *stats.RPCTagInfo) bool {
+		return !ignored[info.FullMethodName]
+	})))
+}
+
+// DialOptions are the options of the grpc clients tracing their calls.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithStatsHandler(clientHandler{otelgrpc.NewClientHandler()})}
+}
+
+type untracedKey struct{}
+
+// Untraced returns a copy of ctx whose grpc calls are not traced.
+func Untraced(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untracedKey{}, true)
+}
+
+func untraced(ctx context.Context) bool {
+	u, _ := ctx.Value(untracedKey{}).(bool)
+	return u
+}
+
+// clientHandler skips the calls made with an Untraced context.
+type clientHandler struct {
+	stats.Handler
+}
+
+func (h clientHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if untraced(ctx) {
+		return ctx
+	}
+	return h.Handler.TagRPC(ctx, info)
+}
+
+func (h clientHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	if untraced(ctx) {
+		return
+	}
+	h.Handler.HandleRPC(ctx, rs)
+}
+
+// Middleware traces the http requests. It has to wrap the http.ServeMux
+// directly, for the spans to be named after the patterns of the requests.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !ignored[r.URL.Path]
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			if r.Pattern != "" {
+				return r.Pattern
+			}
+			return operation + " " + r.Method
+		}),
+	)
+}
+
+// Transport traces the requests sent through base, as children of the span
+// of their context.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// Start starts the span of an outbound call without its own
+// instrumentation. It is a root span when ctx has no span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording err when the call failed.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
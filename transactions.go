@@ -11,11 +11,13 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/breez/server/i18n"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/notify"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/gomodule/redigo/redis"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"golang.org/x/sync/singleflight"
 )
@@ -29,19 +31,23 @@ const (
 var (
 	txGroup             singleflight.Group
 	txNotificationGroup singleflight.Group
-	notificationTypes   = map[string]map[string]string{
-		receivePaymentType: map[string]string{
-			"title": "Receive Payment",
-			"body":  "You are now ready to receive payments using Breez. Open to continue with a previously shared payment link.",
+	notificationTypes   = map[string]map[string]i18n.Message{
+		receivePaymentType: map[string]i18n.Message{
+			"title": i18n.ReceivePaymentTitle,
+			"body":  i18n.ReceivePaymentBody,
 		},
-		channelOpenedType: map[string]string{
-			"title": "Breez",
-			"body":  "You can now use Breez to send and receive Bitcoin payments!",
+		channelOpenedType: map[string]i18n.Message{
+			"title": i18n.ChannelOpenedTitle,
+			"body":  i18n.ChannelOpenedBody,
 		},
 	}
+	alertTypes = map[string]string{
+		receivePaymentType: notify.TypeReceivePayment,
+		channelOpenedType:  notify.TypeChannelOpened,
+	}
 )
 
-func handlePastTransactions(ctx context.Context, c lnrpc.LightningClient) error {
+func (s *services) handlePastTransactions(ctx context.Context, c lnrpc.LightningClient) error {
 	transactionDetails, err := c.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{})
 	if err != nil {
 		log.Println("handlePastTransactions error:", err)
@@ -55,7 +61,7 @@ func handlePastTransactions(ctx context.Context, c lnrpc.LightningClient) error
 			key = key + "-confirmed"
 		}
 		_, err, _ = txGroup.Do(key, func() (interface{}, error) {
-			err := handleTransaction(t)
+			err := s.handleTransaction(t)
 			return nil, err
 		})
 		if err != nil {
@@ -65,18 +71,24 @@ func handlePastTransactions(ctx context.Context, c lnrpc.LightningClient) error
 	return nil
 }
 
-func subscribeTransactions(ctx context.Context, c lnrpc.LightningClient) {
+func (s *services) subscribeTransactions(ctx context.Context, c lnrpc.LightningClient) {
 	for {
 		log.Println("new subscribe")
-		err := subscribeTransactionsOnce(ctx, c)
+		err := s.subscribeTransactionsOnce(ctx, c)
 		if err != nil {
 			log.Println("subscribeTransactions:", err)
 		}
-		time.Sleep(5 * time.Second)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func destAddresses(tx *lnrpc.Transaction) ([]string, error) {
+// destAddresses returns the output addresses of tx on the network
+// chainParams, decoding the raw transaction when lnd did not fill them.
+func destAddresses(tx *lnrpc.Transaction, chainParams *chaincfg.Params) ([]string, error) {
 	if len(tx.DestAddresses) > 0 {
 		return tx.DestAddresses, nil
 	}
@@ -94,7 +106,6 @@ func destAddresses(tx *lnrpc.Transaction) ([]string, error) {
 		return nil, err
 	}
 	var destAddresses []btcutil.Address
-	chainParams := &chaincfg.MainNetParams
 	for _, txOut := range wireTx.TxOut {
 		_, outAddresses, _, err :=
 			txscript.ExtractPkScriptAddrs(txOut.PkScript, chainParams)
@@ -112,7 +123,7 @@ func destAddresses(tx *lnrpc.Transaction) ([]string, error) {
 	return dest, nil
 }
 
-func subscribeTransactionsOnce(ctx context.Context, c lnrpc.LightningClient) error {
+func (s *services) subscribeTransactionsOnce(ctx context.Context, c lnrpc.LightningClient) error {
 	transactionStream, err := c.SubscribeTransactions(ctx, &lnrpc.GetTransactionsRequest{})
 	if err != nil {
 		log.Println("SubscribeTransactions:", err)
@@ -130,7 +141,7 @@ func subscribeTransactionsOnce(ctx context.Context, c lnrpc.LightningClient) err
 			return err
 		}
 		//log.Printf("t:%#v", t)
-		t.DestAddresses, err = destAddresses(t)
+		t.DestAddresses, err = destAddresses(t, s.cfg.Network)
 		if err != nil {
 			log.Printf("transactionStream - Error in destAddresses: %v", err)
 		}
@@ -141,7 +152,7 @@ func subscribeTransactionsOnce(ctx context.Context, c lnrpc.LightningClient) err
 			key = key + "-confirmed"
 		}
 		_, err, _ = txGroup.Do(key, func() (interface{}, error) {
-			err := handleTransaction(t)
+			err := s.handleTransaction(t)
 			return nil, err
 		})
 		if err != nil {
@@ -151,63 +162,68 @@ func subscribeTransactionsOnce(ctx context.Context, c lnrpc.LightningClient) err
 	return nil
 }
 
-func handleTransaction(tx *lnrpc.Transaction) error {
-	if err := handleTransactionNotifications(tx); err != nil {
+func (s *services) handleTransaction(tx *lnrpc.Transaction) error {
+	if err := s.handleTransactionNotifications(tx); err != nil {
 		log.Println("handleTransactionNotifications error:", err)
 		return err
 	}
-	return handleTransactionAddreses(tx)
+	return s.handleTransactionAddreses(tx)
 }
 
-func registerTransacionConfirmation(txID, token, notifyType string) error {
-	redisConn := redisPool.Get()
-	defer redisConn.Close()
+func (s *services) registerTransacionConfirmation(txID, token, notifyType string) error {
 	registrationKey := fmt.Sprintf("tx-notify-%v", txID)
 	registrationData := map[string]string{"token": token, "type": notifyType}
 	marshalled, err := json.Marshal(registrationData)
 	if err != nil {
 		return err
 	}
-	_, err = redisConn.Do("SADD", registrationKey, string(marshalled))
+	err = s.redis.AddToSet(registrationKey, string(marshalled))
 	if err != nil {
 		return err
 	}
-	err = setKeyExpiration(registrationKey, transactionNotificationExpiry)
+	if err = s.redis.IndexToken(token, registrationKey, string(marshalled)); err != nil {
+		return err
+	}
+	err = s.redis.SetKeyExpiration(registrationKey, transactionNotificationExpiry)
 	return err
 }
 
-func handleTransactionNotifications(tx *lnrpc.Transaction) error {
+func (s *services) handleTransactionNotifications(tx *lnrpc.Transaction) error {
 	if tx.NumConfirmations == 0 {
 		return nil
 	}
 
 	registrationKey := fmt.Sprintf("tx-notify-%v", tx.TxHash)
-	redisConn := redisPool.Get()
-	defer redisConn.Close()
 	for {
-		registrations, err := redis.Strings(redisConn.Do("SPOP", registrationKey, 10))
+		registrations, err := s.redis.PopFromSet(registrationKey, 10)
 		if err != nil {
 			return err
 		}
 
-		for _, r := range registrations {
+		for i, r := range registrations {
 			var regData map[string]string
 			if err = json.Unmarshal([]byte(r), &regData); err != nil {
-				log.Printf("Failed to decode json registration %v", r)
+				log.Printf("Failed to decode json registration: %v", err)
 				continue
 			}
 			notificationType := regData["type"]
 			notificationToken := regData["token"]
-			go func() {
-				err := notifyAlertMessage(
-					notificationTypes[notificationType]["title"],
-					notificationTypes[notificationType]["body"],
-					map[string]string{}, notificationToken)
-
-				if err != nil {
-					log.Printf("Error sending transaction confirmation %v", err)
+			err = s.outbox.EnqueueAlert("tx_confirmation", notify.Alert{
+				Type:  alertTypes[notificationType],
+				Title: s.localizer.Render(notificationToken, notificationTypes[notificationType]["title"]),
+				Body:  s.localizer.Render(notificationToken, notificationTypes[notificationType]["body"]),
+				Data:  map[string]string{},
+			}, notificationToken)
+			if err != nil {
+				// Put back the registrations not enqueued, for the next
+				// handling of the transaction.
+				for _, r := range registrations[i:] {
+					if err := s.redis.AddToSet(registrationKey, r); err != nil {
+						log.Printf("Failed to restore the registration of %v: %v", tx.TxHash, err)
+					}
 				}
-			}()
+				return fmt.Errorf("outbox.EnqueueAlert: %w", err)
+			}
 		}
 
 		if len(registrations) < 10 {
@@ -217,53 +233,51 @@ func handleTransactionNotifications(tx *lnrpc.Transaction) error {
 	return nil
 }
 
-func handleTransactionAddreses(tx *lnrpc.Transaction) error {
+func (s *services) handleTransactionAddreses(tx *lnrpc.Transaction) error {
 	//log.Printf("t:%#v", tx)
-	redisConn := redisPool.Get()
-	defer redisConn.Close()
 	for i, a := range tx.DestAddresses {
-		n, err := redis.Int(redisConn.Do("SISMEMBER", "fund-addresses", a))
+		isMember, err := s.redis.IsSetMember("fund-addresses", a)
 		if err != nil {
 			log.Println("handleTransaction error:", err)
 			return err
 		}
-		if n > 0 {
+		if isMember {
 			if tx.NumConfirmations > 0 {
-				err = handleTransactionAddress(tx, i)
+				err = s.handleTransactionAddress(tx, i)
 				if err != nil {
 					return err
 				}
-				go notifyClientTransaction(tx, i, "Action Required", "Breez", "Received funds are now confirmed. Please open the app to complete your transaction.", true)
-				break // There is only one address concerning us per transaction
+				// There is only one address concerning us per transaction
+				return s.notifyClientTransaction(tx, i, "Action Required", true, notify.TypeFundsConfirmed, i18n.FundsConfirmedBody)
 			} else {
-				redisConn := redisPool.Get()
-				defer redisConn.Close()
-				_, err := redisConn.Do("HMSET", "input-address:"+tx.DestAddresses[i],
-					"utx:TxHash", tx.TxHash,
-					"utx:Amount", tx.Amount,
-				)
+				err := s.redis.UpdateKeyFields("input-address:"+tx.DestAddresses[i], map[string]string{
+					"utx:TxHash": tx.TxHash,
+					"utx:Amount": strconv.FormatInt(tx.Amount, 10),
+				})
 				if err != nil {
 					log.Println("handleTransactionAddreses error:", err)
 					return err
 				}
-				amt := strconv.FormatInt(tx.Amount, 10)
-				go notifyClientTransaction(tx, i, "Unconfirmed transaction", "Breez", "Breez is waiting for "+amt+" sat to be confirmed.", false)
-				break
+				return s.notifyClientTransaction(tx, i, "Unconfirmed transaction", false, notify.TypeFundsUnconfirmed, i18n.FundsUnconfirmedBody,
+					strconv.FormatInt(tx.Amount, 10))
 			}
 		}
 	}
 	return nil
 }
 
-func notifyClientTransaction(tx *lnrpc.Transaction, index int, msg, title, body string, delete bool) {
+// notifyClientTransaction enqueues the notifications of the transaction to
+// the address of index to the devices registered for it, alerts of
+// alertType with the body rendered in their language. msg is the tag of the
+// notification in its data, for the app. delete forgets the devices once
+// their notification is enqueued.
+func (s *services) notifyClientTransaction(tx *lnrpc.Transaction, index int, msg string, delete bool, alertType string, body i18n.Message, args ...any) error {
 	key := tx.TxHash + "-notification"
-	_, _, _ = txNotificationGroup.Do(key, func() (interface{}, error) {
-		redisConn := redisPool.Get()
-		defer redisConn.Close()
-		tokens, err := redis.Strings(redisConn.Do("SMEMBERS", "input-address-notification:"+tx.DestAddresses[index]))
+	_, err, _ := txNotificationGroup.Do(key, func() (interface{}, error) {
+		tokens, err := s.redis.SetMembers("input-address-notification:" + tx.DestAddresses[index])
 		if err != nil {
 			log.Println("notifyUnconfirmed error:", err)
-			return nil, nil
+			return nil, err
 		}
 		data := map[string]string{
 			"msg":     msg,
@@ -273,21 +287,22 @@ func notifyClientTransaction(tx *lnrpc.Transaction, index int, msg, title, body
 		}
 
 		for _, tok := range tokens {
-			err = notifyAlertMessage(title, body, data, tok)
-			log.Println("Error in send:", err)
-			unregistered := err != nil && isUnregisteredError(err)
-			if unregistered || delete {
-				_, err = redisConn.Do("SREM", "input-address-notification:"+tx.DestAddresses[index], tok)
-				if err != nil {
-					log.Printf("Error in notifyClientTransaction (SREM); set:%v member:%v error:%v", "input-address-notification:"+tx.DestAddresses[index], tok, err)
-				}
-
-				card, err := redis.Int(redisConn.Do("SCARD", "input-address-notification:"+tx.DestAddresses[index]))
+			err = s.outbox.EnqueueAlert("fund_address", notify.Alert{
+				Type:  alertType,
+				Title: s.localizer.Render(tok, i18n.FundsTitle),
+				Body:  s.localizer.Render(tok, body, args...),
+				Data:  data,
+			}, tok)
+			if err != nil {
+				return nil, fmt.Errorf("outbox.EnqueueAlert: %w", err)
+			}
+			if delete {
+				card, err := s.redis.RemoveFromSet("input-address-notification:"+tx.DestAddresses[index], tok)
 				if err != nil {
-					log.Printf("Error in notifyClientTransaction (SCARD); set:%v error:%v", "input-address-notification:"+tx.DestAddresses[index], err)
+					log.Printf("Error in notifyClientTransaction (SREM); set:%v member:%v error:%v", "input-address-notification:"+tx.DestAddresses[index], logging.Secret(tok), err)
 				} else {
 					if card == 0 {
-						_, err = redisConn.Do("DEL", "input-address-notification:"+tx.DestAddresses[index])
+						err = s.redis.DeleteKey("input-address-notification:" + tx.DestAddresses[index])
 						if err != nil {
 							log.Printf("Error in notifyClientTransaction (DEL); set:%v error:%v", "input-address-notification:"+tx.DestAddresses[index], err)
 						}
@@ -297,16 +312,15 @@ func notifyClientTransaction(tx *lnrpc.Transaction, index int, msg, title, body
 		}
 		return nil, nil
 	})
+	return err
 }
 
-func handleTransactionAddress(tx *lnrpc.Transaction, index int) error {
-	redisConn := redisPool.Get()
-	defer redisConn.Close()
-	_, err := redisConn.Do("HMSET", "input-address:"+tx.DestAddresses[index],
-		"tx:TxHash", tx.TxHash,
-		"tx:Amount", tx.Amount,
-		"tx:BlockHash", tx.BlockHash,
-	)
+func (s *services) handleTransactionAddress(tx *lnrpc.Transaction, index int) error {
+	err := s.redis.UpdateKeyFields("input-address:"+tx.DestAddresses[index], map[string]string{
+		"tx:TxHash":    tx.TxHash,
+		"tx:Amount":    strconv.FormatInt(tx.Amount, 10),
+		"tx:BlockHash": tx.BlockHash,
+	})
 	if err != nil {
 		log.Println("handleTransactionAddress error:", err)
 		return err
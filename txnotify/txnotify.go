@@ -0,0 +1,238 @@
+// Package txnotify sends a push notification to a device when a transaction
+// it registered is confirmed. The notification is enqueued in the outbox of
+// the store with the confirmation.
+package txnotify
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/breez/server/breez"
+	"github.com/breez/server/logging"
+	"github.com/breez/server/notify"
+	"github.com/breez/server/rpcerror"
+	"github.com/breez/server/store"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/google/uuid"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/chainrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// LightningClient is the part of lnrpc.LightningClient giving the height of
+// the chain.
+type LightningClient interface {
+	GetInfo(ctx context.Context, in *lnrpc.GetInfoRequest, opts ...grpc.CallOption) (*lnrpc.GetInfoResponse, error)
+}
+
+// Store is the storage of the registrations.
+type Store interface {
+	InsertTxNotification(in *breez.PushTxNotificationRequest) (*uuid.UUID, error)
+	TxNotified(u uuid.UUID, txHash chainhash.Hash, tx []byte, blockHeigh uint32, blockHash []byte, txIndex uint32, notification *store.OutboxNotification) error
+	BoltzReverseSwapToNotify(currentHeight uint32) ([]store.TxNotification, error)
+}
+
+// LockupTx returns the raw hex encoded lockup transaction of a boltz reverse
+// swap.
+type LockupTx func(boltzID string) (string, error)
+
+// Server implements breez.PushTxNotifierServer.
+type Server struct {
+	breez.UnimplementedPushTxNotifierServer
+	ctx           context.Context
+	wg            sync.WaitGroup
+	macaroonHex   string
+	client        LightningClient
+	chainNotifier chainrpc.ChainNotifierClient
+	store         Store
+	lockupTx      LockupTx
+}
+
+// NewServer returns a server watching the transactions until ctx is done.
+func NewServer(ctx context.Context, macaroonHex string, client LightningClient, chainNotifier chainrpc.ChainNotifierClient,
+	store Store, lockupTx LockupTx) *Server {
+	return &Server{
+		ctx:           ctx,
+		macaroonHex:   macaroonHex,
+		client:        client,
+		chainNotifier: chainNotifier,
+		store:         store,
+		lockupTx:      lockupTx,
+	}
+}
+
+// Wait waits for the goroutines watching the transactions to return once the
+// context of the server is done.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// RegisterPast watches again the transactions registered before the server
+// started and not confirmed yet.
+func (s *Server) RegisterPast(ctx context.Context) error {
+	clientCtx := metadata.AppendToOutgoingContext(ctx, "macaroon", s.macaroonHex)
+	chainInfo, err := s.client.GetInfo(clientCtx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		log.Printf("client.GetInfo(): %v", err)
+		return fmt.Errorf("client.GetInfo(): %w", err)
+	}
+	notifications, err := s.store.BoltzReverseSwapToNotify(chainInfo.BlockHeight)
+	if err != nil {
+		log.Printf("boltzReverseSwapToNotify(%v): %v", chainInfo.BlockHeight, err)
+		return fmt.Errorf("boltzReverseSwapToNotify(%v): %w", chainInfo.BlockHeight, err)
+	}
+	for _, n := range notifications {
+		log.Printf("u: %v, BoltzId: %v, TimeoutBlockHeight: %v, title: %v, body: %v, deviceID: %v, txHash: %x, script: %x, blockHeightHint: %v",
+			n.ID.String(), n.BoltzReverseSwapInfo.ID, n.BoltzReverseSwapInfo.TimeoutBlockHeight, n.Title, n.Body, logging.Secret(n.DeviceID), n.TxHash, n.Script, n.BlockHeightHint)
+		_, err := s.registerTxNotification(&n.ID, &breez.PushTxNotificationRequest{
+			DeviceId:        n.DeviceID,
+			Title:           n.Title,
+			Body:            n.Body,
+			TxHash:          n.TxHash,
+			Script:          n.Script,
+			BlockHeightHint: n.BlockHeightHint,
+			Info: &breez.PushTxNotificationRequest_BoltzReverseSwapLockupTxInfo{
+				BoltzReverseSwapLockupTxInfo: &breez.BoltzReverseSwapLockupTx{
+					BoltzId:            n.BoltzReverseSwapInfo.ID,
+					TimeoutBlockHeight: n.BoltzReverseSwapInfo.TimeoutBlockHeight,
+				},
+			}},
+		)
+		if err != nil {
+			log.Printf("registerTxNotification(%v): %v)", n.ID.String(), err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) RegisterTxNotification(ctx context.Context, in *breez.PushTxNotificationRequest) (*breez.PushTxNotificationResponse, error) {
+	return s.registerTxNotification(nil, in)
+}
+
+func hashString(h []byte) string {
+	ch, err := chainhash.NewHash(h)
+	if err != nil {
+		return ""
+	}
+	return ch.String()
+}
+
+func (s *Server) callFromBlockHeight(f func(), blockHeight uint32) {
+	cancellableCtx, cancel := context.WithCancel(s.ctx)
+	clientCtx := metadata.AppendToOutgoingContext(cancellableCtx, "macaroon", s.macaroonHex)
+	stream, err := s.chainNotifier.RegisterBlockEpochNtfn(clientCtx, &chainrpc.BlockEpoch{})
+	if err != nil {
+		log.Printf("chainNotifierClient.RegisterBlockEpochNtfn(): %v", err)
+		cancel()
+		return
+	}
+	s.wg.Go(func() {
+		for {
+			block, err := stream.Recv()
+			if err != nil {
+				log.Printf("stream.Recv: %v", err)
+				return
+			}
+			if block.Height >= blockHeight {
+				log.Printf("callFromBlockHeight: calling f() because: %v >= %v", block.Height, blockHeight)
+				f()
+				break
+			}
+		}
+		cancel()
+	})
+}
+
+func (s *Server) registerTxNotification(u *uuid.UUID, in *breez.PushTxNotificationRequest) (*breez.PushTxNotificationResponse, error) {
+	var txType int32
+	var boltzReverseSwapInfo *store.BoltzReverseSwapInfo
+	switch x := in.Info.(type) {
+	case *breez.PushTxNotificationRequest_BoltzReverseSwapLockupTxInfo:
+		boltzReverseSwapInfo = &store.BoltzReverseSwapInfo{
+			ID:                 x.BoltzReverseSwapLockupTxInfo.BoltzId,
+			TimeoutBlockHeight: x.BoltzReverseSwapLockupTxInfo.TimeoutBlockHeight,
+		}
+		txType = store.TypeBoltzReverseSwapLockup
+	default:
+		txType = store.TypeUnknown
+	}
+	if txType == store.TypeUnknown {
+		return nil, rpcerror.InvalidArgument("info", "only boltz reverse swap lockup transactions supported")
+	}
+	var err error
+	if u == nil {
+		u, err = s.store.InsertTxNotification(in)
+		if err != nil {
+			return nil, rpcerror.New(codes.Unavailable, rpcerror.ReasonStorageUnavailable,
+				"Failed to register the notification").Wrap(err)
+		}
+		if u == nil {
+			return &breez.PushTxNotificationResponse{}, nil
+		}
+	}
+	confRequest := &chainrpc.ConfRequest{
+		NumConfs:   1,
+		HeightHint: in.BlockHeightHint,
+		Txid:       in.TxHash,
+		Script:     in.Script,
+	}
+	cancellableCtx, cancel := context.WithCancel(s.ctx)
+	clientCtx := metadata.AppendToOutgoingContext(cancellableCtx, "macaroon", s.macaroonHex)
+	stream, err := s.chainNotifier.RegisterConfirmationsNtfn(clientCtx, confRequest)
+	if err != nil {
+		log.Printf("chainNotifierClient.RegisterConfirmationsNtfn(%#v): %v", confRequest, err)
+		cancel()
+		return nil, fmt.Errorf("chainNotifierClient.RegisterConfirmationsNtfn(%#v): %w", confRequest, err)
+	}
+	s.wg.Go(func() {
+		defer cancel()
+		var confDetails chainrpc.ConfDetails
+		for {
+			confEvent, err := stream.Recv()
+			if err != nil {
+				log.Printf("stream.Recv(): %v", err)
+				return
+			}
+			confDetails = *confEvent.GetConf()
+			log.Printf("UUID: %v block: (%v) %v, index: %v rawTX:%x", u,
+				confDetails.BlockHeight, hashString(confDetails.BlockHash), confDetails.TxIndex, confDetails.RawTx)
+			break
+		}
+		if txType == store.TypeBoltzReverseSwapLockup {
+			tx, err := s.lockupTx(boltzReverseSwapInfo.ID)
+			if err != nil {
+				log.Printf("boltz.GetTransaction(%v): %v", boltzReverseSwapInfo.ID, err)
+				return
+			}
+			if hex.EncodeToString(confDetails.RawTx) != tx {
+				log.Printf("bad transaction: %x != %v", confDetails.RawTx, tx)
+				return
+			}
+		}
+		tx, _ := btcutil.NewTxFromBytes(confDetails.RawTx)
+		var txHash chainhash.Hash
+		if tx != nil {
+			txHash = *tx.Hash()
+		}
+
+		err := s.store.TxNotified(*u, txHash, confDetails.RawTx, confDetails.BlockHeight, confDetails.BlockHash, confDetails.TxIndex, &store.OutboxNotification{
+			Source: "push_tx",
+			Kind:   store.OutboxAlert,
+			Type:   notify.TypePushTx,
+			Token:  in.DeviceId,
+			Title:  in.Title,
+			Body:   in.Body,
+		})
+		log.Printf("txNotified(%v, %v, %x, %v, %x, %v): %v", *u, txHash.String(), confDetails.RawTx, confDetails.BlockHeight, confDetails.BlockHash, confDetails.TxIndex, err)
+	})
+	if txType == store.TypeBoltzReverseSwapLockup {
+		s.callFromBlockHeight(cancel, boltzReverseSwapInfo.TimeoutBlockHeight)
+	}
+	return &breez.PushTxNotificationResponse{}, nil
+}
@@ -0,0 +1,173 @@
+package validate
+
+import (
+	"fmt"
+
+	"github.com/breez/server/breez"
+)
+
+const (
+	maxNameLength    = 256
+	maxTextLength    = 1024
+	maxAddresses     = 1000
+	maxLogoSize      = 1 << 20
+	maxBlobSize      = 64 << 10
+	maxValueSize     = 16 << 10
+	maxScriptSize    = 10000
+	maxNodeInfoKey   = 64
+	maxPartyName     = 64
+	signatureLength  = 64
+	maxInactiveDays  = 3650
+	maxSatPerByte    = 100000
+	maxTargetConf    = 1008
+	maxSignedURLPart = 8192
+)
+
+// breezRules are the rules of the breez.proto requests. The optional fields
+// are checked when they are set.
+func (v *Validator) breezRules() {
+	add(v, func(in *breez.PaymentRequest, vs *violations) {
+		vs.token(in.BreezID, "breezID")
+		if vs.required(in.Invoice, "invoice") {
+			v.bolt11(vs, in.Invoice, "invoice")
+		}
+		vs.text(in.Payee, "payee", maxNameLength)
+		vs.check(in.Amount >= 0, "amount", "must not be negative")
+	})
+	add(v, func(in *breez.RegisterRequest, vs *violations) {
+		vs.token(in.DeviceID, "deviceID")
+		if in.LightningID != "" {
+			vs.nodeID(in.LightningID, "lightningID")
+		}
+	})
+	add(v, func(in *breez.OrderRequest, vs *violations) {
+		vs.text(in.FullName, "FullName", maxNameLength)
+		vs.text(in.Address, "Address", maxNameLength)
+		vs.text(in.City, "City", maxNameLength)
+		vs.text(in.State, "State", maxNameLength)
+		vs.text(in.Zip, "Zip", maxNameLength)
+		vs.text(in.Country, "Country", maxNameLength)
+		vs.text(in.Email, "Email", maxNameLength)
+	})
+	add(v, func(in *breez.UploadFileRequest, vs *violations) {
+		vs.bytes(in.Content, "content", maxLogoSize)
+	})
+	add(v, func(in *breez.UpdateChannelPolicyRequest, vs *violations) {
+		vs.nodeID(in.PubKey, "pubKey")
+	})
+	add(v, func(in *breez.AddFundInitRequest, vs *violations) {
+		vs.nodeID(in.NodeID, "nodeID")
+		if in.NotificationToken != "" {
+			vs.token(in.NotificationToken, "notificationToken")
+		}
+		vs.pubkey(in.Pubkey, "pubkey")
+		vs.hash(in.Hash, "hash")
+	})
+	add(v, func(in *breez.AddFundStatusRequest, vs *violations) {
+		vs.check(len(in.Addresses) <= maxAddresses, "addresses", fmt.Sprintf("must have at most %v addresses", maxAddresses))
+		for i, a := range in.Addresses {
+			v.address(vs, a, fmt.Sprintf("addresses[%v]", i))
+		}
+		if in.NotificationToken != "" {
+			vs.token(in.NotificationToken, "notificationToken")
+		}
+	})
+	add(v, func(in *breez.RemoveFundRequest, vs *violations) {
+		if vs.required(in.Address, "address") {
+			v.address(vs, in.Address, "address")
+		}
+		vs.check(in.Amount > 0, "amount", "must be positive")
+	})
+	add(v, func(in *breez.RedeemRemovedFundsRequest, vs *violations) {
+		vs.hexHash(in.Paymenthash, "paymenthash")
+	})
+	add(v, func(in *breez.GetSwapPaymentRequest, vs *violations) {
+		if vs.required(in.PaymentRequest, "paymentRequest") {
+			v.bolt11(vs, in.PaymentRequest, "paymentRequest")
+		}
+	})
+	add(v, func(in *breez.RegisterTransactionConfirmationRequest, vs *violations) {
+		vs.hexHash(in.TxID, "txID")
+		vs.token(in.NotificationToken, "notificationToken")
+		vs.enum(in.NotificationType, "notificationType")
+	})
+	add(v, func(in *breez.RedeemSwapPaymentRequest, vs *violations) {
+		vs.hash(in.Preimage, "preimage")
+		vs.check(in.TargetConf >= 0 && in.TargetConf <= maxTargetConf, "target_conf", fmt.Sprintf("must be between 0 and %v", maxTargetConf))
+		vs.check(in.SatPerByte >= 0 && in.SatPerByte <= maxSatPerByte, "sat_per_byte", fmt.Sprintf("must be between 0 and %v", maxSatPerByte))
+	})
+	add(v, func(in *breez.LSPListRequest, vs *violations) {
+		if in.Pubkey != "" {
+			vs.nodeID(in.Pubkey, "pubkey")
+		}
+	})
+	add(v, func(in *breez.LSPFullListRequest, vs *violations) {
+		if in.Pubkey != "" {
+			vs.nodeID(in.Pubkey, "pubkey")
+		}
+	})
+	add(v, func(in *breez.RegisterPaymentRequest, vs *violations) {
+		vs.required(in.LspId, "lsp_id")
+		vs.bytes(in.Blob, "blob", maxBlobSize)
+	})
+	add(v, func(in *breez.CheckChannelsRequest, vs *violations) {
+		vs.required(in.LspId, "lsp_id")
+		vs.bytes(in.Blob, "blob", maxBlobSize)
+	})
+	add(v, func(in *breez.RegisterPaymentNotificationRequest, vs *violations) {
+		vs.required(in.LspId, "lsp_id")
+		vs.bytes(in.Blob, "blob", maxBlobSize)
+	})
+	add(v, func(in *breez.RemovePaymentNotificationRequest, vs *violations) {
+		vs.required(in.LspId, "lsp_id")
+		vs.bytes(in.Blob, "blob", maxBlobSize)
+	})
+	add(v, func(in *breez.JoinCTPSessionRequest, vs *violations) {
+		vs.enum(in.PartyType, "partyType")
+		if vs.required(in.PartyName, "partyName") {
+			vs.text(in.PartyName, "partyName", maxPartyName)
+		}
+		if in.NotificationToken != "" {
+			vs.token(in.NotificationToken, "notificationToken")
+		}
+		if in.SessionID != "" {
+			vs.uuid(in.SessionID, "sessionID")
+		}
+	})
+	add(v, func(in *breez.TerminateCTPSessionRequest, vs *violations) {
+		vs.uuid(in.SessionID, "sessionID")
+	})
+	add(v, func(in *breez.SetNodeInfoRequest, vs *violations) {
+		vs.pubkey(in.Pubkey, "pubkey")
+		if vs.required(in.Key, "key") {
+			vs.text(in.Key, "key", maxNodeInfoKey)
+		}
+		vs.check(len(in.Value) <= maxValueSize, "value", fmt.Sprintf("must be at most %v bytes", maxValueSize))
+		vs.check(len(in.Signature) == signatureLength, "signature", fmt.Sprintf("must be %v bytes", signatureLength))
+	})
+	add(v, func(in *breez.GetNodeInfoRequest, vs *violations) {
+		vs.pubkey(in.Pubkey, "pubkey")
+		if vs.required(in.Key, "key") {
+			vs.text(in.Key, "key", maxNodeInfoKey)
+		}
+	})
+	add(v, func(in *breez.RegisterPeriodicSyncRequest, vs *violations) {
+		vs.token(in.NotificationToken, "notificationToken")
+	})
+	add(v, func(in *breez.PushTxNotificationRequest, vs *violations) {
+		vs.token(in.DeviceId, "device_id")
+		vs.text(in.Title, "title", maxNameLength)
+		vs.text(in.Body, "body", maxTextLength)
+		vs.hash(in.TxHash, "tx_hash")
+		vs.bytes(in.Script, "script", maxScriptSize)
+		vs.check(in.Info != nil, "info", "must be set")
+	})
+	add(v, func(in *breez.InactiveNotifyRequest, vs *violations) {
+		vs.pubkey(in.Pubkey, "pubkey")
+		vs.check(in.Days > 0 && in.Days <= maxInactiveDays, "days", fmt.Sprintf("must be between 1 and %v", maxInactiveDays))
+	})
+	add(v, func(in *breez.SignUrlRequest, vs *violations) {
+		vs.required(in.BaseUrl, "baseUrl")
+		vs.check(len(in.QueryString) <= maxSignedURLPart, "queryString", fmt.Sprintf("must be at most %v bytes", maxSignedURLPart))
+	})
+}
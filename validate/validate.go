@@ -0,0 +1,222 @@
+// Package validate checks the requests of the grpc services against the rules
+// of their messages before they reach the handlers, and rejects the invalid
+// ones with an InvalidArgument error naming their invalid fields. The rules
+// are declared in rules.go, by message, with the field names of breez.proto.
+package validate
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/breez/server/rpcerror"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/google/uuid"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Validator validates the requests of the messages with rules.
+type Validator struct {
+	network *chaincfg.Params
+	rules   map[protoreflect.FullName]func(proto.Message, *violations)
+}
+
+// New returns the validator of the breez.proto requests. The addresses and
+// the payment requests must be of network.
+func New(network *chaincfg.Params) *Validator {
+	v := &Validator{
+		network: network,
+		rules:   make(map[protoreflect.FullName]func(proto.Message, *violations)),
+	}
+	v.breezRules()
+	return v
+}
+
+// add sets the rule of the messages of type T.
+func add[T proto.Message](v *Validator, rule func(in T, vs *violations)) {
+	var m T
+	v.rules[m.ProtoReflect().Descriptor().FullName()] = func(m proto.Message, vs *violations) {
+		rule(m.(T), vs)
+	}
+}
+
+// Validate returns the InvalidArgument error of m when it breaks the rule of
+// its message. The messages without a rule are valid.
+func (v *Validator) Validate(m proto.Message) error {
+	rule, ok := v.rules[m.ProtoReflect().Descriptor().FullName()]
+	if !ok {
+		return nil
+	}
+	var vs violations
+	rule(m, &vs)
+	if len(vs.fields) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(vs.fields))
+	for i, field := range vs.fields {
+		msgs[i] = field + " " + vs.descriptions[i]
+	}
+	err := rpcerror.New(codes.InvalidArgument, rpcerror.ReasonInvalidArgument, "invalid request: "+strings.Join(msgs, "; "))
+	for i, field := range vs.fields {
+		err = err.WithViolation(field, vs.descriptions[i])
+	}
+	return err
+}
+
+// UnaryServerInterceptor rejects the invalid requests.
+func (v *Validator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m, ok := req.(proto.Message); ok {
+			if err := v.Validate(m); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the invalid messages of the streams.
+func (v *Validator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, v: v})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	v *Validator
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if m, ok := m.(proto.Message); ok {
+		return s.v.Validate(m)
+	}
+	return nil
+}
+
+// violations are the invalid fields of a request.
+type violations struct {
+	fields       []string
+	descriptions []string
+}
+
+// check adds the violation of field when ok is false.
+func (vs *violations) check(ok bool, field, description string) {
+	if !ok {
+		vs.fields = append(vs.fields, field)
+		vs.descriptions = append(vs.descriptions, description)
+	}
+}
+
+// required checks that the string field s is set.
+func (vs *violations) required(s, field string) bool {
+	vs.check(s != "", field, "must be set")
+	return s != ""
+}
+
+// text checks that s is printable text of at most max bytes.
+func (vs *violations) text(s, field string, max int) {
+	vs.check(len(s) <= max, field, fmt.Sprintf("must be at most %v bytes", max))
+	vs.check(printable(s), field, "must be printable text")
+}
+
+// token checks the notification token or device ID s.
+func (vs *violations) token(s, field string) {
+	if vs.required(s, field) {
+		vs.check(len(s) <= maxTokenLength && ascii(s), field, "must be a notification token")
+	}
+}
+
+// nodeID checks that s is the hex encoded public key of a node.
+func (vs *violations) nodeID(s, field string) {
+	b, err := hex.DecodeString(s)
+	vs.check(err == nil && isPubkey(b), field, "must be a hex encoded node public key")
+}
+
+// pubkey checks that b is a compressed public key.
+func (vs *violations) pubkey(b []byte, field string) {
+	vs.check(isPubkey(b), field, "must be a compressed public key")
+}
+
+// hash checks that b is a 32 bytes hash.
+func (vs *violations) hash(b []byte, field string) {
+	vs.check(len(b) == 32, field, "must be 32 bytes")
+}
+
+// hexHash checks that s is a hex encoded 32 bytes hash.
+func (vs *violations) hexHash(s, field string) {
+	b, err := hex.DecodeString(s)
+	vs.check(err == nil && len(b) == 32, field, "must be 64 hex characters")
+}
+
+// uuid checks that s is a UUID.
+func (vs *violations) uuid(s, field string) {
+	_, err := uuid.Parse(s)
+	vs.check(err == nil, field, "must be a UUID")
+}
+
+// bytes checks that b is set and has at most max bytes.
+func (vs *violations) bytes(b []byte, field string, max int) {
+	vs.check(len(b) > 0, field, "must be set")
+	vs.check(len(b) <= max, field, fmt.Sprintf("must be at most %v bytes", max))
+}
+
+// enum checks that e is a value of its enum.
+func (vs *violations) enum(e protoreflect.Enum, field string) {
+	vs.check(e.Descriptor().Values().ByNumber(e.Number()) != nil, field, "must be a defined value")
+}
+
+// address checks that s is a bitcoin address of the network.
+func (v *Validator) address(vs *violations, s, field string) {
+	addr, err := btcutil.DecodeAddress(s, v.network)
+	vs.check(err == nil && addr.IsForNet(v.network), field, "must be a bitcoin address of "+v.network.Name)
+}
+
+// bolt11 checks that s is a BOLT11 payment request of the network.
+func (v *Validator) bolt11(vs *violations, s, field string) {
+	_, err := zpay32.Decode(s, v.network)
+	vs.check(err == nil, field, "must be a BOLT11 payment request of "+v.network.Name)
+}
+
+const maxTokenLength = 4096
+
+func isPubkey(b []byte) bool {
+	if len(b) != btcec.PubKeyBytesLenCompressed {
+		return false
+	}
+	_, err := btcec.ParsePubKey(b)
+	return err == nil
+}
+
+func printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+func ascii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '!' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -11,7 +11,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/breez/server/breez"
@@ -87,25 +86,14 @@ func (s *server) Ping(ctx context.Context, in *breez.PingRequest) (*breez.PingRe
 }
 
 func (s *server) BreezAppVersions(ctx context.Context, in *breez.BreezAppVersionsRequest) (*breez.BreezAppVersionsReply, error) {
-	rows, err := breezAppVersion()
+	versions, err := s.db.BreezAppVersions()
 	if err != nil {
 		log.Printf("breezAppVersion(): %v", err)
-		return nil, fmt.Errorf("breezAppVersion(): %w", err)
-	}
-	defer rows.Close()
-	var versions []string
-	for rows.Next() {
-		var version string
-		err = rows.Scan(&version)
-		if err != nil {
-			log.Printf("rows.Scan: %v", err)
-			continue
-		}
-		versions = append(versions, version)
+		return nil, err
 	}
 	return &breez.BreezAppVersionsReply{Version: versions}, nil
 }
 
 func (s *server) ReceiverInfo(ctx context.Context, in *breez.ReceiverInfoRequest) (*breez.ReceiverInfoReply, error) {
-	return &breez.ReceiverInfoReply{Pubkey: os.Getenv("RECEIVER_NODE")}, nil
+	return &breez.ReceiverInfoReply{Pubkey: s.cfg.ReceiverNode}, nil
 }